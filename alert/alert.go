@@ -0,0 +1,200 @@
+// The purpose of this package is to tell somebody when compare finds a mismatch, instead of merely printing it where
+// nobody will see it until morning.
+//
+// We remember the mismatches found by each run of compare, or cycle of watch, in a small state file.  Comparing that
+// with the mismatches found now tells us which are new, which have lasted too long, and which have gone away.
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/atomicfile"
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// The state file to use if the config does not say otherwise.
+const DefaultStatePath = "okconnect.alerts"
+
+// The kinds of alert.
+const (
+	KindNew        = "new"        // These mismatches were not there last time.
+	KindPersistent = "persistent" // These mismatches have been there for AlertConfig.PersistentCycles in a row.
+	KindRecovered  = "recovered"  // These mismatches were there last time, but not anymore.  Comparisons shows them as they were.
+)
+
+// What we send to the notifiers.
+type Alert struct {
+	Kind        string               `json:"kind"`
+	Time        time.Time            `json:"time"`
+	Comparisons []compare.Comparison `json:"comparisons"`
+}
+
+// A Notifier knows how to tell somebody about an alert.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// What we remember about a mismatch between runs.
+type seen struct {
+	Cycles     int                // in a row
+	Comparison compare.Comparison // as it was last time
+}
+
+// Compare the given mismatches with those found last time and send whatever alerts that calls for to every configured
+// notifier.  If no notifier is configured then do nothing at all.
+//
+// An alert has been sent once any notifier has sent it.  If none of them could, then remember the state as though
+// that alert had never been due, so that it's due again next time, and return an error.
+func Check(cfg *config.Config, mismatches []compare.Comparison) error {
+	notifiers := Notifiers(cfg.AlertConfig)
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	path := cfg.AlertConfig.State
+	if path == "" {
+		path = DefaultStatePath
+	}
+
+	// 1. What did we see last time?
+	previous, err := readState(path)
+	if err != nil {
+		return err
+	}
+
+	// 2. Sort the mismatches found now.
+	now := time.Now().UTC()
+	alerts := map[string]*Alert{
+		KindNew:        {Kind: KindNew, Time: now},
+		KindPersistent: {Kind: KindPersistent, Time: now},
+		KindRecovered:  {Kind: KindRecovered, Time: now},
+	}
+	keys := make(map[string][]string) // of the comparisons of each kind of alert
+
+	current := make(map[string]seen)
+	for _, m := range mismatches {
+		key := m.Key()
+		if m.Account != "" {
+			key = m.Account + ":" + key
+		}
+		s := seen{Cycles: previous[key].Cycles + 1, Comparison: m}
+		current[key] = s
+
+		if s.Cycles == 1 {
+			alerts[KindNew].Comparisons = append(alerts[KindNew].Comparisons, m)
+			keys[KindNew] = append(keys[KindNew], key)
+		}
+		if s.Cycles == cfg.AlertConfig.PersistentCycles {
+			alerts[KindPersistent].Comparisons = append(alerts[KindPersistent].Comparisons, m)
+			keys[KindPersistent] = append(keys[KindPersistent], key)
+		}
+	}
+
+	for key, s := range previous {
+		if _, ok := current[key]; !ok {
+			alerts[KindRecovered].Comparisons = append(alerts[KindRecovered].Comparisons, s.Comparison)
+			keys[KindRecovered] = append(keys[KindRecovered], key)
+		}
+	}
+
+	// 3. Tell everybody.
+	var failed error
+	for _, kind := range []string{KindNew, KindPersistent, KindRecovered} {
+		a := alerts[kind]
+		if len(a.Comparisons) == 0 {
+			continue
+		}
+
+		sent := false
+		var notifyErr error
+		for _, n := range notifiers {
+			err := n.Notify(*a)
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{"kind": kind, "notifier": fmt.Sprintf("%T", n)}).Error("Cannot send the alert.")
+				notifyErr = err
+				continue
+			}
+			sent = true
+		}
+		if sent {
+			log.WithFields(log.Fields{"kind": kind, "mismatches": len(a.Comparisons)}).Info("Alert sent.")
+			continue
+		}
+
+		// Nobody heard about these, so forget that they happened.
+		failed = notifyErr
+		log.WithFields(log.Fields{"kind": kind, "mismatches": len(a.Comparisons)}).Error("No notifier could send the alert.  Will try again next time.")
+		for _, key := range keys[kind] {
+			switch kind {
+			case KindNew:
+				delete(current, key)
+			case KindPersistent:
+				if s, ok := current[key]; ok {
+					s.Cycles--
+					current[key] = s
+				}
+			case KindRecovered:
+				current[key] = previous[key]
+			}
+		}
+	}
+
+	// 4. Remember what we saw this time, or at least what we told somebody about.
+	err = writeState(path, current)
+	if err != nil {
+		log.WithError(err).WithField("state", path).Error("Cannot write the alert state file.")
+		return err
+	}
+
+	return failed
+}
+
+// Build a Notifier for every notifier that the config configures.
+func Notifiers(cfg config.AlertConfig) []Notifier {
+	notifiers := make([]Notifier, 0)
+	if cfg.Webhook.URL != "" {
+		notifiers = append(notifiers, WebhookNotifier{cfg.Webhook})
+	}
+	if cfg.SMTP.Addr != "" {
+		notifiers = append(notifiers, SMTPNotifier{cfg.SMTP})
+	}
+	if cfg.Exec.Command != "" {
+		notifiers = append(notifiers, ExecNotifier{cfg.Exec})
+	}
+	return notifiers
+}
+
+func readState(path string) (map[string]seen, error) {
+	state := make(map[string]seen)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("state", path).Error("Cannot read the alert state file.")
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		log.WithError(err).WithField("state", path).Error("Cannot parse the alert state file.")
+		return nil, err
+	}
+	return state, nil
+}
+
+// Write the file, replacing the existing file only after the new one is safely on the disk.
+func writeState(path string, state map[string]seen) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(path, data, 0600)
+}
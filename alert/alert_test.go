@@ -0,0 +1,119 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+// A webhook that remembers the kinds of the alerts that it's sent, and that can be told to fail.
+type webhook struct {
+	mu    sync.Mutex
+	kinds []string
+	fail  bool
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fail {
+		http.Error(rw, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	a := Alert{}
+	err := json.NewDecoder(req.Body).Decode(&a)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.kinds = append(w.kinds, a.Kind)
+}
+
+// What kinds of alert were sent since last asked?
+func (w *webhook) sent() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	kinds := w.kinds
+	w.kinds = nil
+	return kinds
+}
+
+func same(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheck(t *testing.T) {
+	w := &webhook{}
+	server := httptest.NewServer(w)
+	defer server.Close()
+
+	cfg := &config.Config{AlertConfig: config.AlertConfig{
+		State:            filepath.Join(t.TempDir(), "okconnect.alerts"),
+		PersistentCycles: 3,
+		Webhook:          config.WebhookConfig{URL: server.URL},
+	}}
+	mismatch := compare.Comparison{
+		Category:        "F",
+		CurrencySymbol:  "BTC",
+		OKExBalance:     compare.MaybeBalance{Balance: decimal.New(2, 0)},
+		BookwerxBalance: compare.MaybeBalance{Balance: decimal.New(1, 0)},
+	}
+	some := []compare.Comparison{mismatch}
+
+	steps := []struct {
+		name       string
+		mismatches []compare.Comparison
+		fail       bool
+		want       []string
+		wantErr    bool
+	}{
+		{"new", some, false, []string{KindNew}, false},
+		{"still there", some, false, nil, false},
+		{"persistent", some, false, []string{KindPersistent}, false},
+		{"still persistent", some, false, nil, false},
+		{"recovered", nil, false, []string{KindRecovered}, false},
+		{"nothing", nil, false, nil, false},
+
+		// A new mismatch that nobody hears about is still new next time.
+		{"new but down", some, true, nil, true},
+		{"new again", some, false, []string{KindNew}, false},
+		{"there again", some, false, nil, false},
+
+		// ... and so is a persistent one.
+		{"persistent but down", some, true, nil, true},
+		{"persistent again", some, false, []string{KindPersistent}, false},
+
+		// ... and so is a recovery.
+		{"recovered but down", nil, true, nil, true},
+		{"recovered again", nil, false, []string{KindRecovered}, false},
+	}
+
+	for _, step := range steps {
+		w.mu.Lock()
+		w.fail = step.fail
+		w.mu.Unlock()
+
+		err := Check(cfg, step.mismatches)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: Check returned %v", step.name, err)
+		}
+		if got := w.sent(); !same(got, step.want) {
+			t.Errorf("%s: sent %v, want %v", step.name, got, step.want)
+		}
+	}
+}
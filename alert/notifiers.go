@@ -0,0 +1,124 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Don't let a notifier hold up the next cycle for long.
+const notifyTimeout = 10 * time.Second
+
+// POST the alert as JSON.
+type WebhookNotifier struct {
+	cfg config.WebhookConfig
+}
+
+func (n WebhookNotifier) Notify(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	client := okchttp.GetHTTPClient("webhook", n.cfg.URL)
+	client.Timeout = notifyTimeout
+
+	resp, err := client.Post(n.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("alert:notifiers.go:WebhookNotifier: Status code error: expected= 2xx, received=%d, body=%s", resp.StatusCode, string(respBody)))
+	}
+	return nil
+}
+
+// Email the alert, with the JSON in the body.
+type SMTPNotifier struct {
+	cfg config.SMTPConfig
+}
+
+func (n SMTPNotifier) Notify(a Alert) error {
+	body, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("okconnect: %d %s mismatch", len(a.Comparisons), a.Kind)
+	if len(a.Comparisons) != 1 {
+		subject += "es"
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "Content-Type: application/json; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "\r\n")
+	msg.Write(body)
+	fmt.Fprintf(msg, "\r\n")
+
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(n.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+
+	return smtp.SendMail(n.cfg.Addr, auth, n.cfg.From, n.cfg.To, msg.Bytes())
+}
+
+// Run a command, without a shell, with the alert as JSON on stdin.  The kind of alert is also in
+// $OKCONNECT_ALERT_KIND, for the convenience of scripts that don't want to parse the JSON.
+type ExecNotifier struct {
+	cfg config.ExecConfig
+}
+
+func (n ExecNotifier) Notify(a Alert) error {
+	args := strings.Fields(n.cfg.Command)
+	if len(args) == 0 {
+		return errors.New("there is no command to execute")
+	}
+
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "OKCONNECT_ALERT_KIND="+a.Kind)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(notifyTimeout):
+		_ = cmd.Process.Kill()
+		return errors.New(fmt.Sprintf("alert:notifiers.go:ExecNotifier: %s took longer than %s", args[0], notifyTimeout))
+	}
+}
@@ -0,0 +1,36 @@
+// The purpose of this package is to write the small state files that okconnect keeps, such as the cursors, the lots,
+// and the alert state, so that a crash in the middle of writing one never leaves half a file behind.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write the data to the named file, just as ioutil.WriteFile does, except that the data is first written to a
+// temporary file in the same directory, synced, and only then renamed over the file.  So the file is either what it
+// was or else all of the new data.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
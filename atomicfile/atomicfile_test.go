@@ -0,0 +1,45 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "state")
+
+	for _, data := range []string{"first", "second"} {
+		err := WriteFile(filename, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("the file says %q, want %q", got, data)
+		}
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("the file mode is %v, want 0600", info.Mode().Perm())
+	}
+
+	// Nothing temporary is left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("the directory has %d files, want 1", len(files))
+	}
+}
@@ -0,0 +1,419 @@
+// The purpose of this package is to hold items required to communicate with a bookwerx server.
+// These items are also present in okcatbox and ought to be factored out.
+package bookwerx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bwapi "github.com/bostontrader/bookwerx-common-go"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/gojektech/heimdall/httpclient"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The bookwerx-core server will on occasion return JSON names that contain a '.'.  This vile habit
+// causes trouble here.
+// A good, bad, or ugly hack is to simply change the . to a -.  Do that here.
+func FixDot(b []byte) {
+	for i, num := range b {
+		if num == 46 { // .
+			b[i] = 45 // -
+		}
+	}
+}
+
+// The format of the times that Bookwerx keeps.  Every time has exactly three decimal places so that Bookwerx, which
+// compares them as strings, puts them in the right order.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+type AId struct {
+	Id uint32 `json:"accounts-id"`
+}
+
+type LID struct {
+	LastInsertID uint32
+}
+
+type TId struct {
+	Id uint32 `json:"transactions-id"`
+}
+
+// Given a response object, read the body and return it as a string.  Deal with the error message if necessary.
+func bodyString(resp *http.Response) string {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Sprintf("Cannot read the body: %v", err)
+	}
+	return string(body)
+}
+
+func CreateDistribution(client *httpclient.Client, accountId uint32, amount DFP, txid uint32, cfg config.Config) (did uint32, err error) {
+
+	url1 := fmt.Sprintf("%s/distributions", cfg.BookwerxConfig.BaseURL)
+	url2 := fmt.Sprintf("apikey=%s&account_id=%d&amount=%d&amount_exp=%d&transaction_id=%d",
+		cfg.BookwerxConfig.APIKey, accountId, amount.Amount, amount.Exp, txid)
+
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"application/x-www-form-urlencoded"}
+
+	resp, err := client.Post(url1, bytes.NewBuffer([]byte(url2)), h)
+	if err != nil {
+		log.WithError(err).WithField("func", "CreateDistribution").Error("The Bookwerx request failed.")
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body := bodyString(resp)
+		log.WithFields(log.Fields{"func": "CreateDistribution", "status": resp.StatusCode, "body": body}).Error("Bookwerx did not like the request.")
+		return 0, errors.New(fmt.Sprintf("bookwerx: expected status=200, received=%d, body=%s", resp.StatusCode, body))
+	}
+
+	var insert LID
+	dec := json.NewDecoder(resp.Body)
+	err = dec.Decode(&insert)
+	if err != nil {
+		log.WithError(err).WithField("func", "CreateDistribution").Error("Cannot decode the Bookwerx response.")
+		return 0, err
+	}
+
+	return insert.LastInsertID, nil
+}
+
+func CreateTransaction(client *httpclient.Client, time string, notes string, cfg config.Config) (txid uint32, err error) {
+
+	url1 := fmt.Sprintf("%s/transactions", cfg.BookwerxConfig.BaseURL)
+	url2 := fmt.Sprintf("apikey=%s&notes=%s&time=%s", cfg.BookwerxConfig.APIKey, url.QueryEscape(notes), url.QueryEscape(time))
+
+	h := make(map[string][]string)
+	h["Content-Type"] = []string{"application/x-www-form-urlencoded"}
+
+	resp, err := client.Post(url1, bytes.NewBuffer([]byte(url2)), h)
+	if err != nil {
+		log.WithError(err).WithField("func", "CreateTransaction").Error("The Bookwerx request failed.")
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body := bodyString(resp)
+		log.WithFields(log.Fields{"func": "CreateTransaction", "status": resp.StatusCode, "body": body}).Error("Bookwerx did not like the request.")
+		return 0, errors.New(fmt.Sprintf("bookwerx: expected status=200, received=%d, body=%s", resp.StatusCode, body))
+	}
+
+	var insert LID
+	dec := json.NewDecoder(resp.Body)
+	err = dec.Decode(&insert)
+	if err != nil {
+		log.WithError(err).WithField("func", "CreateTransaction").Error("Cannot decode the Bookwerx response.")
+		return 0, err
+	}
+	txid = insert.LastInsertID
+
+	return txid, nil
+}
+
+// Delete a single distribution.
+func DeleteDistribution(client *httpclient.Client, did uint32, cfg config.Config) error {
+	return deleteRecord(client, "distribution", did, cfg)
+}
+
+// Delete a single transaction.  Bookwerx won't do this until its distributions are gone.
+func DeleteTransaction(client *httpclient.Client, txid uint32, cfg config.Config) error {
+	return deleteRecord(client, "transaction", txid, cfg)
+}
+
+func deleteRecord(client *httpclient.Client, what string, id uint32, cfg config.Config) error {
+	url1 := fmt.Sprintf("%s/%s/%d?apikey=%s", cfg.BookwerxConfig.BaseURL, what, id, cfg.BookwerxConfig.APIKey)
+
+	resp, err := client.Delete(url1, nil)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"func": "deleteRecord", "what": what}).Error("The Bookwerx request failed.")
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := bodyString(resp)
+	if resp.StatusCode != 200 {
+		log.WithFields(log.Fields{"func": "deleteRecord", "what": what, "status": resp.StatusCode, "body": body}).Error("Bookwerx did not like the request.")
+		return errors.New(fmt.Sprintf("bookwerx: expected status=200, received=%d, body=%s", resp.StatusCode, body))
+	}
+
+	// Bookwerx says 200 even when it refuses, so look for an error in the body.
+	var result struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal([]byte(body), &result)
+	if result.Error != "" {
+		log.WithFields(log.Fields{"func": "deleteRecord", "what": what, "body": body}).Error("Bookwerx refused to delete.")
+		return errors.New(fmt.Sprintf("bookwerx: cannot delete %s %d: %s", what, id, result.Error))
+	}
+
+	return nil
+}
+
+// Find the ids of all transactions whose notes contain the given text, such as a client_oid.
+func FindTransactionsByNotes(client *httpclient.Client, text string, cfg config.Config) ([]uint32, error) {
+
+	// Requests cannot have spaces and QueryEscape would give us + instead, so use %20.
+	query := fmt.Sprintf("SELECT transactions.id FROM transactions WHERE transactions.notes LIKE '%%%s%%' ESCAPE '!'", escapeLike(text))
+	query = strings.ReplaceAll(url.QueryEscape(query), "+", "%20")
+	url1 := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
+
+	body, err := bwapi.Get(client, url1)
+	if err != nil {
+		log.WithError(err).WithField("func", "FindTransactionsByNotes").Error("The Bookwerx request failed.")
+		return nil, err
+	}
+	FixDot(body)
+
+	n := make([]TId, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n)
+	if err != nil {
+		log.WithError(err).WithField("func", "FindTransactionsByNotes").Error("Cannot decode the Bookwerx response.")
+		return nil, err
+	}
+
+	retVal := make([]uint32, 0, len(n))
+	for _, tid := range n {
+		retVal = append(retVal, tid.Id)
+	}
+	return retVal, nil
+}
+
+// Make the given text safe to put inside '%...%' ESCAPE '!', where it matches only itself.
+var likeEscaper = strings.NewReplacer("'", "''", "!", "!!", "%", "!%", "_", "!_")
+
+func escapeLike(text string) string {
+	return likeEscaper.Replace(text)
+}
+
+// A distribution of an account, along with the time and notes of its transaction.
+type AccountDistribution struct {
+	ID            uint32 `json:"distributions.id"`
+	AccountID     uint32 `json:"distributions.account_id,omitempty"`
+	Currency      string `json:"currencies.symbol,omitempty"`
+	Amount        int64  `json:"distributions.amount"`
+	AmountExp     int32  `json:"distributions.amount_exp"`
+	TransactionID uint32 `json:"transactions.id"`
+	Time          string `json:"transactions.time"`
+	Notes         string `json:"transactions.notes"`
+}
+
+// Find the newest distributions of the given account, newest first, no more than limit of them.  The time and the
+// notes may contain a '.' so don't FixDot them.  Name the fields as Bookwerx does instead.
+func RecentDistributions(client *httpclient.Client, accountID uint32, limit int, cfg config.Config) ([]AccountDistribution, error) {
+	query := fmt.Sprintf("SELECT distributions.id, distributions.amount, distributions.amount_exp, transactions.id, transactions.time, transactions.notes "+
+		"FROM distributions JOIN transactions ON transactions.id=distributions.transaction_id "+
+		"WHERE distributions.account_id=%d ORDER BY transactions.time DESC LIMIT %d", accountID, limit)
+	query = strings.ReplaceAll(url.QueryEscape(query), "+", "%20")
+	url1 := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
+
+	body, err := bwapi.Get(client, url1)
+	if err != nil {
+		log.WithError(err).WithField("func", "RecentDistributions").Error("The Bookwerx request failed.")
+		return nil, err
+	}
+
+	retVal := make([]AccountDistribution, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&retVal)
+	if err != nil {
+		log.WithError(err).WithField("func", "RecentDistributions").Error("Cannot decode the Bookwerx response.")
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// Find every distribution of every transaction whose notes contain the given text, along with the account and the
+// currency of each distribution.
+func FindDistributionsByNotes(client *httpclient.Client, text string, cfg config.Config) ([]AccountDistribution, error) {
+	query := fmt.Sprintf("SELECT distributions.id, distributions.account_id, currencies.symbol, distributions.amount, distributions.amount_exp, "+
+		"transactions.id, transactions.time, transactions.notes "+
+		"FROM distributions JOIN transactions ON transactions.id=distributions.transaction_id "+
+		"JOIN accounts ON accounts.id=distributions.account_id JOIN currencies ON currencies.id=accounts.currency_id "+
+		"WHERE transactions.notes LIKE '%%%s%%' ORDER BY transactions.time", text)
+	query = strings.ReplaceAll(url.QueryEscape(query), "+", "%20")
+	url1 := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
+
+	body, err := bwapi.Get(client, url1)
+	if err != nil {
+		log.WithError(err).WithField("func", "FindDistributionsByNotes").Error("The Bookwerx request failed.")
+		return nil, err
+	}
+
+	retVal := make([]AccountDistribution, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&retVal)
+	if err != nil {
+		log.WithError(err).WithField("func", "FindDistributionsByNotes").Error("Cannot decode the Bookwerx response.")
+		return nil, err
+	}
+	return retVal, nil
+}
+
+// Find the one account that is tagged with the given category and uses the given currency.
+func FindCategoryAccount(clientB *httpclient.Client, category uint32, currency string, cfg config.Config) (uint32, error) {
+
+	methodName := "okconnect:bookwerx.go:FindCategoryAccount"
+
+	selectt := "SELECT%20accounts.id"
+	from := "FROM%20accounts_categories"
+	join1 := "JOIN%20accounts%20ON%20accounts.id%3daccounts_categories.account_id"
+	join2 := "JOIN%20currencies%20ON%20currencies.id%3daccounts.currency_id"
+	where := fmt.Sprintf("WHERE%%20category_id%%3d%d%%20AND%%20currencies.symbol%%3d'%s'", category, currency)
+	query := fmt.Sprintf("%s%%20%s%%20%s%%20%s%%20%s", selectt, from, join1, join2, where)
+	url := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
+
+	body, err := bwapi.Get(clientB, url)
+	if err != nil {
+		log.WithError(err).Error("Cannot query the bookwerx accounts.")
+		return 0, err
+	}
+	FixDot(body)
+
+	n1 := make([]AId, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n1)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the bookwerx accounts.")
+		return 0, err
+	}
+
+	if len(n1) == 0 {
+		log.WithFields(log.Fields{"category": category, "currency": currency}).Error("Bookwerx does not have any account properly configured.")
+		return 0, fmt.Errorf("%s: no account has category %d and currency %s", methodName, category, currency)
+	} else if len(n1) > 1 {
+		log.WithFields(log.Fields{"category": category, "currency": currency}).Warn("Bookwerx has more than one suitable account.  This should never happen.")
+	}
+
+	return n1[0].Id, nil
+}
+
+// The margin instrument, such as BTC-USDT, that an account's title names, if any.  It's in capitals, just as OKEx
+// writes it, so that a title such as Margin-Borrowed names none.
+var titleInstrument = regexp.MustCompile(`\b[A-Z0-9]+-[A-Z0-9]+\b`)
+
+// Which margin instrument does the title of an account name?  OKEx keeps a margin account for each instrument, so
+// Bookwerx may have one too, such as "Margin BTC-USDT".  Return "" if the title names no instrument.
+func TitleInstrument(title string) string {
+	return titleInstrument.FindString(title)
+}
+
+// Find the account that is tagged with the given category, uses the given currency, and has a title that names the
+// given margin instrument.  If there's no such account then find the one whose title names no instrument at all.
+func FindInstrumentAccount(clientB *httpclient.Client, category uint32, currency string, instrument string, cfg config.Config) (uint32, error) {
+	query := fmt.Sprintf("SELECT accounts.id, accounts.title FROM accounts_categories "+
+		"JOIN accounts ON accounts.id=accounts_categories.account_id JOIN currencies ON currencies.id=accounts.currency_id "+
+		"WHERE category_id=%d AND currencies.symbol='%s'", category, currency)
+	query = strings.ReplaceAll(url.QueryEscape(query), "+", "%20")
+	url1 := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
+
+	body, err := bwapi.Get(clientB, url1)
+	if err != nil {
+		log.WithError(err).Error("Cannot query the bookwerx accounts.")
+		return 0, err
+	}
+
+	accounts := make([]struct {
+		Id    uint32 `json:"accounts.id"`
+		Title string `json:"accounts.title"`
+	}, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&accounts)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the bookwerx accounts.")
+		return 0, err
+	}
+
+	var retVal uint32
+	for _, a := range accounts {
+		switch TitleInstrument(a.Title) {
+		case strings.ToUpper(instrument):
+			return a.Id, nil
+		case "":
+			retVal = a.Id
+		}
+	}
+	if retVal == 0 {
+		log.WithFields(log.Fields{"category": category, "currency": currency, "instrument": instrument}).Error("Bookwerx does not have any account properly configured.")
+		return 0, fmt.Errorf("okconnect:bookwerx.go:FindInstrumentAccount: no account has category %d, currency %s, and instrument %s", category, currency, instrument)
+	}
+	return retVal, nil
+}
+
+type AccountCurrency struct {
+	AccountID uint32 `json:"account_id"`
+	Title     string
+	Currency  CurrencySymbol
+}
+
+type BalanceResultDecorated struct {
+	Account AccountCurrency
+	Sum     DFP
+}
+
+type CurrencySymbol struct {
+	CurrencyID uint32 `json:"currency_id"`
+	Symbol     string
+}
+
+type Sums struct {
+	Sums []BalanceResultDecorated
+}
+
+// Get the current balances of all accounts tagged with a list of categories from Bookwerx.
+func GetCategoryDistSums(url string) ([]BalanceResultDecorated, error) {
+
+	client := okchttp.GetHTTPClient("bookwerx", url)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		log.WithError(err).Error("Cannot build the Bookwerx request.")
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.WithError(err).Error("Cannot read the Bookwerx response.")
+		return nil, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		log.WithFields(log.Fields{"status": resp.StatusCode, "body": string(body)}).Error("Bookwerx did not like the request.")
+		return nil, errors.New("status code error")
+	}
+
+	n := Sums{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the Bookwerx category_dist_sums.")
+		return nil, err
+	}
+
+	return n.Sums, nil
+}
+
+// Get the balances of all accounts tagged with the given category from Bookwerx, counting only the distributions of
+// transactions between timeStart and timeStop.  Either time may be "" to mean the beginning or end of time.
+func CategoryDistSums(cfg config.Config, category uint32, timeStart string, timeStop string) ([]BalanceResultDecorated, error) {
+	url1 := fmt.Sprintf("%s/category_dist_sums?apikey=%s&category_id=%d&decorate=true", cfg.BookwerxConfig.BaseURL, cfg.BookwerxConfig.APIKey, category)
+	if timeStart != "" {
+		url1 += "&time_start=" + url.QueryEscape(timeStart)
+	}
+	if timeStop != "" {
+		url1 += "&time_stop=" + url.QueryEscape(timeStop)
+	}
+	return GetCategoryDistSums(url1)
+}
@@ -0,0 +1,64 @@
+package bookwerx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+)
+
+func TestTitleInstrument(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Margin BTC-USDT", "BTC-USDT"},
+		{"ETH-USDT margin borrowed", "ETH-USDT"},
+		{"Margin btc-usdt", ""},
+		{"Margin", ""},
+		{"Margin-Borrowed", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TitleInstrument(tt.title); got != tt.want {
+			t.Errorf("TitleInstrument(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestFindTransactionsByNotes(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		fmt.Fprint(w, `[{"transactions.id":7}]`)
+	}))
+	defer server.Close()
+	cfg := config.Config{BookwerxConfig: config.BookwerxConfig{BaseURL: server.URL}}
+	client := okchttp.GetHeimdallClient("bookwerx", server.URL, time.Second)
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"okc0123", "'%okc0123%' ESCAPE '!'"},
+		{"okex_ledger_id=1", "'%okex!_ledger!_id=1%' ESCAPE '!'"},
+		{"x' OR '1'='1", "'%x'' OR ''1''=''1%' ESCAPE '!'"},
+		{"50%!", "'%50!%!!%' ESCAPE '!'"},
+	}
+	for _, tt := range tests {
+		txids, err := FindTransactionsByNotes(client, tt.text, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txids) != 1 || txids[0] != 7 {
+			t.Errorf("%q: txids = %v, want [7]", tt.text, txids)
+		}
+		if want := "SELECT transactions.id FROM transactions WHERE transactions.notes LIKE " + tt.want; query != want {
+			t.Errorf("%q: query = %s, want %s", tt.text, query, want)
+		}
+	}
+}
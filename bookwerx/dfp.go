@@ -0,0 +1,80 @@
+package bookwerx
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"math"
+	"math/big"
+)
+
+// Bookwerx keeps an amount as a decimal floating point number: an integer amount and a power of ten, amount_exp, so
+// that the value is amount * 10^amount_exp.  It stores the amount as an int64 and the amount_exp as an int8, so not
+// every decimal fits.
+//
+// The sums that Bookwerx gives back to us are decoded into a wider exponent so that an unusual one doesn't break the
+// decoding of the whole response.
+type DFP struct {
+	Amount int64
+	Exp    int32
+}
+
+var (
+	bigTen    = big.NewInt(10)
+	bigMaxDFP = big.NewInt(math.MaxInt64)
+	bigMinDFP = big.NewInt(math.MinInt64)
+	maxDFPExp = int32(math.MaxInt8)
+	minDFPExp = int32(math.MinInt8)
+)
+
+// 10^18 is the largest power of ten that fits in an int64.
+const maxInt64Pow10 = 18
+
+// The exact value.
+func (d DFP) Decimal() decimal.Decimal {
+	return decimal.New(d.Amount, d.Exp)
+}
+
+// Convert a decimal into what Bookwerx can store, exactly, or else return an error.  The trailing zeros of the amount
+// are moved into the exponent so that, for example, 1.50 and 1.5 both become 15 * 10^-1.  If the exponent is still too
+// big then it's moved back into the amount if that fits.  Anything else would lose something, so it's refused.
+func ToDFP(d decimal.Decimal) (DFP, error) {
+	amount := d.Coefficient()
+	exp := d.Exponent()
+	if amount.Sign() == 0 {
+		return DFP{0, 0}, nil
+	}
+
+	// 1. Normalise the trailing zeros.
+	q, m := new(big.Int), new(big.Int)
+	for {
+		q.QuoRem(amount, bigTen, m)
+		if m.Sign() != 0 {
+			break
+		}
+		amount.Set(q)
+		exp++
+	}
+
+	// 2. A very small number has more decimal places than Bookwerx can hold.
+	if exp < minDFPExp {
+		return DFP{}, errors.New(fmt.Sprintf("bookwerx:dfp.go:ToDFP: %s has too many decimal places", d.String()))
+	}
+
+	// 3. A very large number might still fit if we spell out some of its zeros.
+	if exp > maxDFPExp {
+		shift := exp - maxDFPExp
+		if shift > maxInt64Pow10 {
+			return DFP{}, errors.New(fmt.Sprintf("bookwerx:dfp.go:ToDFP: %s is too big", d.String()))
+		}
+		amount.Mul(amount, new(big.Int).Exp(bigTen, big.NewInt(int64(shift)), nil))
+		exp = maxDFPExp
+	}
+
+	// 4. The amount must fit in an int64.
+	if amount.Cmp(bigMaxDFP) > 0 || amount.Cmp(bigMinDFP) < 0 {
+		return DFP{}, errors.New(fmt.Sprintf("bookwerx:dfp.go:ToDFP: %s has too many significant digits", d.String()))
+	}
+
+	return DFP{amount.Int64(), exp}, nil
+}
@@ -0,0 +1,85 @@
+package bookwerx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToDFP(t *testing.T) {
+	tests := []struct {
+		name  string
+		value decimal.Decimal
+		want  DFP
+		fails bool
+	}{
+		// Trailing zeros
+		{"zero", decimal.RequireFromString("0.000"), DFP{0, 0}, false},
+		{"trailing decimal zero", decimal.RequireFromString("1.50"), DFP{15, -1}, false},
+		{"same without it", decimal.RequireFromString("1.5"), DFP{15, -1}, false},
+		{"integer zeros", decimal.RequireFromString("100"), DFP{1, 2}, false},
+		{"negative", decimal.RequireFromString("-0.0100"), DFP{-1, -2}, false},
+
+		// The int64 amount
+		{"max int64", decimal.New(math.MaxInt64, 0), DFP{math.MaxInt64, 0}, false},
+		{"min int64", decimal.New(math.MinInt64, 0), DFP{math.MinInt64, 0}, false},
+		{"max int64 + 1", decimal.New(math.MaxInt64, 0).Add(decimal.New(1, 0)), DFP{}, true},
+		{"min int64 - 1", decimal.New(math.MinInt64, 0).Sub(decimal.New(1, 0)), DFP{}, true},
+		{"too many significant digits", decimal.RequireFromString("1.2345678901234567890123"), DFP{}, true},
+
+		// The int8 exponent
+		{"max exp", decimal.New(1, 127), DFP{1, 127}, false},
+		{"max exp + 1", decimal.New(1, 128), DFP{10, 127}, false},
+		{"max exp + 18", decimal.New(1, 127+18), DFP{1000000000000000000, 127}, false},
+		{"max exp + 19", decimal.New(1, 127+19), DFP{}, true},
+		{"max exp + 1 too big", decimal.New(math.MaxInt64, 128), DFP{}, true},
+		{"min exp", decimal.New(1, -128), DFP{1, -128}, false},
+		{"min exp - 1", decimal.New(1, -129), DFP{}, true},
+		{"min exp - 1 with a trailing zero", decimal.New(10, -129), DFP{1, -128}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ToDFP(tt.value)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: ToDFP(%s) = %v, want an error", tt.name, tt.value.String(), got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ToDFP(%s) returned %v", tt.name, tt.value.String(), err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: ToDFP(%s) = %v, want %v", tt.name, tt.value.String(), got, tt.want)
+		}
+	}
+}
+
+func FuzzToDFP(f *testing.F) {
+	f.Add(int64(0), int32(0))
+	f.Add(int64(150), int32(-2))
+	f.Add(int64(-1), int32(-128))
+	f.Add(int64(1), int32(-129))
+	f.Add(int64(1), int32(145))
+	f.Add(int64(math.MaxInt64), int32(128))
+	f.Add(int64(math.MinInt64), int32(-3))
+
+	f.Fuzz(func(t *testing.T, value int64, exp int32) {
+		// Keep the exponent small enough that comparing the values doesn't spend all day on powers of ten.
+		exp %= 1000
+		d := decimal.New(value, exp)
+
+		got, err := ToDFP(d)
+		if err != nil {
+			return
+		}
+		if got.Exp < minDFPExp || got.Exp > maxDFPExp {
+			t.Fatalf("ToDFP(%s) = %v, whose exponent does not fit in an int8", d.String(), got)
+		}
+		if !got.Decimal().Equal(d) {
+			t.Fatalf("ToDFP(%s) = %v, which is %s", d.String(), got, got.Decimal().String())
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package bookwerx
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/gojektech/heimdall/httpclient"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// One distribution of a transaction: an amount of a currency into an account.  A debit is positive and a credit is
+// negative.
+type Leg struct {
+	AccountID uint32
+	Currency  string
+	Amount    decimal.Decimal
+}
+
+// A Transaction collects all the legs of a transaction, as many as it needs, so that we can check that they balance
+// before we write anything.  Then it writes the transaction and all of its distributions, or else none of them.
+type Transaction struct {
+	Time  string
+	Notes string
+	Legs  []Leg
+}
+
+func NewTransaction(time string, notes string) *Transaction {
+	return &Transaction{Time: time, Notes: notes}
+}
+
+// Add a leg.  A zero amount changes nothing so don't bother.
+func (t *Transaction) Add(accountID uint32, currency string, amount decimal.Decimal) *Transaction {
+	if !amount.IsZero() {
+		t.Legs = append(t.Legs, Leg{accountID, currency, amount})
+	}
+	return t
+}
+
+// Return an error unless the transaction has some legs, Bookwerx can hold each of their amounts, and the legs of each
+// currency sum to zero.
+func (t *Transaction) Balanced() error {
+	if len(t.Legs) == 0 {
+		return errors.New("bookwerx:transaction.go:Balanced: The transaction has no legs")
+	}
+
+	sums := make(map[string]decimal.Decimal)
+	for _, l := range t.Legs {
+		_, err := ToDFP(l.Amount)
+		if err != nil {
+			return err
+		}
+		sums[l.Currency] = sums[l.Currency].Add(l.Amount)
+	}
+
+	currencies := make([]string, 0, len(sums))
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			currencies = append(currencies, currency)
+		}
+	}
+	if len(currencies) == 0 {
+		return nil
+	}
+
+	sort.Strings(currencies)
+	return errors.New(fmt.Sprintf("bookwerx:transaction.go:Balanced: The legs of %s sum to %s, not zero", currencies[0], sums[currencies[0]].String()))
+}
+
+// Write the transaction and then its distributions, but only if they balance.  If anything goes wrong on the way,
+// delete whatever we wrote so that Bookwerx never has half a transaction.  Return the id of the new transaction.
+func (t *Transaction) Post(client *httpclient.Client, cfg config.Config) (uint32, error) {
+	tlog := log.WithField("notes", t.Notes)
+
+	// 1. Check before we write anything.
+	err := t.Balanced()
+	if err != nil {
+		tlog.WithError(err).Error("Cannot book this.")
+		return 0, err
+	}
+
+	// 2. Create the tx and then its distributions.
+	txid, err := CreateTransaction(client, t.Time, t.Notes, cfg)
+	if err != nil {
+		tlog.WithError(err).Error("Error creating bookwerx transaction.")
+		return 0, err
+	}
+
+	dids := make([]uint32, 0, len(t.Legs))
+	for _, l := range t.Legs {
+		amount, _ := ToDFP(l.Amount) // Balanced says that it fits
+		did, err := CreateDistribution(client, l.AccountID, amount, txid, cfg)
+		if err != nil {
+			tlog.WithError(err).WithField("transaction_id", txid).Error("Error creating bookwerx distribution.  Deleting the transaction.")
+			t.rollback(client, txid, dids, cfg)
+			return 0, err
+		}
+		dids = append(dids, did)
+	}
+
+	return txid, nil
+}
+
+// Delete the distributions, and then the transaction, that we wrote.  If even that fails then somebody will have to
+// do it by hand, so say exactly what's left over.
+func (t *Transaction) rollback(client *httpclient.Client, txid uint32, dids []uint32, cfg config.Config) {
+	rlog := log.WithFields(log.Fields{"notes": t.Notes, "transaction_id": txid})
+	for _, did := range dids {
+		err := DeleteDistribution(client, did, cfg)
+		if err != nil {
+			rlog.WithError(err).WithField("distributions", dids).Error("Cannot delete the partial transaction.  Delete it, and these distributions, by hand.")
+			return
+		}
+	}
+
+	err := DeleteTransaction(client, txid, cfg)
+	if err != nil {
+		rlog.WithError(err).Error("Cannot delete the partial transaction.  Delete it by hand.")
+		return
+	}
+	rlog.Info("Deleted the partial transaction.")
+}
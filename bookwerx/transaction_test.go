@@ -0,0 +1,108 @@
+package bookwerx
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/shopspring/decimal"
+)
+
+func TestBalanced(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      *Transaction
+		wantErr string
+	}{
+		{"balanced", NewTransaction("", "").Add(1, "BTC", decimal.New(15, -1)).Add(2, "BTC", decimal.New(-15, -1)), ""},
+		{"each currency balances", NewTransaction("", "").
+			Add(1, "BTC", decimal.New(1, 0)).Add(2, "USDT", decimal.New(-10000, 0)).
+			Add(3, "BTC", decimal.New(-1, 0)).Add(4, "USDT", decimal.New(10000, 0)), ""},
+		{"no legs", NewTransaction("", ""), "no legs"},
+		{"zero legs are dropped", NewTransaction("", "").Add(1, "BTC", decimal.Zero), "no legs"},
+		{"unbalanced", NewTransaction("", "").Add(1, "BTC", decimal.New(1, 0)).Add(2, "BTC", decimal.New(-9, -1)), "BTC sum to 0.1"},
+		{"the first unbalanced currency", NewTransaction("", "").Add(1, "USDT", decimal.New(1, 0)).Add(2, "BTC", decimal.New(1, 0)), "BTC sum to 1"},
+		{"too big for bookwerx", NewTransaction("", "").
+			Add(1, "BTC", decimal.New(math.MaxInt64, 0).Add(decimal.New(1, 0))).
+			Add(2, "BTC", decimal.New(math.MaxInt64, 0).Add(decimal.New(1, 0)).Neg()), "too many significant digits"},
+	}
+	for _, tt := range tests {
+		err := tt.tx.Balanced()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Balanced = %v, want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Balanced = %v, want an error about %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// A Bookwerx that creates the transaction, and then the given number of distributions before it fails.  It remembers
+// what was deleted.
+func newPostServer(t *testing.T, good int) (config.Config, *[]string) {
+	deleted := make([]string, 0)
+	distributions := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/transactions":
+			fmt.Fprint(w, `{"LastInsertId":7}`)
+		case r.Method == "POST" && r.URL.Path == "/distributions":
+			distributions++
+			if distributions > good {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"no such account"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"LastInsertId":%d}`, 100+distributions)
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			fmt.Fprint(w, `{"data":{"info":"1 row deleted"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return config.Config{BookwerxConfig: config.BookwerxConfig{BaseURL: server.URL}}, &deleted
+}
+
+func TestPost(t *testing.T) {
+	cfg, deleted := newPostServer(t, 3)
+	client := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, time.Second)
+
+	tx := NewTransaction("2020-10-10T00:00:00.000Z", "test").
+		Add(1, "BTC", decimal.New(1, 0)).Add(2, "BTC", decimal.New(-1, 0))
+	txid, err := tx.Post(client, cfg)
+	if err != nil || txid != 7 || len(*deleted) != 0 {
+		t.Errorf("Post = %d, %v, deleted %v", txid, err, *deleted)
+	}
+
+	// Nothing is written unless it balances.
+	_, err = NewTransaction("2020-10-10T00:00:00.000Z", "test").Add(1, "BTC", decimal.New(1, 0)).Post(client, cfg)
+	if err == nil {
+		t.Errorf("Post of an unbalanced transaction = nil, want an error")
+	}
+}
+
+func TestPostRollback(t *testing.T) {
+	cfg, deleted := newPostServer(t, 2)
+	client := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, time.Second)
+
+	// The third distribution fails, so the first two, and then the transaction, are deleted.
+	tx := NewTransaction("2020-10-10T00:00:00.000Z", "test").
+		Add(1, "BTC", decimal.New(1, 0)).Add(2, "BTC", decimal.New(-1, 0)).
+		Add(3, "USDT", decimal.New(5, 0)).Add(4, "USDT", decimal.New(-5, 0))
+	txid, err := tx.Post(client, cfg)
+	if err == nil || txid != 0 {
+		t.Errorf("Post = %d, %v, want an error", txid, err)
+	}
+	want := []string{"/distribution/101", "/distribution/102", "/transaction/7"}
+	if strings.Join(*deleted, " ") != strings.Join(want, " ") {
+		t.Errorf("deleted %v, want %v", *deleted, want)
+	}
+}
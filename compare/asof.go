@@ -0,0 +1,306 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// How many ledger entries to ask OKEx for at a time, when replaying a ledger.
+const replayPageLimit = 100
+
+// The category of the comparisons of the spot balances as of some time.  The OKEx ledger only tells us the total
+// of what's available and on hold, so that's what we compare.
+const SpotTotal = "Spot"
+
+// What OKEx said about the balances at some time, as saved by compare.  The Bookwerx balances are what Bookwerx said at
+// that time, which need not be what it says about that time now.
+type Snapshot struct {
+	Time        string
+	Comparisons []Comparison
+	Raw         []RawBalances `json:",omitempty"`
+
+	// How closely the balances of each OKEx account had to agree when the snapshot was taken, by the Account of its
+	// comparisons.
+	Tolerances map[string]config.CompareConfig `json:",omitempty"`
+}
+
+// What OKEx said about the funding and spot balances of a single OKEx account, just as it said it.
+type RawBalances struct {
+	Account string          `json:",omitempty"` // If there's more than one
+	Wallet  json.RawMessage // from /api/account/v3/wallet
+	Spot    json.RawMessage // from /api/spot/v3/accounts
+}
+
+// Read a snapshot saved in the given file.
+func ReadSnapshot(filename string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("snapshot", filename).Error("Cannot read the snapshot.")
+		return nil, err
+	}
+
+	snapshot := Snapshot{}
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		log.WithError(err).WithField("snapshot", filename).Error("Cannot decode the snapshot.")
+		return nil, err
+	}
+	if snapshot.Time == "" {
+		err = fmt.Errorf("compare:asof.go:ReadSnapshot: the snapshot %s has no time", filename)
+		log.WithError(err).Error("Cannot use the snapshot.")
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Make sure that opts asks for something that we can compare as of some time, and say what time that is.  Either
+// -as-of or -snapshot will do, but not both.  -as-of may be a time such as 2020-06-01T00:00:00Z or merely a date.
+// Either way, the time is then written as Bookwerx writes its own, since Bookwerx compares them as strings.
+func checkAsOf(opts *Options) error {
+	if opts.AdjustRounding || opts.Propose || opts.Apply {
+		log.Error("Nothing can be adjusted when comparing as of some time.  Drop -adjust-rounding, -propose, and -apply.")
+		return fmt.Errorf("compare:asof.go:checkAsOf: cannot adjust as of some time")
+	}
+	if opts.Record != nil {
+		log.Error("Only the balances of now can be saved in a snapshot.  Drop -save-snapshot.")
+		return fmt.Errorf("compare:asof.go:checkAsOf: cannot save a snapshot as of some time")
+	}
+
+	if opts.Snapshot != nil {
+		if opts.AsOf != "" {
+			log.Error("Use either -as-of or -snapshot, but not both.  The snapshot says what time it is.")
+			return fmt.Errorf("compare:asof.go:checkAsOf: both -as-of and -snapshot")
+		}
+		t, err := time.Parse(time.RFC3339, opts.Snapshot.Time)
+		if err != nil {
+			log.WithError(err).WithField("as_of", opts.Snapshot.Time).Error("Cannot parse the time of the snapshot.")
+			return err
+		}
+		opts.Snapshot.Time = t.UTC().Format(bookwerx.TimeFormat)
+		log.WithField("as_of", opts.Snapshot.Time).Info("Comparing the snapshot.")
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, opts.AsOf)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", opts.AsOf)
+	}
+	if err != nil {
+		log.WithField("as_of", opts.AsOf).Error("Cannot parse the time.  Use something like 2020-06-01T00:00:00Z or 2020-06-01.")
+		return err
+	}
+	opts.AsOf = t.UTC().Format(bookwerx.TimeFormat)
+	log.WithField("as_of", opts.AsOf).Info("Comparing as of then.")
+	return nil
+}
+
+// Which section is compared with which Bookwerx categories, is it something that we owe, and is it kept for each
+// margin instrument?
+type section struct {
+	name       string
+	categories []uint32
+	credit     bool
+	margin     bool
+}
+
+func sections(cfg *config.Config) []section {
+	bc := cfg.BookwerxConfig
+	return []section{
+		{"F", []uint32{bc.CatFunding}, false, false},
+		{"Spot-Available", []uint32{bc.CatSpotAvailable}, false, false},
+		{"Spot-Hold", []uint32{bc.CatSpotHold}, false, false},
+		{SpotTotal, []uint32{bc.CatSpotAvailable, bc.CatSpotHold}, false, false},
+		{"Margin-Available", []uint32{bc.CatMargin}, false, true},
+		{"Margin-Hold", []uint32{bc.CatMarginHold}, false, true},
+		{MarginBorrowed, []uint32{bc.CatMarginBorrowed}, true, true},
+		{"Futures", []uint32{bc.CatFutures}, false, false},
+		{"Swap", []uint32{bc.CatSwap}, false, false},
+	}
+}
+
+// Compare the OKEx balances saved in the snapshot, for the given OKEx account, with what Bookwerx says that the
+// balances were at the time of the snapshot.  If there's only a single OKEx account then account is "".
+func snapshotComparisons(cfg *config.Config, snapshot *Snapshot, account string) ([]Comparison, error) {
+
+	// 1. What OKEx said, by section, margin instrument, and currency.
+	okexBalances := make(map[string]map[instrumentCurrency]decimal.Decimal)
+	for _, c := range snapshot.Comparisons {
+		if c.Account != account || c.OKExBalance.Nil {
+			continue
+		}
+		if okexBalances[c.Category] == nil {
+			okexBalances[c.Category] = make(map[instrumentCurrency]decimal.Decimal)
+		}
+		key := instrumentCurrency{c.Instrument, c.CurrencySymbol}
+		okexBalances[c.Category][key] = okexBalances[c.Category][key].Add(c.OKExBalance.Balance)
+	}
+
+	// 2. What Bookwerx says, for each section in the snapshot.
+	retVal := make([]Comparison, 0)
+	for _, s := range sections(cfg) {
+		balances, ok := okexBalances[s.name]
+		if !ok {
+			continue
+		}
+		var comparisons []Comparison
+		var err error
+		if s.margin {
+			comparisons, err = instrumentComparisons(cfg, s.name, s.categories[0], s.credit, balances, snapshot.Time)
+		} else {
+			byCurrency := make(map[string]decimal.Decimal)
+			for key, b := range balances {
+				byCurrency[key.currency] = byCurrency[key.currency].Add(b)
+			}
+			comparisons, err = sectionComparisons(cfg, s.name, s.categories, s.credit, byCurrency, snapshot.Time)
+		}
+		if err != nil {
+			return nil, err
+		}
+		retVal = append(retVal, comparisons...)
+	}
+	return retVal, nil
+}
+
+// Compare the funding and spot balances as of the given time.  Bookwerx can tell us its balances as of any time.  For
+// OKEx, replay the ledger of each currency backwards from now until we find the newest entry at or before that time.
+// Its balance is what we had then.  If the ledger has entries but none that old then we had nothing.  If it has none
+// at all then nothing has changed since then.
+func replayComparisons(cfg *config.Config, client *okex.Client, asOf string) ([]Comparison, error) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		log.WithError(err).WithField("as_of", asOf).Error("Cannot parse the time.")
+		return nil, err
+	}
+
+	bc := cfg.BookwerxConfig
+	if bc.CatMargin != 0 || bc.CatFutures != 0 || bc.CatSwap != 0 {
+		log.Warn("Only the funding and spot balances can be replayed.  Use -snapshot to compare the others as of some time.")
+	}
+
+	// 1. The funding balances, starting from what we have now.
+	walletEntries, err := getWallet(client, nil)
+	if err != nil {
+		log.Error("Cannot execute the wallet API endpoint.")
+		return nil, err
+	}
+	funding := make(map[string]decimal.Decimal)
+	for _, w := range walletEntries {
+		b, err := decimal.NewFromString(w.Balance)
+		if err != nil {
+			b = decimal.Zero
+		}
+		funding[w.CurrencyID] = b
+	}
+
+	// 2. The spot balances, available and on hold together, starting from what we have now.
+	accountsEntries, err := getAccounts(client, nil)
+	if err != nil {
+		log.Error("Cannot execute the accounts API endpoint.")
+		return nil, err
+	}
+	spot := make(map[string]decimal.Decimal)
+	for _, a := range accountsEntries {
+		b, err := decimal.NewFromString(a.Balance)
+		if err != nil {
+			b = decimal.Zero
+		}
+		spot[a.CurrencyID] = b
+	}
+
+	replays := []struct {
+		section  section
+		current  map[string]decimal.Decimal
+		endpoint func(currency string) string
+	}{
+		{section{"F", []uint32{bc.CatFunding}, false, false}, funding,
+			func(currency string) string { return "/api/account/v3/ledger?currency=" + currency }},
+		{section{SpotTotal, []uint32{bc.CatSpotAvailable, bc.CatSpotHold}, false, false}, spot,
+			func(currency string) string { return "/api/spot/v3/accounts/" + currency + "/ledger" }},
+	}
+
+	retVal := make([]Comparison, 0)
+	for _, r := range replays {
+
+		// 3. Compare them with Bookwerx, as of then.
+		comparisons, err := sectionComparisons(cfg, r.section.name, r.section.categories, r.section.credit, r.current, asOf)
+		if err != nil {
+			return nil, err
+		}
+
+		// 4. Replay the ledger of each currency, including those that Bookwerx has but OKEx doesn't have anymore.
+		for i, c := range comparisons {
+			b, found, err := balanceAsOf(client, r.endpoint(c.CurrencySymbol), t)
+			if err != nil {
+				log.WithFields(log.Fields{"section": r.section.name, "currency": c.CurrencySymbol}).Error("Cannot replay the OKEx ledger.")
+				return nil, err
+			}
+			if found {
+				comparisons[i].OKExBalance = MaybeBalance{b, false}
+			}
+		}
+		retVal = append(retVal, comparisons...)
+	}
+	return retVal, nil
+}
+
+// Walk backwards through an OKEx ledger, newest first, to find the balance as of the given time.  Return false if the
+// ledger has no entries at all.
+func balanceAsOf(client *okex.Client, endpoint string, t time.Time) (decimal.Decimal, bool, error) {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+
+	after := ""
+	seen := false
+	for {
+		e := fmt.Sprintf("%s%slimit=%d", endpoint, sep, replayPageLimit)
+		if after != "" {
+			e += "&after=" + after
+		}
+		body, err := client.Do("GET", e, "")
+		if err != nil {
+			return decimal.Zero, false, err
+		}
+
+		entries := make([]utils.LedgerEntry, 0)
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(&entries)
+		if err != nil {
+			log.WithError(err).WithField("endpoint", e).Error("Cannot decode the OKEx ledger.")
+			return decimal.Zero, false, err
+		}
+
+		for _, entry := range entries {
+			seen = true
+			when, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				log.WithError(err).WithField("ledger_id", entry.LedgerID).Error("Cannot parse the timestamp of the ledger entry.")
+				return decimal.Zero, false, err
+			}
+			if when.After(t) {
+				after = entry.LedgerID
+				continue
+			}
+			b, err := decimal.NewFromString(entry.Balance)
+			if err != nil {
+				log.WithError(err).WithField("ledger_id", entry.LedgerID).Error("Cannot parse the balance of the ledger entry.")
+				return decimal.Zero, false, err
+			}
+			return b, true, nil
+		}
+
+		if len(entries) < replayPageLimit {
+			return decimal.Zero, seen, nil
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package compare
+
+import "testing"
+
+func TestCheckAsOf(t *testing.T) {
+	tests := []struct {
+		asOf string
+		want string
+	}{
+		{"2020-06-01", "2020-06-01T00:00:00.000Z"},
+		{"2020-06-01T00:00:00Z", "2020-06-01T00:00:00.000Z"},
+		{"2020-06-01T01:02:03.5+02:00", "2020-05-31T23:02:03.500Z"},
+	}
+	for _, tt := range tests {
+		opts := Options{AsOf: tt.asOf}
+		if err := checkAsOf(&opts); err != nil || opts.AsOf != tt.want {
+			t.Errorf("checkAsOf(%s) = %s, %v, want %s", tt.asOf, opts.AsOf, err, tt.want)
+		}
+	}
+
+	// A snapshot saved with fewer decimal places is compared as Bookwerx writes the time.
+	opts := Options{Snapshot: &Snapshot{Time: "2020-06-01T00:00:05.1Z"}}
+	if err := checkAsOf(&opts); err != nil || opts.Snapshot.Time != "2020-06-01T00:00:05.100Z" {
+		t.Errorf("checkAsOf(snapshot) = %s, %v", opts.Snapshot.Time, err)
+	}
+
+	for _, opts := range []Options{{AsOf: "yesterday"}, {AsOf: "2020-06-01", Propose: true}, {AsOf: "2020-06-01", Snapshot: &Snapshot{Time: "2020-06-01T00:00:00Z"}}} {
+		if err := checkAsOf(&opts); err == nil {
+			t.Errorf("checkAsOf(%+v) = nil, want an error", opts)
+		}
+	}
+}
@@ -3,15 +3,14 @@ package compare
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/bookwerx"
 	"github.com/bostontrader/okconnect/config"
-	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/okex"
 	"github.com/shopspring/decimal"
-	"io/ioutil"
-	"net/http"
-	"time"
+	log "github.com/sirupsen/logrus"
+	"sort"
 )
 
 // 1. Define some structs used for the comparison of balances.
@@ -32,72 +31,55 @@ type Comparison struct {
 	BookwerxBalance MaybeBalance
 	CurrencySymbol  string // OKEx uses a currency symbol as a currency id
 	AccountID       uint32 // This is the account id for bookwerx
+	Account         string `json:",omitempty"` // Which OKEx account, if there's more than one
+	Instrument      string `json:",omitempty"` // Which margin account, if Bookwerx has one for this instrument
 }
 
-// 2. Define some structs for use in interfacing with Bookwerx
-type AccountCurrency struct {
-	AccountID uint32 `json:"account_id"`
-	Title     string
-	Currency  CurrencySymbol
+// 1.3 When there's more than one OKEx account, compare each of them and then all of them together.
+type AccountMismatches struct {
+	Account    string
+	Mismatches []Comparison
 }
 
-type BalanceResultDecorated struct {
-	Account AccountCurrency
-	Sum     DFP
+type Consolidated struct {
+	Accounts []AccountMismatches
+	Total    []Comparison // The mismatches between the sums of the balances of every account
 }
 
-type CurrencySymbol struct {
-	CurrencyID uint32 `json:"currency_id"`
-	Symbol     string
+// 1.4 What else shall Compare do besides printing the mismatches?
+type Options struct {
+	AdjustRounding bool      // Book a rounding adjustment for each difference that's within tolerance
+	Propose        bool      // Print a proposed adjusting transaction for each mismatch instead
+	Apply          bool      // Post the proposed adjusting transactions too
+	Yes            bool      // Post them without asking first
+	AsOf           string    // Compare the balances as of this time instead of now, replaying the OKEx ledgers
+	Snapshot       *Snapshot // Compare the balances as of the time of this snapshot, using the OKEx balances in it
+	Record         *Snapshot // Keep the balances, and what OKEx and Bookwerx said about them, in here
 }
 
-type DFP struct {
-	Amount int64
-	Exp    int8
+// What identifies the comparison within a single OKEx account: its category, its margin instrument if any, and its
+// currency.
+func (c Comparison) Key() string {
+	if c.Instrument == "" {
+		return c.Category + ":" + c.CurrencySymbol
+	}
+	return c.Category + ":" + c.Instrument + ":" + c.CurrencySymbol
 }
 
-type Sums struct {
-	Sums []BalanceResultDecorated
+// Do the OKEx and Bookwerx balances agree?
+func (c Comparison) Matches() bool {
+	b1, b2 := decimal.RescalePair(c.BookwerxBalance.Balance, c.OKExBalance.Balance)
+	return b1.Equal(b2)
 }
 
-// Make the API call to get all funding balances from OKEx
-func getWallet(cfg config.Config, credentials utils.Credentials) ([]utils.WalletEntry, error) {
-	urlBase := cfg.OKExConfig.BaseURL
-	endpoint := "/api/account/v3/wallet"
-	url := urlBase + endpoint
-	client := okchttp.GetHTTPClient(urlBase)
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
-	prehash := timestamp + "GET" + endpoint
-	encoded, _ := utils.HmacSha256Base64Signer(prehash, credentials.SecretKey)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Printf("NewRequest error: %v\n", err)
-		return nil, err
-	}
-
-	req.Header.Add("OK-ACCESS-KEY", credentials.Key)
-	req.Header.Add("OK-ACCESS-SIGN", encoded)
-	req.Header.Add("OK-ACCESS-TIMESTAMP", timestamp)
-	req.Header.Add("OK-ACCESS-PASSPHRASE", credentials.Passphrase)
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("client.Do error: %v\n", err)
-		return nil, err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
+// Make the API call to get all funding balances from OKEx.  If raw isn't nil then keep the response there too.
+func getWallet(client *okex.Client, raw *json.RawMessage) ([]utils.WalletEntry, error) {
+	body, err := client.Do("GET", "/api/account/v3/wallet", "")
 	if err != nil {
-		fmt.Printf("ReadAll error: %v\n", err)
 		return nil, err
 	}
-
-	_ = resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		fmt.Printf("Status Code error: expected= 200, received=%d\n", resp.StatusCode)
-		fmt.Printf("body=%s\n", string(body))
-		return nil, errors.New("status code error")
+	if raw != nil {
+		*raw = body
 	}
 
 	walletEntries := make([]utils.WalletEntry, 0)
@@ -105,125 +87,313 @@ func getWallet(cfg config.Config, credentials utils.Credentials) ([]utils.Wallet
 	dec.DisallowUnknownFields()
 	err = dec.Decode(&walletEntries)
 	if err != nil {
-		fmt.Printf("Wallet JSON decode error: %v\n", err)
+		log.WithError(err).Error("Cannot decode the OKEx wallet.")
 		return nil, err
 	}
 
 	return walletEntries, nil
 }
 
-// Make the API call to get all spot balances from OKEx.  This gives us both available and hold balances.
-func getAccounts(cfg config.Config, credentials utils.Credentials) ([]utils.AccountsEntry, error) {
-	urlBase := cfg.OKExConfig.BaseURL
-	endpoint := "/api/spot/v3/accounts"
-	url := urlBase + endpoint
-	client := okchttp.GetHTTPClient(urlBase)
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
-	prehash := timestamp + "GET" + endpoint
-	encoded, _ := utils.HmacSha256Base64Signer(prehash, credentials.SecretKey)
-
-	req, err := http.NewRequest("GET", url, nil)
+// Make the API call to get all spot balances from OKEx.  This gives us both available and hold balances.  If raw isn't
+// nil then keep the response there too.
+func getAccounts(client *okex.Client, raw *json.RawMessage) ([]utils.AccountsEntry, error) {
+	body, err := client.Do("GET", "/api/spot/v3/accounts", "")
 	if err != nil {
-		fmt.Printf("NewRequest error: %v\n", err)
 		return nil, err
 	}
+	if raw != nil {
+		*raw = body
+	}
 
-	req.Header.Add("OK-ACCESS-KEY", credentials.Key)
-	req.Header.Add("OK-ACCESS-SIGN", encoded)
-	req.Header.Add("OK-ACCESS-TIMESTAMP", timestamp)
-	req.Header.Add("OK-ACCESS-PASSPHRASE", credentials.Passphrase)
-	resp, err := client.Do(req)
+	accountsEntries := make([]utils.AccountsEntry, 0)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&accountsEntries)
 	if err != nil {
-		fmt.Printf("client.Do error: %v\n", err)
+		log.WithError(err).Error("Cannot decode the OKEx spot accounts.")
 		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("ReadAll error: %v\n", err)
-		return nil, err
+	return accountsEntries, nil
+}
+
+// Compare the OKEx balances with those in Bookwerx and print the mismatches as a JSON array.  Return them too, or
+// an error if we could not compare.
+//
+// If the config has more than one OKEx account then compare each of them, and the sums of them all, and print a
+// Consolidated instead.  Return the mismatches of each account.
+//
+// A difference that's within tolerance is not a mismatch.  It's merely logged, unless opts.AdjustRounding, in which
+// case a rounding adjustment is booked so that Bookwerx agrees exactly.
+//
+// If opts.Propose or opts.Apply then print the proposed adjusting transactions instead, and if opts.Apply, post them.
+// The mismatches that they fixed are not returned.
+//
+// If opts.AsOf or opts.Snapshot then compare the balances as of some time in the past instead.  Nothing can be
+// adjusted then.  Otherwise, if opts.Record isn't nil then record a snapshot of them all in it.
+func Compare(cfg *config.Config, opts Options) ([]Comparison, error) {
+	if opts.AsOf != "" || opts.Snapshot != nil {
+		err := checkAsOf(&opts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	_ = resp.Body.Close()
+	accounts := cfg.AllAccounts()
+	consolidated := Consolidated{Accounts: make([]AccountMismatches, 0), Total: make([]Comparison, 0)}
+	retValA := make([]Comparison, 0)
+	totals := make(map[string]*Comparison)
+	keys := make([]string, 0)
+	proposals := make([]Proposal, 0)
+
+	for _, a := range accounts {
+		alog := log.WithField("account", a.Name)
+
+		// Read the credentials file for OKEx
+		cfgA := cfg.ForAccount(a)
+		credentials, err := config.ReadCredentials(cfgA.OKExConfig.Credentials)
+		if err != nil {
+			alog.Error("Cannot read the OKEx credentials file.")
+			return nil, err
+		}
+
+		client := okex.NewClient(*cfgA, *credentials)
+
+		account := ""
+		if len(accounts) > 1 {
+			account = a.Name
+		}
+
+		var comparisons []Comparison
+		switch {
+		case opts.Snapshot != nil:
+			comparisons, err = snapshotComparisons(cfgA, opts.Snapshot, account)
+		case opts.AsOf != "":
+			comparisons, err = replayComparisons(cfgA, client, opts.AsOf)
+		case opts.Record != nil:
+			if opts.Record.Time == "" {
+				opts.Record.Time = client.Timestamp()
+			}
+			raw := RawBalances{Account: account}
+			comparisons, err = recordComparisons(cfgA, client, &raw)
+			opts.Record.Raw = append(opts.Record.Raw, raw)
+			if opts.Record.Tolerances == nil {
+				opts.Record.Tolerances = make(map[string]config.CompareConfig)
+			}
+			opts.Record.Tolerances[account] = cfgA.CompareConfig
+		default:
+			comparisons, err = Comparisons(cfgA, client)
+		}
+		if err != nil {
+			alog.Error("Cannot compare this account.")
+			return nil, err
+		}
+
+		am := AccountMismatches{Account: a.Name, Mismatches: make([]Comparison, 0)}
+		tolerated := make([]Comparison, 0)
+		for _, c := range comparisons {
+			c.Account = account
+			if opts.Record != nil {
+				opts.Record.Comparisons = append(opts.Record.Comparisons, c)
+			}
+			switch c.Status(cfgA.CompareConfig) {
+			case StatusMismatch:
+				am.Mismatches = append(am.Mismatches, c)
+				retValA = append(retValA, c)
+			case StatusTolerated:
+				tolerated = append(tolerated, c)
+				alog.WithFields(log.Fields{"category": c.Category, "currency": c.CurrencySymbol, "difference": c.Difference().String()}).Info("Within tolerance.")
+			}
 
-	if resp.StatusCode != 200 {
-		fmt.Printf("Status Code error: expected= 200, received=%d\n", resp.StatusCode)
-		fmt.Printf("body=%s\n", string(body))
-		return nil, errors.New("status code error")
+			// Sum the balances of every account.
+			key := c.Key()
+			t, ok := totals[key]
+			if !ok {
+				t = &Comparison{c.Category, MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.Zero, true}, c.CurrencySymbol, 0, "", c.Instrument}
+				totals[key] = t
+				keys = append(keys, key)
+			}
+			t.OKExBalance = addMaybe(t.OKExBalance, c.OKExBalance)
+			t.BookwerxBalance = addMaybe(t.BookwerxBalance, c.BookwerxBalance)
+		}
+		consolidated.Accounts = append(consolidated.Accounts, am)
+
+		if opts.AdjustRounding {
+			err = adjustRounding(cfgA, client, tolerated)
+			if err != nil {
+				alog.WithError(err).Error("Cannot adjust for rounding.")
+				return nil, err
+			}
+		}
+
+		if opts.Propose || opts.Apply {
+			p, err := Propose(cfgA, client, am.Mismatches)
+			if err != nil {
+				alog.WithError(err).Error("Cannot propose the adjustments.")
+				return nil, err
+			}
+			proposals = append(proposals, p...)
+		}
 	}
 
-	accountsEntries := make([]utils.AccountsEntry, 0)
-	dec := json.NewDecoder(bytes.NewReader(body))
-	dec.DisallowUnknownFields()
-	err = dec.Decode(&accountsEntries)
-	if err != nil {
-		fmt.Printf("Accounts JSON decode error: %v\n", err)
-		return nil, err
+	if opts.Propose || opts.Apply {
+		if opts.Apply {
+			posted, err := apply(proposals, opts.Yes)
+			if err != nil {
+				log.WithError(err).WithField("posted", posted).Error("Cannot post every adjustment.")
+			}
+			retValA = unfixed(retValA, proposals)
+		}
+		retValB, _ := json.Marshal(proposals)
+		fmt.Println(string(retValB))
+		return retValA, nil
 	}
 
-	return accountsEntries, nil
+	var retValB []byte
+	if len(accounts) == 1 {
+		retValB, _ = json.Marshal(retValA)
+	} else {
+		// The sums belong to no single account, so judge them as the config says, not as any account does.
+		for _, key := range keys {
+			if totals[key].Status(cfg.CompareConfig) == StatusMismatch {
+				consolidated.Total = append(consolidated.Total, *totals[key])
+			}
+		}
+		retValB, _ = json.Marshal(consolidated)
+	}
+
+	fmt.Println(string(retValB))
+
+	return retValA, nil
 }
 
-// Get the current balances of all accounts tagged with a list of categories from Bookwerx.
-func getCategoryDistSums(url string) ([]BalanceResultDecorated, error) {
+// The mismatches that no posted proposal fixed.
+func unfixed(mismatches []Comparison, proposals []Proposal) []Comparison {
+	retVal := make([]Comparison, 0)
+	for _, c := range mismatches {
+		fixed := false
+		for _, p := range proposals {
+			if p.TransactionID != 0 && p.Mismatch.Account == c.Account && p.Mismatch.Key() == c.Key() &&
+				sameBalance(p.Mismatch.OKExBalance, c.OKExBalance) && sameBalance(p.Mismatch.BookwerxBalance, c.BookwerxBalance) {
+				fixed = true
+			}
+		}
+		if !fixed {
+			retVal = append(retVal, c)
+		}
+	}
+	return retVal
+}
 
-	client := okchttp.GetHTTPClient(url)
+// Are these the same balance?  The same amount may be written differently, such as 1.5 and 1.50, so compare the
+// amounts and not how they're written.  Nothing is not the same as zero.
+func sameBalance(a MaybeBalance, b MaybeBalance) bool {
+	if a.Nil || b.Nil {
+		return a.Nil == b.Nil
+	}
+	return a.Balance.Equal(b.Balance)
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Printf("NewRequest error: %v\n", err)
-		return nil, err
+// Add two balances, either of which might be nil.
+func addMaybe(a MaybeBalance, b MaybeBalance) MaybeBalance {
+	if b.Nil {
+		return a
+	}
+	if a.Nil {
+		return b
 	}
+	return MaybeBalance{a.Balance.Add(b.Balance), false}
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("client.Do error: %v\n", err)
-		return nil, err
+// Compare the balances of a single section, given what OKEx says about each currency, with the sums of the balances
+// of the Bookwerx accounts tagged with the given categories, if any, as of the given time, or now if asOf is "".  If
+// the section is something that we owe then Bookwerx records it as a credit, so flip its sign.  Return the comparisons
+// sorted by currency.
+func sectionComparisons(cfg *config.Config, section string, categories []uint32, credit bool, okexBalances map[string]decimal.Decimal, asOf string) ([]Comparison, error) {
+	entries := make(map[string]Comparison)
+	for currency, b := range okexBalances {
+		entries[currency] = Comparison{section, MaybeBalance{b, false}, MaybeBalance{decimal.Zero, true}, currency, 0, "", ""}
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("ReadAll error: %v\n", err)
-		return nil, err
+	for _, category := range categories {
+		if category == 0 {
+			continue
+		}
+		sums, err := bookwerx.CategoryDistSums(*cfg, category, "", asOf)
+		if err != nil {
+			log.WithField("section", section).Error("Cannot execute the getCategoryDistSums API endpoint.")
+			return nil, err
+		}
+
+		for _, brd := range sums {
+			b1 := brd.Sum.Decimal()
+			if credit {
+				b1 = b1.Neg()
+			}
+			currency := brd.Account.Currency.Symbol
+			c, ok := entries[currency]
+			if !ok {
+				c = Comparison{section, MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.Zero, true}, currency, 0, "", ""}
+			}
+			c.BookwerxBalance = addMaybe(c.BookwerxBalance, MaybeBalance{b1, false})
+			if c.AccountID == 0 {
+				c.AccountID = brd.Account.AccountID
+			}
+			entries[currency] = c
+		}
 	}
 
-	_ = resp.Body.Close()
+	currencies := make([]string, 0, len(entries))
+	for currency := range entries {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
 
-	if resp.StatusCode != 200 {
-		fmt.Printf("Status Code error: expected= 200, received=%d\n", resp.StatusCode)
-		fmt.Printf("body=%s\n", string(body))
-		return nil, errors.New("status code error")
+	retVal := make([]Comparison, 0, len(currencies))
+	for _, currency := range currencies {
+		retVal = append(retVal, entries[currency])
 	}
+	return retVal, nil
+}
 
-	n := Sums{}
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n)
+// Get the balances from OKEx and Bookwerx and return the comparisons that don't match, not even within tolerance.
+func Mismatches(cfg *config.Config, client *okex.Client) ([]Comparison, error) {
+	comparisons, err := Comparisons(cfg, client)
 	if err != nil {
-		fmt.Printf("getCategoryDistSums JSON decode error: %v\n", err)
 		return nil, err
 	}
 
-	return n.Sums, nil
+	retVal := make([]Comparison, 0)
+	for _, c := range comparisons {
+		if c.Status(cfg.CompareConfig) == StatusMismatch {
+			retVal = append(retVal, c)
+		}
+	}
+	return retVal, nil
 }
 
-func Compare(cfg *config.Config) {
+// Get the balances from OKEx and Bookwerx and return all the comparisons, whether they match or not.
+func Comparisons(cfg *config.Config, client *okex.Client) ([]Comparison, error) {
+	return recordComparisons(cfg, client, nil)
+}
 
-	// 1. Read the credentials file for OKEx
-	credentials, err := config.ReadCredentialsFile(cfg.OKExConfig.Credentials)
-	if err != nil {
-		fmt.Printf("Cannot read the OKEx credentials file.\n")
-		return
+// Get the comparisons, and if raw isn't nil then keep what OKEx said about the funding and spot balances there too.
+func recordComparisons(cfg *config.Config, client *okex.Client, raw *RawBalances) ([]Comparison, error) {
+	var rawWallet, rawSpot *json.RawMessage
+	if raw != nil {
+		rawWallet, rawSpot = &raw.Wallet, &raw.Spot
 	}
 
-	// 2. Get the funding balances
+	// 1. Get the funding balances
 
-	// 2.1 ... from OKEx
-	walletEntries, err := getWallet(*cfg, *credentials)
+	// 1.1 ... from OKEx
+	walletEntries, err := getWallet(client, rawWallet)
 	if err != nil {
-		fmt.Printf("Cannot execute the wallet API endpoint.\n")
-		return
+		log.Error("Cannot execute the wallet API endpoint.")
+		return nil, err
 	}
 
-	// 2.1.1 Init the comparison chart for the funding section
+	// 1.1.1 Init the comparison chart for the funding section
 	comparisonEntriesFunding := make(map[string]Comparison)
 	for _, walletEntry := range walletEntries {
 
@@ -239,25 +409,27 @@ func Compare(cfg *config.Config) {
 			MaybeBalance{decimal.NewFromInt(0), true},
 			walletEntry.CurrencyID,
 			0,
+			"",
+			"",
 		}
 		comparisonEntriesFunding[walletEntry.CurrencyID] = comparison // this is really the currency symbol
 	}
 
-	// 2.2 ... from Bookwerx
+	// 1.2 ... from Bookwerx
 	// Get the account balances for all accounts tagged as funding_cat.
 	categories := fmt.Sprintf("%d", cfg.BookwerxConfig.CatFunding)
 	url := fmt.Sprintf("%s/category_dist_sums?apikey=%s&category_id=%s&decorate=true", cfg.BookwerxConfig.BaseURL, cfg.BookwerxConfig.APIKey, categories)
 
-	sums, err := getCategoryDistSums(url)
+	sums, err := bookwerx.GetCategoryDistSums(url)
 	if err != nil {
-		fmt.Printf("Cannot execute the getCategoryDistSums API endpoint.\n")
-		return
+		log.Error("Cannot execute the getCategoryDistSums API endpoint.")
+		return nil, err
 	}
 
-	// 2.2.1. Insert whatever balance info is found into the comparison chart for the funding section.  Modify an existing record or create a new one if necessary.
+	// 1.2.1. Insert whatever balance info is found into the comparison chart for the funding section.  Modify an existing record or create a new one if necessary.
 	for _, brd := range sums {
 
-		b1 := decimal.New(brd.Sum.Amount, int32(brd.Sum.Exp))
+		b1 := brd.Sum.Decimal()
 
 		i, ok := comparisonEntriesFunding[brd.Account.Currency.Symbol]
 		if ok {
@@ -273,20 +445,22 @@ func Compare(cfg *config.Config) {
 				MaybeBalance{b1, false},
 				brd.Account.Currency.Symbol,
 				brd.Account.AccountID,
+				"",
+				"",
 			}
 		}
 	}
 
-	// 3. Get the spot balances.  Be aware of available and hold balances.
+	// 2. Get the spot balances.  Be aware of available and hold balances.
 
-	// 3.1 ... from OKEx
-	accountsEntries, err := getAccounts(*cfg, *credentials)
+	// 2.1 ... from OKEx
+	accountsEntries, err := getAccounts(client, rawSpot)
 	if err != nil {
-		fmt.Printf("Cannot execute the accounts API endpoint.\n")
-		return
+		log.Error("Cannot execute the accounts API endpoint.")
+		return nil, err
 	}
 
-	// 3.1.1 Init the comparison chart for the spot, available section
+	// 2.1.1 Init the comparison chart for the spot, available section
 	comparisonEntriesSpotA := make(map[string]Comparison)
 	for _, accountsEntry := range accountsEntries {
 
@@ -302,11 +476,13 @@ func Compare(cfg *config.Config) {
 			MaybeBalance{decimal.NewFromInt(0), true},
 			accountsEntry.CurrencyID, // okex uses a currency symbol as their currency id
 			0,
+			"",
+			"",
 		}
 		comparisonEntriesSpotA[accountsEntry.CurrencyID] = comparison // this is really the currency symbol
 	}
 
-	// 3.1.2 Init the comparison chart for the spot, hold section
+	// 2.1.2 Init the comparison chart for the spot, hold section
 	comparisonEntriesSpotH := make(map[string]Comparison)
 	for _, accountsEntry := range accountsEntries {
 
@@ -322,41 +498,52 @@ func Compare(cfg *config.Config) {
 			MaybeBalance{decimal.NewFromInt(0), true},
 			accountsEntry.CurrencyID, // okex uses a currency symbol as their currency id
 			0,
+			"",
+			"",
 		}
 		comparisonEntriesSpotH[accountsEntry.CurrencyID] = comparison // this is really the currency symbol
 	}
 
-	// 4. Build the return value
+	// 3. Get the margin balances, but only if the config says that we use margin.
+	comparisonEntriesMargin := make([]Comparison, 0)
+	if cfg.BookwerxConfig.CatMargin != 0 {
+		comparisonEntriesMargin, err = marginComparisons(cfg, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 4. Get the futures and swap balances, for whichever the config says that we use.
+	comparisonEntriesDerivatives, err := derivativesComparisons(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+
+	// 5. Build the return value
 	retValA := make([]Comparison, 0)
 
-	// 4.1 Funding
+	// 5.1 Funding
 	for _, v := range comparisonEntriesFunding {
-		b1, b2 := decimal.RescalePair(v.BookwerxBalance.Balance, v.OKExBalance.Balance)
-		if !b1.Equal(b2) {
-			retValA = append(retValA, v)
-		}
+		retValA = append(retValA, v)
 	}
 
-	// 4.2 Spot
+	// 5.2 Spot
 
-	// 4.2.1 Spot Available
+	// 5.2.1 Spot Available
 	for _, v := range comparisonEntriesSpotA {
-		b1, b2 := decimal.RescalePair(v.BookwerxBalance.Balance, v.OKExBalance.Balance)
-		if !b1.Equal(b2) {
-			retValA = append(retValA, v)
-		}
+		retValA = append(retValA, v)
 	}
 
-	// 4.2.2 Spot Hold
+	// 5.2.2 Spot Hold
 	for _, v := range comparisonEntriesSpotH {
-		b1, b2 := decimal.RescalePair(v.BookwerxBalance.Balance, v.OKExBalance.Balance)
-		if !b1.Equal(b2) {
-			retValA = append(retValA, v)
-		}
+		retValA = append(retValA, v)
 	}
 
-	retValB, _ := json.Marshal(retValA)
+	// 5.3 Margin
+	retValA = append(retValA, comparisonEntriesMargin...)
 
-	fmt.Printf(string(retValB))
+	// 5.4 Futures and swaps
+	retValA = append(retValA, comparisonEntriesDerivatives...)
 
+	return retValA, nil
 }
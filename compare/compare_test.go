@@ -0,0 +1,51 @@
+package compare
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestUnfixed(t *testing.T) {
+	mismatch := func(account string, category string, currency string, okex decimal.Decimal) Comparison {
+		return Comparison{category, MaybeBalance{okex, false}, MaybeBalance{decimal.New(1, 0), false}, currency, 7, account, ""}
+	}
+	btc := mismatch("", "F", "BTC", decimal.New(150, -2))
+	usdt := mismatch("", "F", "USDT", decimal.New(2, 0))
+	sub := mismatch("sub1", "F", "BTC", decimal.New(15, -1))
+	spot := mismatch("", "Spot-Available", "BTC", decimal.New(15, -1))
+
+	// The proposal was made from 1.50 but the same amount, such as 1.5, is the same mismatch.
+	proposals := []Proposal{
+		{Mismatch: mismatch("", "F", "BTC", decimal.New(15, -1)), TransactionID: 1},
+		{Mismatch: usdt}, // not posted
+	}
+
+	got := unfixed([]Comparison{btc, usdt, sub, spot}, proposals)
+	want := []Comparison{usdt, sub, spot}
+	if len(got) != len(want) {
+		t.Fatalf("unfixed = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Key() != want[i].Key() || got[i].Account != want[i].Account {
+			t.Errorf("%d: got %s %s, want %s %s", i, got[i].Account, got[i].Key(), want[i].Account, want[i].Key())
+		}
+	}
+}
+
+func TestSameBalance(t *testing.T) {
+	tests := []struct {
+		a, b MaybeBalance
+		want bool
+	}{
+		{MaybeBalance{decimal.New(150, -2), false}, MaybeBalance{decimal.New(15, -1), false}, true},
+		{MaybeBalance{decimal.New(15, -1), false}, MaybeBalance{decimal.New(16, -1), false}, false},
+		{MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.Zero, false}, false},
+		{MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.New(0, -3), true}, true},
+	}
+	for _, tt := range tests {
+		if got := sameBalance(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameBalance(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
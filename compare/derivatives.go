@@ -0,0 +1,72 @@
+package compare
+
+import (
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+)
+
+// Compare the balances of the futures and perpetual swap accounts, if the config says which categories tag them.
+// Bookwerx has only a single account for each category and currency, so the balances of each currency are summed
+// across the underlyings or instruments.
+//
+// The positions themselves are not booked, but whatever they realise is, by sync swap-ledger.  So the balance that
+// we compare is the equity less the unrealised PnL of the open positions.
+func derivativesComparisons(cfg *config.Config, client *okex.Client) ([]Comparison, error) {
+	bc := cfg.BookwerxConfig
+
+	sections := []struct {
+		name     string
+		category uint32
+		accounts func() ([]okex.DerivativesAccount, error)
+	}{
+		{"Futures", bc.CatFutures, client.FuturesAccounts},
+		{"Swap", bc.CatSwap, client.SwapAccounts},
+	}
+
+	retVal := make([]Comparison, 0)
+	for _, section := range sections {
+		if section.category == 0 {
+			continue
+		}
+
+		// 1. ... from OKEx
+		accounts, err := section.accounts()
+		if err != nil {
+			log.WithField("section", section.name).Error("Cannot execute the accounts API endpoint.")
+			return nil, err
+		}
+
+		okexBalances := make(map[string]decimal.Decimal)
+		for _, a := range accounts {
+			alog := log.WithFields(log.Fields{"section": section.name, "instrument_id": a.InstrumentID})
+			balance, err := decimal.NewFromString(a.Equity)
+			if err != nil {
+				alog.WithError(err).WithField("equity", a.Equity).Error("Cannot parse the equity.")
+				return nil, err
+			}
+			for _, s := range a.UnrealisedPNL {
+				if s == "" {
+					continue
+				}
+				upnl, err := decimal.NewFromString(s)
+				if err != nil {
+					alog.WithError(err).WithField("unrealized_pnl", s).Error("Cannot parse the unrealised PnL.")
+					return nil, err
+				}
+				balance = balance.Sub(upnl)
+			}
+			okexBalances[a.Currency] = okexBalances[a.Currency].Add(balance)
+		}
+
+		// 2. ... from Bookwerx
+		comparisons, err := sectionComparisons(cfg, section.name, []uint32{section.category}, false, okexBalances, "")
+		if err != nil {
+			return nil, err
+		}
+		retVal = append(retVal, comparisons...)
+	}
+
+	return retVal, nil
+}
@@ -0,0 +1,105 @@
+package compare
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+)
+
+func TestDerivativesComparisons(t *testing.T) {
+	futuresAsked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/swap/v3/accounts":
+			fmt.Fprint(w, `{"info":[
+				{"instrument_id":"BTC-USD-SWAP","currency":"BTC","margin_mode":"crossed","equity":"1.5","unrealized_pnl":"0.1"},
+				{"instrument_id":"BTC-USDT-SWAP","currency":"USDT","margin_mode":"fixed","equity":"100",
+					"contracts":[{"unrealized_pnl":"2"},{"unrealized_pnl":"-1"}]}
+			]}`)
+		case "/api/futures/v3/accounts":
+			futuresAsked = true
+			fmt.Fprint(w, `{"info":{
+				"btc-usd":{"currency":"BTC","margin_mode":"crossed","equity":"2","unrealized_pnl":"-0.2"},
+				"eth-usd":{"currency":"ETH","margin_mode":"fixed","equity":"10","contracts":[{"unrealized_pnl":"0.3"},{"unrealized_pnl":"-0.1"}]}
+			}}`)
+		case "/category_dist_sums":
+			switch r.URL.Query().Get("category_id") {
+			case "40": // futures
+				fmt.Fprint(w, `{"sums":[
+					{"account":{"account_id":1,"title":"Futures","currency":{"currency_id":1,"symbol":"BTC"}},"sum":{"amount":22,"exp":-1}},
+					{"account":{"account_id":2,"title":"Futures","currency":{"currency_id":3,"symbol":"LTC"}},"sum":{"amount":1,"exp":0}}
+				]}`)
+			case "41": // swap
+				fmt.Fprint(w, `{"sums":[
+					{"account":{"account_id":3,"title":"Swap","currency":{"currency_id":1,"symbol":"BTC"}},"sum":{"amount":14,"exp":-1}},
+					{"account":{"account_id":4,"title":"Swap","currency":{"currency_id":2,"symbol":"USDT"}},"sum":{"amount":98,"exp":0}}
+				]}`)
+			default:
+				fmt.Fprint(w, `{"sums":[]}`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := &okex.Client{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	type want struct {
+		category string
+		currency string
+		okex     string // "" if OKEx has nothing
+		bookwerx string // "" if Bookwerx has nothing
+	}
+	check := func(name string, comparisons []Comparison, wants []want) {
+		if len(comparisons) != len(wants) {
+			t.Fatalf("%s: got %d comparisons, want %d: %+v", name, len(comparisons), len(wants), comparisons)
+		}
+		balance := func(b MaybeBalance) string {
+			if b.Nil {
+				return ""
+			}
+			return b.Balance.String()
+		}
+		for i, w := range wants {
+			c := comparisons[i]
+			if c.Category != w.category || c.CurrencySymbol != w.currency || balance(c.OKExBalance) != w.okex || balance(c.BookwerxBalance) != w.bookwerx {
+				t.Errorf("%s %d: got %s %s %s %s, want %+v", name, i, c.Category, c.CurrencySymbol, balance(c.OKExBalance), balance(c.BookwerxBalance), w)
+			}
+		}
+	}
+
+	// The crossed accounts are compared less the unrealised PnL of the whole account, and the fixed ones less that of
+	// every contract.
+	cfg := &config.Config{BookwerxConfig: config.BookwerxConfig{BaseURL: server.URL, CatFutures: 40, CatSwap: 41}}
+	comparisons, err := derivativesComparisons(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("both", comparisons, []want{
+		{"Futures", "BTC", "2.2", "2.2"},
+		{"Futures", "ETH", "9.8", ""},
+		{"Futures", "LTC", "", "1"},
+		{"Swap", "BTC", "1.4", "1.4"},
+		{"Swap", "USDT", "99", "98"},
+	})
+	if comparisons[4].Status(config.CompareConfig{Dust: decimal.New(1, -8)}) != StatusMismatch {
+		t.Errorf("Swap USDT: status = %s, want %s", comparisons[4].Status(config.CompareConfig{}), StatusMismatch)
+	}
+
+	// Without a category, the futures aren't even asked about.
+	futuresAsked = false
+	cfg.BookwerxConfig.CatFutures = 0
+	comparisons, err = derivativesComparisons(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("swap only", comparisons, []want{{"Swap", "BTC", "1.4", "1.4"}, {"Swap", "USDT", "99", "98"}})
+	if futuresAsked {
+		t.Errorf("asked OKEx about the futures")
+	}
+}
@@ -0,0 +1,146 @@
+package compare
+
+import (
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// The category of the comparisons of what's borrowed.  Unlike the others, it's something that we owe.
+const MarginBorrowed = "Margin-Borrowed"
+
+// Compare the margin balances.  OKEx keeps a margin account for each instrument.  Bookwerx may have an account for
+// each instrument too, whose title names it, such as "Margin BTC-USDT".  If so then compare each instrument with its
+// own account.  Whatever's left is summed, for each currency, across the instruments and compared with the account
+// whose title names no instrument.
+//
+// What's borrowed, plus its accrued interest, is a liability.  Bookwerx records it as a credit, so we flip its sign in
+// order to compare it with what OKEx says.
+func marginComparisons(cfg *config.Config, client *okex.Client) ([]Comparison, error) {
+	bc := cfg.BookwerxConfig
+
+	// 1. ... from OKEx
+	marginAccounts, err := client.MarginAccounts()
+	if err != nil {
+		log.Error("Cannot execute the margin accounts API endpoint.")
+		return nil, err
+	}
+
+	sections := []struct {
+		name     string
+		category uint32
+		credit   bool
+		balance  func(b okex.MarginBalance) []string
+	}{
+		{"Margin-Available", bc.CatMargin, false, func(b okex.MarginBalance) []string { return []string{b.Available} }},
+		{"Margin-Hold", bc.CatMarginHold, false, func(b okex.MarginBalance) []string { return []string{b.Hold} }},
+		{MarginBorrowed, bc.CatMarginBorrowed, true, func(b okex.MarginBalance) []string { return []string{b.Borrowed, b.LendingFee} }},
+	}
+
+	retVal := make([]Comparison, 0)
+	for _, section := range sections {
+
+		// 1.1 The balances of each instrument and currency.
+		okexBalances := make(map[instrumentCurrency]decimal.Decimal)
+		for _, a := range marginAccounts {
+			for currency, b := range a.Balances {
+				sum := decimal.Zero
+				for _, s := range section.balance(b) {
+					if s == "" {
+						continue
+					}
+					d, err := decimal.NewFromString(s)
+					if err != nil {
+						log.WithError(err).WithFields(log.Fields{"instrument_id": a.InstrumentID, "currency": currency, "balance": s}).Error("Cannot parse the margin balance.")
+						return nil, err
+					}
+					sum = sum.Add(d)
+				}
+				okexBalances[instrumentCurrency{strings.ToUpper(a.InstrumentID), currency}] = sum
+			}
+		}
+
+		// 2. ... from Bookwerx, if the config says which category to use.
+		comparisons, err := instrumentComparisons(cfg, section.name, section.category, section.credit, okexBalances, "")
+		if err != nil {
+			return nil, err
+		}
+		retVal = append(retVal, comparisons...)
+	}
+
+	return retVal, nil
+}
+
+// A margin instrument, or "" for all those that Bookwerx has no account of their own for, and a currency.
+type instrumentCurrency struct {
+	instrument string
+	currency   string
+}
+
+// Compare the margin balances of a single section, given what OKEx says about each instrument and currency, with the
+// balances of the Bookwerx accounts tagged with the given category, if any, as of the given time, or now if asOf is "".
+// An instrument that has its own Bookwerx account, for the currency, is compared with that.  The others are summed
+// and compared with the accounts that name no instrument.  Return the comparisons sorted by currency and instrument.
+func instrumentComparisons(cfg *config.Config, section string, category uint32, credit bool, okexBalances map[instrumentCurrency]decimal.Decimal, asOf string) ([]Comparison, error) {
+	entries := make(map[instrumentCurrency]Comparison)
+
+	// 1. What Bookwerx says about each instrument, or about none of them.
+	if category != 0 {
+		sums, err := bookwerx.CategoryDistSums(*cfg, category, "", asOf)
+		if err != nil {
+			log.WithField("section", section).Error("Cannot execute the getCategoryDistSums API endpoint.")
+			return nil, err
+		}
+
+		for _, brd := range sums {
+			b1 := brd.Sum.Decimal()
+			if credit {
+				b1 = b1.Neg()
+			}
+			key := instrumentCurrency{bookwerx.TitleInstrument(brd.Account.Title), brd.Account.Currency.Symbol}
+			c, ok := entries[key]
+			if !ok {
+				c = Comparison{section, MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.Zero, true}, key.currency, 0, "", key.instrument}
+			}
+			c.BookwerxBalance = addMaybe(c.BookwerxBalance, MaybeBalance{b1, false})
+			if c.AccountID == 0 {
+				c.AccountID = brd.Account.AccountID
+			}
+			entries[key] = c
+		}
+	}
+
+	// 2. What OKEx says, about the instrument if Bookwerx has an account for it, or else about none of them.
+	for key, b := range okexBalances {
+		if _, ok := entries[key]; !ok {
+			key.instrument = ""
+		}
+		c, ok := entries[key]
+		if !ok {
+			c = Comparison{section, MaybeBalance{decimal.Zero, true}, MaybeBalance{decimal.Zero, true}, key.currency, 0, "", ""}
+		}
+		c.OKExBalance = addMaybe(c.OKExBalance, MaybeBalance{b, false})
+		entries[key] = c
+	}
+
+	keys := make([]instrumentCurrency, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].currency != keys[j].currency {
+			return keys[i].currency < keys[j].currency
+		}
+		return keys[i].instrument < keys[j].instrument
+	})
+
+	retVal := make([]Comparison, 0, len(keys))
+	for _, key := range keys {
+		retVal = append(retVal, entries[key])
+	}
+	return retVal, nil
+}
@@ -0,0 +1,62 @@
+package compare
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+func TestInstrumentComparisons(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sums":[
+			{"account":{"account_id":1,"title":"Margin BTC-USDT","currency":{"currency_id":1,"symbol":"USDT"}},"sum":{"amount":100,"exp":0}},
+			{"account":{"account_id":2,"title":"Margin","currency":{"currency_id":1,"symbol":"USDT"}},"sum":{"amount":7,"exp":0}},
+			{"account":{"account_id":3,"title":"Margin","currency":{"currency_id":2,"symbol":"BTC"}},"sum":{"amount":2,"exp":0}}
+		]}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{BookwerxConfig: config.BookwerxConfig{BaseURL: server.URL}}
+	okexBalances := map[instrumentCurrency]decimal.Decimal{
+		{"BTC-USDT", "USDT"}: decimal.New(100, 0),
+		{"BTC-USDT", "BTC"}:  decimal.New(1, 0),
+		{"ETH-USDT", "USDT"}: decimal.New(3, 0),
+		{"LTC-USDT", "USDT"}: decimal.New(4, 0),
+	}
+
+	comparisons, err := instrumentComparisons(cfg, "Margin-Available", 8, false, okexBalances, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BTC-USDT has its own USDT account, so it's compared alone.  The other instruments are summed, and so is BTC-USDT
+	// for BTC, which has no account of its own.
+	want := []struct {
+		instrument string
+		currency   string
+		okex       int64
+		bookwerx   int64
+		accountID  uint32
+	}{
+		{"", "BTC", 1, 2, 3},
+		{"", "USDT", 7, 7, 2},
+		{"BTC-USDT", "USDT", 100, 100, 1},
+	}
+	if len(comparisons) != len(want) {
+		t.Fatalf("got %d comparisons, want %d: %v", len(comparisons), len(want), comparisons)
+	}
+	for i, w := range want {
+		c := comparisons[i]
+		if c.Instrument != w.instrument || c.CurrencySymbol != w.currency || c.AccountID != w.accountID ||
+			!c.OKExBalance.Balance.Equal(decimal.New(w.okex, 0)) || !c.BookwerxBalance.Balance.Equal(decimal.New(w.bookwerx, 0)) {
+			t.Errorf("%d: got %+v, want %+v", i, c, w)
+		}
+	}
+	if comparisons[2].Key() != "Margin-Available:BTC-USDT:USDT" || comparisons[1].Key() != "Margin-Available:USDT" {
+		t.Errorf("keys = %s, %s", comparisons[2].Key(), comparisons[1].Key())
+	}
+}
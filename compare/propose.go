@@ -0,0 +1,116 @@
+package compare
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/okex"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+// Every adjusting transaction has this in its notes, so that they can all be found, audited, and reversed.
+const AdjustmentTag = "[okconnect adjustment"
+
+// A balanced transaction that would make Bookwerx agree with OKEx about a mismatch, by moving the difference between
+// its Bookwerx account and the suspense account.
+type Proposal struct {
+	Mismatch      Comparison
+	Transaction   *bookwerx.Transaction
+	TransactionID uint32 `json:",omitempty"` // Once it's posted
+
+	cfg *config.Config // The config of the OKEx account that has the mismatch
+}
+
+// Propose an adjusting transaction for each of the given mismatches.  If OKEx says that we have more:
+//
+// DR The account  difference
+// CR Suspense     difference
+//
+// and the other way around if less.  A mismatch that has no Bookwerx account cannot be adjusted, so no transaction is
+// proposed for it.
+func Propose(cfg *config.Config, client *okex.Client, mismatches []Comparison) ([]Proposal, error) {
+	retVal := make([]Proposal, 0)
+	if len(mismatches) == 0 {
+		return retVal, nil
+	}
+	if cfg.BookwerxConfig.CatSuspense == 0 {
+		log.Error("The config must say which category is cat_suspense in order to propose adjustments.")
+		return nil, fmt.Errorf("compare:propose.go:Propose: no cat_suspense")
+	}
+
+	clientB := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond)
+	for _, c := range mismatches {
+		clog := log.WithFields(log.Fields{"category": c.Category, "currency": c.CurrencySymbol, "difference": c.Difference().String()})
+		if c.AccountID == 0 {
+			clog.Warn("Bookwerx has no account for this, so no adjustment can be proposed.")
+			continue
+		}
+
+		suspenseID, err := bookwerx.FindCategoryAccount(clientB, cfg.BookwerxConfig.CatSuspense, c.CurrencySymbol, *cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		adjustment := c.adjustment()
+		notes := fmt.Sprintf("Adjust %s %s by %s %s okex=%s bookwerx=%s]", c.Category, c.CurrencySymbol,
+			adjustment.String(), AdjustmentTag, c.OKExBalance.Balance.String(), c.BookwerxBalance.Balance.String())
+		tx := bookwerx.NewTransaction(client.Timestamp(), notes)
+		tx.Add(c.AccountID, c.CurrencySymbol, adjustment).Add(suspenseID, c.CurrencySymbol, adjustment.Neg())
+		err = tx.Balanced()
+		if err != nil {
+			clog.WithError(err).Error("Cannot propose an adjustment for this.")
+			return nil, err
+		}
+
+		retVal = append(retVal, Proposal{Mismatch: c, Transaction: tx, cfg: cfg})
+	}
+	return retVal, nil
+}
+
+// Post the proposed transactions, once the user has agreed to, unless yes is true.  Each posted proposal is given its
+// transaction id.  Return how many were posted.
+func apply(proposals []Proposal, yes bool) (int, error) {
+	if len(proposals) == 0 {
+		return 0, nil
+	}
+
+	if !yes {
+		b, _ := json.MarshalIndent(proposals, "", "  ")
+		fmt.Fprintln(os.Stderr, string(b))
+		fmt.Fprintf(os.Stderr, "Post these %d adjusting transactions to Bookwerx? [y/N] ", len(proposals))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			log.Info("Not posting the adjustments.")
+			return 0, nil
+		}
+	}
+
+	posted := 0
+	for i := range proposals {
+		err := proposals[i].Post()
+		if err != nil {
+			return posted, err
+		}
+		posted++
+	}
+	return posted, nil
+}
+
+// Post the proposed transaction to Bookwerx and remember its id.
+func (p *Proposal) Post() error {
+	clientB := okchttp.GetHeimdallClient("bookwerx", p.cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond)
+	txid, err := p.Transaction.Post(clientB, *p.cfg)
+	if err != nil {
+		return err
+	}
+	p.TransactionID = txid
+	log.WithFields(log.Fields{"category": p.Mismatch.Category, "currency": p.Mismatch.CurrencySymbol, "transaction_id": txid}).Info("Posted the adjustment.")
+	return nil
+}
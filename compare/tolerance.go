@@ -0,0 +1,98 @@
+package compare
+
+import (
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// How do the OKEx and Bookwerx balances compare?
+const (
+	StatusMatch     = "match"
+	StatusTolerated = "within tolerance"
+	StatusMismatch  = "mismatch"
+)
+
+// How much more OKEx says that we have than Bookwerx does.
+func (c Comparison) Difference() decimal.Decimal {
+	return c.OKExBalance.Balance.Sub(c.BookwerxBalance.Balance)
+}
+
+// How much must the Bookwerx account be debited to agree with OKEx?  What we owe is a credit in Bookwerx, so that's
+// the other way around.
+func (c Comparison) adjustment() decimal.Decimal {
+	if c.Category == MarginBorrowed {
+		return c.Difference().Neg()
+	}
+	return c.Difference()
+}
+
+// Do the balances match, or are they merely within tolerance, or not even that?  A difference is within tolerance if
+// it's no bigger than the dust, or than the absolute tolerance of its currency, or than the relative tolerance of its
+// currency times the OKEx balance.
+func (c Comparison) Status(cc config.CompareConfig) string {
+	if c.Matches() {
+		return StatusMatch
+	}
+
+	diff := c.Difference().Abs()
+	if diff.LessThanOrEqual(cc.Dust) {
+		return StatusTolerated
+	}
+
+	t, ok := cc.Tolerances[c.CurrencySymbol]
+	if ok {
+		if diff.LessThanOrEqual(t.Absolute) || diff.LessThanOrEqual(t.Relative.Mul(c.OKExBalance.Balance.Abs())) {
+			return StatusTolerated
+		}
+	}
+
+	return StatusMismatch
+}
+
+// Make Bookwerx agree exactly with OKEx about each of the given comparisons, which should be within tolerance, by
+// moving the difference between its Bookwerx account and the rounding account.  If OKEx says that we have more:
+//
+// DR The account  difference
+// CR Rounding     difference
+//
+// and the other way around if less.
+func adjustRounding(cfg *config.Config, client *okex.Client, tolerated []Comparison) error {
+	if len(tolerated) == 0 {
+		return nil
+	}
+	if cfg.BookwerxConfig.CatRounding == 0 {
+		log.Error("The config must say which category is cat_rounding in order to adjust for rounding.")
+		return fmt.Errorf("compare:tolerance.go:adjustRounding: no cat_rounding")
+	}
+
+	clientB := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond)
+	for _, c := range tolerated {
+		clog := log.WithFields(log.Fields{"category": c.Category, "currency": c.CurrencySymbol, "difference": c.Difference().String()})
+		if c.AccountID == 0 {
+			clog.Warn("Bookwerx has no account for this, so it cannot be adjusted.")
+			continue
+		}
+
+		roundingID, err := bookwerx.FindCategoryAccount(clientB, cfg.BookwerxConfig.CatRounding, c.CurrencySymbol, *cfg)
+		if err != nil {
+			return err
+		}
+
+		adjustment := c.adjustment()
+		notes := fmt.Sprintf("Rounding adjustment %s %s for %s", adjustment.String(), c.CurrencySymbol, c.Category)
+		tx := bookwerx.NewTransaction(client.Timestamp(), notes)
+		tx.Add(c.AccountID, c.CurrencySymbol, adjustment).Add(roundingID, c.CurrencySymbol, adjustment.Neg())
+		txid, err := tx.Post(clientB, *cfg)
+		if err != nil {
+			return err
+		}
+		clog.WithField("transaction_id", txid).Info("Booked the rounding adjustment.")
+	}
+	return nil
+}
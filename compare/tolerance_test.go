@@ -0,0 +1,50 @@
+package compare
+
+import (
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+func TestStatus(t *testing.T) {
+	cc := config.CompareConfig{
+		Dust: decimal.New(1, -8),
+		Tolerances: map[string]config.Tolerance{
+			"BTC":  {Absolute: decimal.New(1, -6)},
+			"USDT": {Relative: decimal.New(1, -4)},
+		},
+	}
+	d := decimal.RequireFromString
+	comparison := func(currency string, okex string, bookwerx string) Comparison {
+		return Comparison{"F", MaybeBalance{d(okex), false}, MaybeBalance{d(bookwerx), false}, currency, 7, "", ""}
+	}
+
+	tests := []struct {
+		name string
+		c    Comparison
+		want string
+	}{
+		{"exactly", comparison("BTC", "1.5", "1.50"), StatusMatch},
+		{"dust, in any currency", comparison("LTC", "1.00000001", "1"), StatusTolerated},
+		{"more than dust", comparison("LTC", "1.00000002", "1"), StatusMismatch},
+		{"within the absolute tolerance", comparison("BTC", "1", "1.000001"), StatusTolerated},
+		{"beyond the absolute tolerance", comparison("BTC", "1", "1.0000011"), StatusMismatch},
+		{"within the relative tolerance", comparison("USDT", "10000", "10001"), StatusTolerated},
+		{"beyond the relative tolerance", comparison("USDT", "10000", "10001.01"), StatusMismatch},
+		{"relative to the size of a negative balance", comparison("USDT", "-10000", "-10001"), StatusTolerated},
+		{"relative to the OKEx balance", comparison("USDT", "0", "1"), StatusMismatch},
+		{"BTC has no relative tolerance", comparison("BTC", "10000", "10000.5"), StatusMismatch},
+		{"nothing at OKEx", Comparison{"F", MaybeBalance{decimal.Zero, true}, MaybeBalance{d("0.000000001"), false}, "BTC", 7, "", ""}, StatusTolerated},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Status(cc); got != tt.want {
+			t.Errorf("%s: Status = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// With no tolerances, only an exact match will do.
+	if got := comparison("BTC", "1", "1.00000001").Status(config.CompareConfig{}); got != StatusMismatch {
+		t.Errorf("no tolerances: Status = %s, want %s", got, StatusMismatch)
+	}
+}
@@ -2,20 +2,45 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/credstore"
+	"github.com/bostontrader/okconnect/logging"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 	"io/ioutil"
+	"strings"
 )
 
+// What we call the OKEx account configured by OKExConfig itself, as opposed to any of OKExConfig.Accounts.
+const MainAccount = "main"
+
 // OKConnect needs to talk to an OKEx server and a bookwerx-core-rust server.
 type Config struct {
 	BookwerxConfig BookwerxConfig
 	OKExConfig     OKExConfig
+	AlertConfig    AlertConfig
+	LotsConfig     LotsConfig
+	CompareConfig  CompareConfig
+
+	// Where shall we remember the state-changing requests made to OKEx?  If empty, use journal.DefaultPath.
+	Journal string
+
+	// Where shall we remember how far we have booked each OKEx ledger?  If empty, use ledger.DefaultCursorsPath.
+	Cursors string
+
+	// Where shall we keep the snapshots saved by compare -save-snapshot?  If empty, use snapshot.DefaultDir.
+	Snapshots string
+
+	// Named variations of this config, such as sandbox.  Each may say anything that the config itself says and whatever
+	// it says replaces what the config says.  See UseProfile.
+	Profiles map[string]yaml.Node
 }
 
 // What does OKConnect need to know in order to communicate with a bookwerx-core-rust server?
 type BookwerxConfig struct {
-	APIKey  string
+	APIKey  string // either the key itself or a secret reference such as env:BOOKWERX_APIKEY.  See SecretProvider.
 	BaseURL string `yaml:"base_url"` // for example: http:185.183.96.73:3003
 
 	// Any user account that is a...
@@ -28,28 +53,345 @@ type BookwerxConfig struct {
 	// ... spot hold account shall be tagged with this category
 	CatSpotHold uint32 `yaml:"cat_spot_hold"`
 
+	// ... account for coins on their way into OKEx, the other side of a deposit, shall be tagged with this category
+	CatInTransit uint32 `yaml:"cat_in_transit"`
+
+	// ... account for the other side of a spot trade, one per currency, shall be tagged with this category
+	CatTrading uint32 `yaml:"cat_trading"`
+
+	// ... fee expense account shall be tagged with this category
+	CatFee uint32 `yaml:"cat_fee"`
+
+	// ... futures account shall be tagged with this category
+	CatFutures uint32 `yaml:"cat_futures"`
+
+	// ... C2C account shall be tagged with this category
+	CatC2C uint32 `yaml:"cat_c2c"`
+
+	// ... margin account, for the available balance, shall be tagged with this category
+	CatMargin uint32 `yaml:"cat_margin"`
+
+	// ... margin hold account shall be tagged with this category
+	CatMarginHold uint32 `yaml:"cat_margin_hold"`
+
+	// ... margin loan account, a liability for what's borrowed and its interest, shall be tagged with this category
+	CatMarginBorrowed uint32 `yaml:"cat_margin_borrowed"`
+
+	// ... margin interest expense account shall be tagged with this category
+	CatMarginInterest uint32 `yaml:"cat_margin_interest"`
+
+	// ... perpetual swap account shall be tagged with this category
+	CatSwap uint32 `yaml:"cat_swap"`
+
+	// ... account for the realised PnL of the perpetual swaps, a revenue, shall be tagged with this category
+	CatSwapPNL uint32 `yaml:"cat_swap_pnl"`
+
+	// ... account for the perpetual swap funding fees, an expense, shall be tagged with this category
+	CatSwapFunding uint32 `yaml:"cat_swap_funding"`
+
+	// ... account for the perpetual swap liquidation fees, an expense, shall be tagged with this category
+	CatSwapLiquidation uint32 `yaml:"cat_swap_liquidation"`
+
+	// ... options account shall be tagged with this category
+	CatOptions uint32 `yaml:"cat_options"`
+
+	// For the reports, any user account that is one of the...
+	// ... assets shall be tagged with this category
+	CatAssets uint32 `yaml:"cat_assets"`
+
+	// ... liabilities shall be tagged with this category
+	CatLiabilities uint32 `yaml:"cat_liabilities"`
+
+	// ... equity shall be tagged with this category
+	CatEquity uint32 `yaml:"cat_equity"`
+
+	// ... revenue shall be tagged with this category
+	CatRevenue uint32 `yaml:"cat_revenue"`
+
+	// ... expenses shall be tagged with this category
+	CatExpenses uint32 `yaml:"cat_expenses"`
+
+	// For a revaluation, any user account that...
+	// ... holds the market value of the other currencies, in a quote currency, shall be tagged with this category
+	CatRevaluation uint32 `yaml:"cat_revaluation"`
+
+	// ... holds the unrealised gain or loss, in a quote currency, shall be tagged with this category
+	CatUnrealisedGain uint32 `yaml:"cat_unrealised_gain"`
+
+	// For compare, any user account that...
+	// ... absorbs the differences that are within tolerance, when asked to, shall be tagged with this category
+	CatRounding uint32 `yaml:"cat_rounding"`
+
+	// ... absorbs the mismatches, when asked to adjust for them, shall be tagged with this category
+	CatSuspense uint32 `yaml:"cat_suspense"`
+
+	// For the lots, any user account that...
+	// ... holds the realised gains, a revenue, shall be tagged with this category
+	CatRealisedGain uint32 `yaml:"cat_realised_gain"`
+
+	// ... holds the realised losses, an expense, shall be tagged with this category
+	CatRealisedLoss uint32 `yaml:"cat_realised_loss"`
+
 	// Any transaction that is a...
 	// ... deposit into OKEx funding shall be tagged with this category
 	CatDeposit uint32 `yaml:"cat_deposit"`
 }
 
 type OKExConfig struct {
-	Credentials string
+	Credentials string // either a filename or a secret reference such as exec:pass show okex.  See SecretProvider.
 	BaseURL     string `yaml:"base_url"` // for example: https:www.okex.com
+	WSURL       string `yaml:"ws_url"`   // for example: wss://real.okex.com:8443/ws/v3
+
+	// The spot instruments, such as BTC-USDT, whose fills shall be booked.
+	Instruments []string
+
+	// The perpetual swap instruments, such as BTC-USD-SWAP, whose ledgers shall be booked.
+	SwapInstruments []string `yaml:"swap_instruments"`
+
+	// Any other OKEx accounts, such as sub-accounts, that compare and transfer shall also look after.
+	Accounts []AccountConfig
+}
+
+// Another OKEx account, such as a sub-account, with its own credentials and its own Bookwerx accounts.
+type AccountConfig struct {
+	Name        string // what we call it, such as sub1
+	SubAccount  string `yaml:"sub_account"` // what OKEx calls it, if it's a sub-account.  If empty, use the Name.
+	Credentials string // either a filename or a secret reference.  See SecretProvider.
+
+	// The categories that tag this account's Bookwerx accounts, instead of those in BookwerxConfig.  If one is not
+	// given then this account does not use that kind of OKEx account, so it's neither compared nor transferred to.
+	CatFunding        uint32 `yaml:"cat_funding"`
+	CatSpotAvailable  uint32 `yaml:"cat_spot_available"`
+	CatSpotHold       uint32 `yaml:"cat_spot_hold"`
+	CatFutures        uint32 `yaml:"cat_futures"`
+	CatC2C            uint32 `yaml:"cat_c2c"`
+	CatMargin         uint32 `yaml:"cat_margin"`
+	CatMarginHold     uint32 `yaml:"cat_margin_hold"`
+	CatMarginBorrowed uint32 `yaml:"cat_margin_borrowed"`
+	CatSwap           uint32 `yaml:"cat_swap"`
+	CatOptions        uint32 `yaml:"cat_options"`
+
+	// How closely must this account's balances agree, instead of as CompareConfig says?
+	CompareConfig *CompareConfig `yaml:"compareconfig"`
+}
+
+// How shall we figure the cost basis of what we sell, and thus the realised gains?  If Method is empty, we don't.
+type LotsConfig struct {
+	Method string // fifo, lifo, or average
+	Quote  string // the currency in which to figure the costs and the gains.  If empty, USDT.
+
+	// Where shall we remember the open lots?  If empty, use lots.DefaultStatePath.
+	State string
+}
+
+// How closely must the OKEx and Bookwerx balances agree?  If nothing is said, exactly.
+type CompareConfig struct {
+	// A difference no bigger than this, in any currency, is merely dust.
+	Dust decimal.Decimal
+
+	// The tolerances of particular currencies, such as BTC.
+	Tolerances map[string]Tolerance
+}
+
+// A difference is within tolerance if it's no bigger than either of these.  Zero means no tolerance.
+type Tolerance struct {
+	Absolute decimal.Decimal
+	Relative decimal.Decimal // a fraction, such as 0.0001, of the OKEx balance
+}
+
+// Who shall we tell when compare finds a mismatch?  Any notifier that isn't configured is not used.
+type AlertConfig struct {
+	// Where shall we remember the mismatches between runs?  If empty, use alert.DefaultStatePath.
+	State string
+
+	// Also alert when a mismatch has lasted for this many runs of compare, or cycles of watch.  If 0, don't.
+	PersistentCycles int `yaml:"persistent_cycles"`
+
+	Webhook WebhookConfig
+	SMTP    SMTPConfig `yaml:"smtp"`
+	Exec    ExecConfig
+}
+
+// POST the alert, as JSON, to this URL.
+type WebhookConfig struct {
+	URL string
+}
+
+// Email the alert.
+type SMTPConfig struct {
+	Addr     string   // for example: smtp.example.com:587
+	Username string   // if the server wants us to login
+	Password string   // either the password itself or a secret reference.  See SecretProvider.
+	From     string   // for example: okconnect@example.com
+	To       []string // for example: [ops@example.com]
+}
+
+// Run this command, without a shell, and give it the alert, as JSON, on stdin.
+type ExecConfig struct {
+	Command string // for example: /usr/local/bin/page-someone --severity high
+}
+
+// Replace whatever the named profile says in a copy of this config.
+func (cfg *Config) UseProfile(name string) (*Config, error) {
+	node, ok := cfg.Profiles[name]
+	if !ok {
+		log.WithField("profile", name).Error("The config does not have this profile.")
+		return nil, errors.New("config: no such profile " + name)
+	}
+
+	// The profile is decoded over a copy of this config, so the copy must not share any map with it.  Slices are
+	// replaced, not merged, so they needn't be copied.
+	retVal := *cfg
+	retVal.Profiles = nil
+	if cfg.CompareConfig.Tolerances != nil {
+		retVal.CompareConfig.Tolerances = make(map[string]Tolerance, len(cfg.CompareConfig.Tolerances))
+		for currency, t := range cfg.CompareConfig.Tolerances {
+			retVal.CompareConfig.Tolerances[currency] = t
+		}
+	}
+	err := node.Decode(&retVal)
+	if err != nil {
+		log.WithError(err).WithField("profile", name).Error("Cannot parse the profile.")
+		return nil, err
+	}
+	return &retVal, nil
+}
+
+// The main account, as configured by OKExConfig and BookwerxConfig, followed by every other account.
+func (cfg *Config) AllAccounts() []AccountConfig {
+	bc := cfg.BookwerxConfig
+	main := AccountConfig{
+		Name:              MainAccount,
+		Credentials:       cfg.OKExConfig.Credentials,
+		CatFunding:        bc.CatFunding,
+		CatSpotAvailable:  bc.CatSpotAvailable,
+		CatSpotHold:       bc.CatSpotHold,
+		CatFutures:        bc.CatFutures,
+		CatC2C:            bc.CatC2C,
+		CatMargin:         bc.CatMargin,
+		CatMarginHold:     bc.CatMarginHold,
+		CatMarginBorrowed: bc.CatMarginBorrowed,
+		CatSwap:           bc.CatSwap,
+		CatOptions:        bc.CatOptions,
+	}
+	return append([]AccountConfig{main}, cfg.OKExConfig.Accounts...)
+}
+
+// Find the account with the given name, which may be MainAccount.
+func (cfg *Config) Account(name string) (AccountConfig, bool) {
+	for _, a := range cfg.AllAccounts() {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return AccountConfig{}, false
+}
+
+// Return a copy of this config that uses the credentials, the categories, and the tolerances of the given account
+// instead.
+func (cfg Config) ForAccount(a AccountConfig) *Config {
+	cfg.OKExConfig.Credentials = a.Credentials
+	cfg.BookwerxConfig.CatFunding = a.CatFunding
+	cfg.BookwerxConfig.CatSpotAvailable = a.CatSpotAvailable
+	cfg.BookwerxConfig.CatSpotHold = a.CatSpotHold
+	cfg.BookwerxConfig.CatFutures = a.CatFutures
+	cfg.BookwerxConfig.CatC2C = a.CatC2C
+	cfg.BookwerxConfig.CatMargin = a.CatMargin
+	cfg.BookwerxConfig.CatMarginHold = a.CatMarginHold
+	cfg.BookwerxConfig.CatMarginBorrowed = a.CatMarginBorrowed
+	cfg.BookwerxConfig.CatSwap = a.CatSwap
+	cfg.BookwerxConfig.CatOptions = a.CatOptions
+	if a.CompareConfig != nil {
+		cfg.CompareConfig = *a.CompareConfig
+	}
+	return &cfg
+}
+
+// If the config refers to any secrets, instead of containing them, fetch them now.
+// The OKEx credentials are left alone.  Only the commands that need them should ask for them, using ReadCredentials.
+func (cfg *Config) ResolveSecrets() error {
+	if IsSecretRef(cfg.BookwerxConfig.APIKey) {
+		apikey, err := ResolveSecret(cfg.BookwerxConfig.APIKey)
+		if err != nil {
+			log.WithError(err).Error("Cannot get the Bookwerx API key.")
+			return err
+		}
+		cfg.BookwerxConfig.APIKey = string(apikey)
+	}
+	logging.AddSecret(cfg.BookwerxConfig.APIKey)
+
+	if IsSecretRef(cfg.AlertConfig.SMTP.Password) {
+		password, err := ResolveSecret(cfg.AlertConfig.SMTP.Password)
+		if err != nil {
+			log.WithError(err).Error("Cannot get the SMTP password.")
+			return err
+		}
+		cfg.AlertConfig.SMTP.Password = string(password)
+	}
+	logging.AddSecret(cfg.AlertConfig.SMTP.Password)
+
+	return nil
+}
+
+// Get the credentials for OKEx or the OKCatbox, given either a secret reference or the name of a credentials file.
+func ReadCredentials(ref string) (*utils.Credentials, error) {
+	if !IsSecretRef(ref) {
+		return ReadCredentialsFile(ref)
+	}
+
+	data, err := ResolveSecret(ref)
+	if err != nil {
+		log.WithError(err).Error("Cannot get the OKEx credentials.")
+		return nil, err
+	}
+
+	if strings.HasPrefix(ref, "file:") {
+		log.WithField("credentials", ref).Warn("The credentials are not encrypted. That's ok for an OKCatbox, but for real OKEx credentials use okconnect credentials import.")
+	}
+
+	var obj utils.Credentials
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		log.WithError(err).WithField("credentials", ref).Error("Cannot parse the credentials.")
+		return nil, err
+	}
+	addCredentialSecrets(&obj)
+	return &obj, nil
 }
 
 // Read the given credentials file for OKEx or the OKCatbox.
+// This should usually be an encrypted file made by okconnect credentials import.  A plaintext JSON file is ok for
+// an OKCatbox but we'll complain about it.
 func ReadCredentialsFile(keyFile string) (*utils.Credentials, error) {
 	var obj utils.Credentials
 	data, err := ioutil.ReadFile(keyFile)
 	if err != nil {
-		fmt.Printf("compare.go:readCredentials: %v\n", err)
+		log.WithError(err).WithField("credentials", keyFile).Error("Cannot read the credentials file.")
 		return nil, err
 	}
+
+	if credstore.IsEncrypted(data) {
+		credentials, err := credstore.Unlock(keyFile)
+		if err != nil {
+			log.WithError(err).WithField("credentials", keyFile).Error("Cannot unlock the credentials file.")
+			return nil, err
+		}
+		addCredentialSecrets(credentials)
+		return credentials, nil
+	}
+
+	log.WithField("credentials", keyFile).Warn("The credentials file is not encrypted. That's ok for an OKCatbox, but for real OKEx credentials use okconnect credentials import.")
 	err = json.Unmarshal(data, &obj)
 	if err != nil {
-		fmt.Printf("compare.go:readCredentials: Cannot parse the credentials file.\n")
+		log.WithError(err).WithField("credentials", keyFile).Error("Cannot parse the credentials file.")
 		return nil, err
 	}
+	addCredentialSecrets(&obj)
 	return &obj, nil
 }
+
+// Make sure the secret parts of the credentials never appear in the logs.
+func addCredentialSecrets(credentials *utils.Credentials) {
+	logging.AddSecret(credentials.SecretKey)
+	logging.AddSecret(credentials.Passphrase)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+)
+
+func TestForAccount(t *testing.T) {
+	cfg := &Config{CompareConfig: CompareConfig{Dust: decimal.New(1, -8)}}
+	cfg.OKExConfig.Accounts = []AccountConfig{
+		{Name: "sub1", Credentials: "sub1.credentials", CatFunding: 31},
+		{Name: "sub2", CompareConfig: &CompareConfig{Dust: decimal.New(1, -2)}},
+	}
+
+	main := cfg.ForAccount(cfg.AllAccounts()[0])
+	if !main.CompareConfig.Dust.Equal(decimal.New(1, -8)) {
+		t.Errorf("main: dust = %s, want 0.00000001", main.CompareConfig.Dust)
+	}
+
+	sub1, _ := cfg.Account("sub1")
+	cfg1 := cfg.ForAccount(sub1)
+	if cfg1.OKExConfig.Credentials != "sub1.credentials" || cfg1.BookwerxConfig.CatFunding != 31 {
+		t.Errorf("sub1: credentials = %s, cat_funding = %d", cfg1.OKExConfig.Credentials, cfg1.BookwerxConfig.CatFunding)
+	}
+	if !cfg1.CompareConfig.Dust.Equal(decimal.New(1, -8)) {
+		t.Errorf("sub1: dust = %s, want the top-level 0.00000001", cfg1.CompareConfig.Dust)
+	}
+
+	sub2, _ := cfg.Account("sub2")
+	if dust := cfg.ForAccount(sub2).CompareConfig.Dust; !dust.Equal(decimal.New(1, -2)) {
+		t.Errorf("sub2: dust = %s, want its own 0.01", dust)
+	}
+	if !cfg.CompareConfig.Dust.Equal(decimal.New(1, -8)) {
+		t.Errorf("ForAccount changed the config itself: dust = %s", cfg.CompareConfig.Dust)
+	}
+}
+
+func TestForAccountCategories(t *testing.T) {
+	cfg := &Config{BookwerxConfig: BookwerxConfig{
+		CatFunding: 1, CatSpotAvailable: 2, CatSpotHold: 3, CatFutures: 4, CatC2C: 5, CatMargin: 6, CatMarginHold: 7,
+		CatMarginBorrowed: 8, CatSwap: 9, CatOptions: 10, CatFee: 11, CatMarginInterest: 12,
+	}}
+	cfg.OKExConfig.Accounts = []AccountConfig{
+		{Name: "sub1", CatFunding: 21, CatSpotAvailable: 22, CatSpotHold: 23, CatMargin: 26, CatMarginBorrowed: 28},
+	}
+
+	category := func(bc BookwerxConfig) []uint32 {
+		return []uint32{bc.CatFunding, bc.CatSpotAvailable, bc.CatSpotHold, bc.CatFutures, bc.CatC2C, bc.CatMargin,
+			bc.CatMarginHold, bc.CatMarginBorrowed, bc.CatSwap, bc.CatOptions, bc.CatFee, bc.CatMarginInterest}
+	}
+	tests := []struct {
+		account string
+		want    []uint32
+	}{
+		{MainAccount, []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+
+		// The sub-account uses neither futures, c2c, margin hold, swap, nor options, so it must not borrow the main
+		// account's.  The income and expense categories are shared.
+		{"sub1", []uint32{21, 22, 23, 0, 0, 26, 0, 28, 0, 0, 11, 12}},
+	}
+	for _, tt := range tests {
+		a, ok := cfg.Account(tt.account)
+		if !ok {
+			t.Fatalf("%s: no such account", tt.account)
+		}
+		got := category(cfg.ForAccount(a).BookwerxConfig)
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: categories = %v, want %v", tt.account, got, tt.want)
+				break
+			}
+		}
+	}
+	if cfg.BookwerxConfig.CatSwap != 9 {
+		t.Errorf("ForAccount changed the config itself: cat_swap = %d", cfg.BookwerxConfig.CatSwap)
+	}
+}
+
+// A profile changes the copy, never the config that it came from, not even the maps within it.
+func TestUseProfile(t *testing.T) {
+	cfg := &Config{}
+	err := yaml.Unmarshal([]byte(`
+compareconfig:
+  dust: "0.00000001"
+  tolerances:
+    BTC: {absolute: "0.0001"}
+okexconfig:
+  swap_instruments: [BTC-USD-SWAP]
+profiles:
+  sandbox:
+    compareconfig:
+      tolerances:
+        BTC: {absolute: "0.01"}
+        USDT: {relative: "0.001"}
+    okexconfig:
+      swap_instruments: [ETH-USD-SWAP]
+`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox, err := cfg.UseProfile("sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tolerances := sandbox.CompareConfig.Tolerances
+	if len(tolerances) != 2 || !tolerances["BTC"].Absolute.Equal(decimal.New(1, -2)) || !tolerances["USDT"].Relative.Equal(decimal.New(1, -3)) {
+		t.Errorf("sandbox: tolerances = %v", tolerances)
+	}
+	if !sandbox.CompareConfig.Dust.Equal(decimal.New(1, -8)) {
+		t.Errorf("sandbox: dust = %s, want the dust of the config", sandbox.CompareConfig.Dust)
+	}
+	if sandbox.Profiles != nil {
+		t.Errorf("sandbox: profiles = %v, want none", sandbox.Profiles)
+	}
+
+	tolerances = cfg.CompareConfig.Tolerances
+	if len(tolerances) != 1 || !tolerances["BTC"].Absolute.Equal(decimal.New(1, -4)) {
+		t.Errorf("the config has changed: tolerances = %v", tolerances)
+	}
+	if len(cfg.OKExConfig.SwapInstruments) != 1 || cfg.OKExConfig.SwapInstruments[0] != "BTC-USD-SWAP" {
+		t.Errorf("the config has changed: swap_instruments = %v", cfg.OKExConfig.SwapInstruments)
+	}
+
+	if _, err := cfg.UseProfile("production"); err == nil {
+		t.Errorf("UseProfile(production) = nil, want an error")
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/credstore"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Secrets such as the OKEx credentials and the Bookwerx API key need not be written in the config file.  Instead the
+// config can refer to them using a URI such as:
+//
+// file:/run/secrets/okex.json       Read the file.
+// env:BOOKWERX_APIKEY               Read the environment variable.
+// exec:pass show okex/credentials   Run the command and read its stdout.
+// encrypted-file:okex.credentials   Unlock a file made by okconnect credentials import.
+//
+// A SecretProvider knows how to fetch the secret referred to by one of these schemes.
+type SecretProvider interface {
+	// Given the part of the URI after the scheme, return the secret.
+	Secret(location string) ([]byte, error)
+}
+
+var secretProviders = map[string]SecretProvider{
+	"file":           FileSecretProvider{},
+	"env":            EnvSecretProvider{},
+	"exec":           ExecSecretProvider{},
+	"encrypted-file": EncryptedFileSecretProvider{},
+}
+
+// Make another scheme available.
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProviders[scheme] = provider
+}
+
+// Split a reference into its scheme and location.  If the reference doesn't start with a known scheme then it
+// isn't a reference at all.
+func parseSecretRef(ref string) (scheme string, location string, ok bool) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	if _, ok := secretProviders[ref[:i]]; !ok {
+		return "", "", false
+	}
+	return ref[:i], ref[i+1:], true
+}
+
+// Is this a reference to a secret, as opposed to the secret itself or a plain filename?
+func IsSecretRef(ref string) bool {
+	_, _, ok := parseSecretRef(ref)
+	return ok
+}
+
+// Fetch the secret that the given reference refers to.  Any trailing newline is removed.
+func ResolveSecret(ref string) ([]byte, error) {
+	scheme, location, ok := parseSecretRef(ref)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("config:secret.go:ResolveSecret: %s is not a secret reference", ref))
+	}
+
+	secret, err := secretProviders[scheme].Secret(location)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("config:secret.go:ResolveSecret: Cannot get the %s secret: %v", scheme, err))
+	}
+
+	return bytes.TrimRight(secret, "\r\n"), nil
+}
+
+type FileSecretProvider struct{}
+
+func (FileSecretProvider) Secret(location string) ([]byte, error) {
+	return ioutil.ReadFile(location)
+}
+
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Secret(location string) ([]byte, error) {
+	value, ok := os.LookupEnv(location)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("the environment variable %s is not set", location))
+	}
+	return []byte(value), nil
+}
+
+// Run the command, without a shell, and take whatever it writes to stdout.  The command may talk to the user using
+// stdin and stderr, as gpg might do.
+type ExecSecretProvider struct{}
+
+func (ExecSecretProvider) Secret(location string) ([]byte, error) {
+	args := strings.Fields(location)
+	if len(args) == 0 {
+		return nil, errors.New("there is no command to execute")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+type EncryptedFileSecretProvider struct{}
+
+func (EncryptedFileSecretProvider) Secret(location string) ([]byte, error) {
+	return credstore.UnlockRaw(location)
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bostontrader/okconnect/credstore"
+)
+
+func TestIsSecretRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"file:/run/secrets/okex.json", true},
+		{"env:BOOKWERX_APIKEY", true},
+		{"exec:pass show okex/credentials", true},
+		{"encrypted-file:okex.credentials", true},
+		{"vault:secret/okex", false},
+		{"okex.credentials", false},
+		{"/run/secrets/okex.json", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsSecretRef(tt.ref); got != tt.want {
+			t.Errorf("IsSecretRef(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "apikey")
+	if err := ioutil.WriteFile(plain, []byte("from a file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted := filepath.Join(dir, "okex.credentials")
+	f, err := credstore.SealRaw("key", []byte(`{"key":"key"}`), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteFile(encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OKCONNECT_TEST_SECRET", "from the environment")
+	t.Setenv(credstore.EnvPassphrase, "correct horse")
+
+	tests := []struct {
+		name string
+		ref  string
+		want string // "" if it's an error
+	}{
+		{"file", "file:" + plain, "from a file"},
+		{"missing file", "file:" + filepath.Join(dir, "missing"), ""},
+		{"env", "env:OKCONNECT_TEST_SECRET", "from the environment"},
+		{"missing env", "env:OKCONNECT_TEST_MISSING", ""},
+		{"exec", "exec:echo from a  command", "from a command"},
+		{"exec fails", "exec:false", ""},
+		{"exec nothing", "exec:", ""},
+		{"encrypted-file", "encrypted-file:" + encrypted, `{"key":"key"}`},
+		{"missing encrypted-file", "encrypted-file:" + plain, ""},
+		{"unknown scheme", "vault:secret/okex", ""},
+		{"not a reference", plain, ""},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSecret(tt.ref)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: ResolveSecret = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: ResolveSecret = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	// The wrong passphrase cannot unlock it.
+	t.Setenv(credstore.EnvPassphrase, "wrong horse")
+	if _, err := ResolveSecret("encrypted-file:" + encrypted); err == nil {
+		t.Errorf("encrypted-file with the wrong passphrase: ResolveSecret = nil, want an error")
+	}
+}
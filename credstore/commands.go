@@ -0,0 +1,139 @@
+package credstore
+
+import (
+	"encoding/json"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+)
+
+// okconnect credentials import -in okex.json -out okex.credentials
+//
+// Encrypt the plaintext credentials file in and save the result in out.  The plaintext file is left alone, so
+// get rid of it yourself.
+//
+// If raw, then in is not an OKEx credentials file but some other secret, such as a Bookwerx API key, which is
+// encrypted as-is.
+func Import(in string, out string, raw bool) {
+	var plaintext []byte
+	keyID := ""
+	var err error
+	if raw {
+		plaintext, err = ioutil.ReadFile(in)
+	} else {
+		var credentials *utils.Credentials
+		credentials, err = readPlaintext(in)
+		if err == nil {
+			keyID = credentials.Key
+			plaintext, err = json.Marshal(credentials)
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithField("file", in).Error("Cannot read the plaintext credentials file.")
+		return
+	}
+
+	passphrase, err := NewPassphrase(fmt.Sprintf("New passphrase for %s: ", out))
+	if err != nil {
+		log.WithError(err).Error("Cannot get the new passphrase.")
+		return
+	}
+
+	f, err := SealRaw(keyID, plaintext, passphrase)
+	if err != nil {
+		log.WithError(err).Error("Cannot encrypt the credentials.")
+		return
+	}
+
+	err = f.WriteFile(out)
+	if err != nil {
+		log.WithError(err).WithField("file", out).Error("Cannot write the encrypted credentials file.")
+		return
+	}
+
+	fmt.Printf("Imported key_id %s into %s.\n", f.KeyID, out)
+}
+
+// okconnect credentials rotate -file okex.credentials [-in new-okex.json]
+//
+// Lock the encrypted credentials file with a new passphrase.  If in is given, then also replace the credentials with
+// those found in the plaintext credentials file in.
+func Rotate(filename string, in string) {
+	f, err := ReadFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Error("Cannot read the encrypted credentials file.")
+		return
+	}
+
+	passphrase, err := Passphrase(fmt.Sprintf("Passphrase for %s: ", filename))
+	if err != nil {
+		log.WithError(err).Error("Cannot get the passphrase.")
+		return
+	}
+
+	plaintext, err := f.OpenRaw(passphrase)
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Error("Cannot unlock the encrypted credentials file.")
+		return
+	}
+	keyID := f.KeyID
+
+	if in != "" {
+		credentials, err := readPlaintext(in)
+		if err != nil {
+			log.WithError(err).WithField("file", in).Error("Cannot read the plaintext credentials file.")
+			return
+		}
+		keyID = credentials.Key
+		plaintext, _ = json.Marshal(credentials)
+	}
+
+	passphrase, err = NewPassphrase(fmt.Sprintf("New passphrase for %s: ", filename))
+	if err != nil {
+		log.WithError(err).Error("Cannot get the new passphrase.")
+		return
+	}
+
+	f, err = SealRaw(keyID, plaintext, passphrase)
+	if err != nil {
+		log.WithError(err).Error("Cannot encrypt the credentials.")
+		return
+	}
+
+	err = f.WriteFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Error("Cannot write the encrypted credentials file.")
+		return
+	}
+
+	fmt.Printf("Rotated %s. key_id is now %s.\n", filename, f.KeyID)
+}
+
+// okconnect credentials show-key-id -file okex.credentials
+//
+// Which OKEx API key is in this file?  No passphrase required.
+func ShowKeyID(filename string) {
+	f, err := ReadFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("file", filename).Error("Cannot read the encrypted credentials file.")
+		return
+	}
+
+	fmt.Println(f.KeyID)
+}
+
+func readPlaintext(filename string) (*utils.Credentials, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := utils.Credentials{}
+	err = json.Unmarshal(data, &credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
+}
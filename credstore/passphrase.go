@@ -0,0 +1,111 @@
+package credstore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Where can we find the passphrase?  In order of preference:
+// 1. The environment variable EnvPassphrase,
+// 2. The first line read from the file descriptor given by the environment variable EnvPassphraseFD,
+// 3. Ask the user on the terminal.
+const (
+	EnvPassphrase      = "OKCONNECT_PASSPHRASE"
+	EnvPassphraseFD    = "OKCONNECT_PASSPHRASE_FD"
+	EnvNewPassphrase   = "OKCONNECT_NEW_PASSPHRASE"
+	EnvNewPassphraseFD = "OKCONNECT_NEW_PASSPHRASE_FD"
+)
+
+// Get the passphrase that unlocks an existing file.
+func Passphrase(prompt string) ([]byte, error) {
+	return passphraseFrom(EnvPassphrase, EnvPassphraseFD, prompt, false)
+}
+
+// Get a passphrase that will lock a new file.  If we must ask the user then ask twice, to guard against typos.
+func NewPassphrase(prompt string) ([]byte, error) {
+	return passphraseFrom(EnvNewPassphrase, EnvNewPassphraseFD, prompt, true)
+}
+
+// A file descriptor can only be read once, so keep what we read from each of them in case we're asked again.
+var (
+	fdMu          sync.Mutex
+	fdPassphrases = make(map[string][]byte)
+)
+
+func passphraseFrom(env string, envFD string, prompt string, confirm bool) ([]byte, error) {
+
+	// 1. The env
+	if p, ok := os.LookupEnv(env); ok {
+		return []byte(p), nil
+	}
+
+	// 2. A file descriptor
+	if s, ok := os.LookupEnv(envFD); ok {
+		return passphraseFromFD(envFD, s)
+	}
+
+	// 3. Ask
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New(fmt.Sprintf("credstore: No passphrase. Set %s or %s, or use a terminal", env, envFD))
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Again: ")
+		p2, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(p, p2) {
+			return nil, errors.New("credstore: The passphrases do not match")
+		}
+	}
+
+	if len(p) == 0 {
+		return nil, errors.New("credstore: The passphrase is empty")
+	}
+
+	return p, nil
+}
+
+// Read the first line from the file descriptor named by envFD, the first time that we're asked.  After that, return
+// the same line again.
+func passphraseFromFD(envFD string, s string) ([]byte, error) {
+	fdMu.Lock()
+	defer fdMu.Unlock()
+
+	if p, ok := fdPassphrases[envFD]; ok {
+		return append([]byte(nil), p...), nil
+	}
+
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("credstore: %s=%s is not a file descriptor", envFD, s))
+	}
+	f := os.NewFile(uintptr(fd), envFD)
+	if f == nil {
+		return nil, errors.New(fmt.Sprintf("credstore: %s=%s is not a valid file descriptor", envFD, s))
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+
+	p := bytes.TrimRight(line, "\r\n")
+	fdPassphrases[envFD] = p
+	return append([]byte(nil), p...), nil
+}
@@ -0,0 +1,36 @@
+package credstore
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// Every unlock in the same process must get the same passphrase from the file descriptor, not whatever is left of it.
+func TestPassphraseFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	_, err = w.WriteString("correct horse\nsomething else\n")
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Unsetenv(EnvPassphrase)
+	os.Setenv(EnvPassphraseFD, strconv.Itoa(int(r.Fd())))
+	defer os.Unsetenv(EnvPassphraseFD)
+	defer delete(fdPassphrases, EnvPassphraseFD)
+
+	for i := 0; i < 3; i++ {
+		p, err := Passphrase("")
+		if err != nil {
+			t.Fatalf("unlock %d: %v", i+1, err)
+		}
+		if string(p) != "correct horse" {
+			t.Errorf("unlock %d: passphrase = %q, want %q", i+1, p, "correct horse")
+		}
+	}
+}
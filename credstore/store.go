@@ -0,0 +1,246 @@
+// The purpose of this package is to keep the OKEx credentials in an encrypted file instead of a plaintext JSON file.
+//
+// The credentials are encrypted using AES-256-GCM with a key derived from a passphrase using scrypt.  The OKEx API key
+// itself is not a secret, OKEx sees it in the clear with every request, so it's stored in the clear as the key_id.
+// This enables us to identify which credentials a file contains without unlocking it.
+package credstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/atomicfile"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+)
+
+const version = 1
+
+// Recommended scrypt parameters for interactive logins, as of 2017.
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// The most that we'll let a file ask scrypt for, so that a corrupt file cannot ask for more memory or time than any
+// machine has.  scrypt needs 128 * N * r bytes.
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+// ErrWrongPassphrase means that the passphrase cannot unlock the file, or that the file has been tampered with.
+var ErrWrongPassphrase = errors.New("credstore: wrong passphrase or corrupted file")
+
+// A CorruptError means that the file is not what Seal writes, so there's no point in trying to unlock it.
+type CorruptError struct {
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return "credstore: corrupted file: " + e.Reason
+}
+
+// This is what an encrypted credentials file looks like.
+type File struct {
+	Version    int    `json:"version"`
+	KeyID      string `json:"key_id"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Does this data look like an encrypted credentials file, as opposed to a plaintext one?
+func IsEncrypted(data []byte) bool {
+	f := File{}
+	err := json.Unmarshal(data, &f)
+	return err == nil && f.KDF != "" && len(f.Ciphertext) > 0
+}
+
+// Encrypt the given credentials using the given passphrase.
+func Seal(credentials utils.Credentials, passphrase []byte) (*File, error) {
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return SealRaw(credentials.Key, plaintext, passphrase)
+}
+
+// Encrypt any secret, such as a Bookwerx API key, using the given passphrase.
+func SealRaw(keyID string, plaintext []byte, passphrase []byte) (*File, error) {
+	f := &File{
+		Version: version,
+		KeyID:   keyID,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 16),
+	}
+
+	_, err := rand.Read(f.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := f.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(f.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, f.additionalData())
+	return f, nil
+}
+
+// Decrypt the credentials using the given passphrase.
+func (f *File) Open(passphrase []byte) (*utils.Credentials, error) {
+	plaintext, err := f.OpenRaw(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := utils.Credentials{}
+	err = json.NewDecoder(bytes.NewReader(plaintext)).Decode(&credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
+}
+
+// Decrypt whatever secret is in the file using the given passphrase.
+func (f *File) OpenRaw(passphrase []byte) ([]byte, error) {
+	if f.Version != version || f.KDF != "scrypt" {
+		return nil, errors.New(fmt.Sprintf("credstore: unsupported file version=%d, kdf=%s", f.Version, f.KDF))
+	}
+
+	err := f.checkKDF()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := f.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, &CorruptError{fmt.Sprintf("the nonce is %d bytes, not %d", len(f.Nonce), aead.NonceSize())}
+	}
+
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, f.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+// Make sure that the scrypt parameters are ones that scrypt accepts, and that they don't ask for too much.
+func (f *File) checkKDF() error {
+	switch {
+	case len(f.Salt) == 0:
+		return &CorruptError{"there is no salt"}
+	case f.N <= 1 || f.N&(f.N-1) != 0 || f.N > maxScryptN:
+		return &CorruptError{fmt.Sprintf("n=%d is not a power of two between 2 and %d", f.N, maxScryptN)}
+	case f.R < 1 || f.R > maxScryptR:
+		return &CorruptError{fmt.Sprintf("r=%d is not between 1 and %d", f.R, maxScryptR)}
+	case f.P < 1 || f.P > maxScryptP:
+		return &CorruptError{fmt.Sprintf("p=%d is not between 1 and %d", f.P, maxScryptP)}
+	}
+	return nil
+}
+
+func (f *File) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Bind the cleartext parts of the file to the ciphertext so that nobody can change them unnoticed.
+func (f *File) additionalData() []byte {
+	return []byte(fmt.Sprintf("okconnect-credstore:%d:%s", f.Version, f.KeyID))
+}
+
+// Read an encrypted credentials file.
+func ReadFile(filename string) (*File, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{}
+	err = json.Unmarshal(data, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write the file, replacing any existing file of that name only after the new one is safely on the disk.
+func (f *File) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(filename, data, 0600)
+}
+
+// Read and unlock an encrypted credentials file, getting the passphrase in the usual way.  See Passphrase.
+func Unlock(filename string) (*utils.Credentials, error) {
+	f, err := ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := Passphrase(fmt.Sprintf("Passphrase for %s: ", filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Open(passphrase)
+}
+
+// Read and unlock an encrypted file, returning whatever secret it contains.
+func UnlockRaw(filename string) ([]byte, error) {
+	f, err := ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := Passphrase(fmt.Sprintf("Passphrase for %s: ", filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return f.OpenRaw(passphrase)
+}
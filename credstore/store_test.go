@@ -0,0 +1,90 @@
+package credstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	utils "github.com/bostontrader/okcommon"
+)
+
+var testCredentials = utils.Credentials{Key: "key", SecretKey: "secret", Passphrase: "okex passphrase"}
+
+func TestSealOpen(t *testing.T) {
+	f, err := Seal(testCredentials, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.KeyID != testCredentials.Key {
+		t.Errorf("KeyID = %s, want %s", f.KeyID, testCredentials.Key)
+	}
+
+	// Write it and read it back, as credentials import and Unlock do.
+	filename := filepath.Join(t.TempDir(), "okex.credentials")
+	err = f.WriteFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := f.Open([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *credentials != testCredentials {
+		t.Errorf("Open = %+v, want %+v", *credentials, testCredentials)
+	}
+
+	_, err = f.Open([]byte("battery staple"))
+	if err != ErrWrongPassphrase {
+		t.Errorf("Open with the wrong passphrase returned %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	f, err := SealRaw("bookwerx", []byte("api key"), []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.KeyID = "someone else"
+	_, err = f.OpenRaw([]byte("correct horse"))
+	if err != ErrWrongPassphrase {
+		t.Errorf("OpenRaw with a changed key_id returned %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(f *File)
+	}{
+		{"short nonce", func(f *File) { f.Nonce = f.Nonce[:4] }},
+		{"no nonce", func(f *File) { f.Nonce = nil }},
+		{"no salt", func(f *File) { f.Salt = nil }},
+		{"n not a power of two", func(f *File) { f.N = 1000 }},
+		{"n too big", func(f *File) { f.N = 1 << 30 }},
+		{"n zero", func(f *File) { f.N = 0 }},
+		{"r zero", func(f *File) { f.R = 0 }},
+		{"r too big", func(f *File) { f.R = 1 << 20 }},
+		{"p negative", func(f *File) { f.P = -1 }},
+		{"p too big", func(f *File) { f.P = 1 << 20 }},
+	}
+
+	for _, tt := range tests {
+		f, err := SealRaw("bookwerx", []byte("api key"), []byte("correct horse"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.corrupt(f)
+
+		_, err = f.OpenRaw([]byte("correct horse"))
+		var corrupt *CorruptError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("%s: OpenRaw returned %v, want a CorruptError", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// The purpose of this package is to export what OKConnect knows in the forms that other people, such as the tax man,
+// want to see.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The formats that Tax can produce.
+const (
+	FormatGeneric  = "generic"  // Everything that we know, one row per disposal.
+	FormatForm8949 = "8949"     // The columns of IRS Form 8949, as imported by TaxAct and others.
+	FormatTurboTax = "turbotax" // The TurboTax "Other (Gains and losses)" CSV.
+)
+
+// A disposal held for longer than this is long-term.
+const longTermDays = 365
+
+// Export the disposals sold during the given year, as recorded by the lots, in the given format: generic, 8949, or
+// turbotax.  Only the sales for the lots' quote currency are disposals.  The proceeds are before the fee, and the
+// gain is the proceeds minus the cost basis, as booked.  The tax formats subtract the fee, if it was paid in the
+// quote currency, from the proceeds instead.
+//
+// Example:
+// okconnect export tax -year 2026 -format 8949 -config okconnect.yaml
+func Tax(cfg *config.Config, year int, format string) {
+	b, err := lots.Open(cfg.LotsConfig)
+	if err != nil {
+		return
+	}
+	if b == nil {
+		log.Error("The config does not say which lots method to use, so there are no disposals to export.")
+		return
+	}
+	if b.Incomplete() {
+		log.Warn("The lots file is older than the disposals, so the sales before then are missing.  Run okconnect lots -backfill-disposals to find them.")
+	}
+
+	// 1. Which disposals were sold during the year?
+	disposals := make([]lots.Disposal, 0)
+	for _, d := range b.Disposals() {
+		sold, err := time.Parse(time.RFC3339, d.Sold)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"tag": d.Tag, "sold": d.Sold}).Error("Cannot parse the time of the sale.")
+			return
+		}
+		if sold.Year() == year {
+			disposals = append(disposals, d)
+		}
+	}
+
+	// 2. Write them.
+	w := csv.NewWriter(os.Stdout)
+	switch format {
+	case FormatGeneric:
+		_ = w.Write([]string{"currency", "quantity", "date_acquired", "date_sold", "proceeds", "cost_basis", "gain", "fee", "fee_currency", "quote_currency", "term", "tag"})
+		for _, d := range disposals {
+			_ = w.Write([]string{d.Currency, d.Quantity.String(), date(d.Acquired, "2006-01-02"), date(d.Sold, "2006-01-02"),
+				d.Proceeds.String(), d.Cost.String(), d.Proceeds.Sub(d.Cost).String(), d.Fee.String(), d.FeeCurrency, b.Quote(), term(d), d.Tag})
+		}
+
+	case FormatForm8949:
+		_ = w.Write([]string{"Description of property", "Date acquired", "Date sold or disposed of", "Proceeds", "Cost or other basis", "Gain or (loss)", "Term"})
+		for _, d := range disposals {
+			proceeds := netProceeds(d, b.Quote())
+			_ = w.Write([]string{d.Quantity.String() + " " + d.Currency, date(d.Acquired, "01/02/2006"), date(d.Sold, "01/02/2006"),
+				proceeds.StringFixed(2), d.Cost.StringFixed(2), proceeds.Sub(d.Cost).StringFixed(2), term(d)})
+		}
+
+	case FormatTurboTax:
+		_ = w.Write([]string{"Currency Name", "Purchase Date", "Cost Basis", "Date Sold", "Proceeds"})
+		for _, d := range disposals {
+			_ = w.Write([]string{d.Currency, date(d.Acquired, "01/02/2006"), d.Cost.StringFixed(2), date(d.Sold, "01/02/2006"), netProceeds(d, b.Quote()).StringFixed(2)})
+		}
+
+	default:
+		err = errors.New("unknown format")
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		log.WithError(err).WithField("format", format).Error("Cannot export the disposals.")
+		return
+	}
+
+	log.WithFields(log.Fields{"year": strconv.Itoa(year), "disposals": len(disposals)}).Info("Exported.")
+}
+
+// The proceeds, less the fee if it was paid in the quote currency.
+func netProceeds(d lots.Disposal, quote string) decimal.Decimal {
+	if d.FeeCurrency == quote {
+		return d.Proceeds.Sub(d.Fee)
+	}
+	return d.Proceeds
+}
+
+// Reformat an RFC3339 time as a date, or VARIOUS if we don't know it.
+func date(s string, layout string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return "VARIOUS"
+	}
+	return t.UTC().Format(layout)
+}
+
+// Was the disposal held for a short or long term?  If we don't know when it was acquired then assume short.
+func term(d lots.Disposal) string {
+	acquired, err1 := time.Parse(time.RFC3339, d.Acquired)
+	sold, err2 := time.Parse(time.RFC3339, d.Sold)
+	if err1 != nil || err2 != nil || sold.Sub(acquired) <= longTermDays*24*time.Hour {
+		return "short"
+	}
+	return "long"
+}
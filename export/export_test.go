@@ -0,0 +1,153 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/shopspring/decimal"
+)
+
+func TestTerm(t *testing.T) {
+	tests := []struct {
+		acquired string
+		sold     string
+		want     string
+	}{
+		{"2019-01-01T00:00:00Z", "2019-06-01T00:00:00Z", "short"},
+		{"2019-01-01T00:00:00Z", "2020-01-01T00:00:00Z", "short"}, // exactly 365 days
+		{"2019-01-01T00:00:00Z", "2020-01-01T00:00:01Z", "long"},
+		{"2020-01-01T00:00:00Z", "2020-12-31T00:00:00Z", "short"}, // 365 days of a leap year
+		{"2020-01-01T00:00:00Z", "2021-01-01T00:00:00Z", "long"},
+		{"", "2021-01-01T00:00:00Z", "short"}, // we don't know when it was acquired
+		{"2019-01-01T00:00:00Z", "", "short"},
+	}
+	for _, tt := range tests {
+		if got := term(lots.Disposal{Acquired: tt.acquired, Sold: tt.sold}); got != tt.want {
+			t.Errorf("term(%s, %s) = %s, want %s", tt.acquired, tt.sold, got, tt.want)
+		}
+	}
+}
+
+func TestNetProceeds(t *testing.T) {
+	tests := []struct {
+		fee         string
+		feeCurrency string
+		want        string
+	}{
+		{"15", "USDT", "985"},
+		{"0.001", "BTC", "1000"},
+		{"0", "", "1000"},
+	}
+	for _, tt := range tests {
+		d := lots.Disposal{Proceeds: decimal.New(1000, 0), Fee: decimal.RequireFromString(tt.fee), FeeCurrency: tt.feeCurrency}
+		if got := netProceeds(d, "USDT"); got.String() != tt.want {
+			t.Errorf("netProceeds with a fee of %s %s = %s, want %s", tt.fee, tt.feeCurrency, got, tt.want)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	tests := []struct {
+		s      string
+		layout string
+		want   string
+	}{
+		{"2020-03-01T23:00:00-05:00", "2006-01-02", "2020-03-02"},
+		{"2020-03-01T12:00:00Z", "01/02/2006", "03/01/2020"},
+		{"", "01/02/2006", "VARIOUS"},
+		{"yesterday", "2006-01-02", "VARIOUS"},
+	}
+	for _, tt := range tests {
+		if got := date(tt.s, tt.layout); got != tt.want {
+			t.Errorf("date(%q, %q) = %s, want %s", tt.s, tt.layout, got, tt.want)
+		}
+	}
+}
+
+// Lots with three disposals sold in 2020, a long-term one, a short-term one, and one whose acquisition we never saw,
+// and one more sold in 2021.
+func newTaxConfig(t *testing.T) *config.Config {
+	cfg := &config.Config{LotsConfig: config.LotsConfig{Method: lots.MethodFIFO, State: filepath.Join(t.TempDir(), "okconnect.lots")}}
+	b, err := lots.Open(cfg.LotsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := decimal.RequireFromString
+	commit := func(c *lots.Change, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Commit(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit(b.Deposit("[d1]", "2019-01-01T00:00:00Z", "BTC", d("1")), nil)
+	commit(b.Trade("[t1]", "2019-06-01T00:00:00Z", lots.Flow{Currency: "BTC", Quantity: d("1")}, lots.Flow{Currency: "USDT", Quantity: d("10000")}))
+	commit(b.Trade("[s1]", "2020-03-01T12:00:00Z", lots.Flow{Currency: "USDT", Quantity: d("25000"), Fee: d("25")}, lots.Flow{Currency: "BTC", Quantity: d("2.5")}))
+	commit(b.Trade("[s2]", "2021-03-01T12:00:00Z", lots.Flow{Currency: "USDT", Quantity: d("6000")}, lots.Flow{Currency: "BTC", Quantity: d("0.5")}))
+	return cfg
+}
+
+// Return whatever the func prints.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestTax(t *testing.T) {
+	cfg := newTaxConfig(t)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatGeneric, `currency,quantity,date_acquired,date_sold,proceeds,cost_basis,gain,fee,fee_currency,quote_currency,term,tag
+BTC,1,2019-01-01,2020-03-01,10000,0,10000,10,USDT,USDT,long,[s1]
+BTC,1,2019-06-01,2020-03-01,10000,10000,0,10,USDT,USDT,short,[s1]
+BTC,0.5,VARIOUS,2020-03-01,5000,0,5000,5,USDT,USDT,short,[s1]
+`},
+
+		// The tax forms subtract the fee from the proceeds.
+		{FormatForm8949, `Description of property,Date acquired,Date sold or disposed of,Proceeds,Cost or other basis,Gain or (loss),Term
+1 BTC,01/01/2019,03/01/2020,9990.00,0.00,9990.00,long
+1 BTC,06/01/2019,03/01/2020,9990.00,10000.00,-10.00,short
+0.5 BTC,VARIOUS,03/01/2020,4995.00,0.00,4995.00,short
+`},
+		{FormatTurboTax, `Currency Name,Purchase Date,Cost Basis,Date Sold,Proceeds
+BTC,01/01/2019,0.00,03/01/2020,9990.00
+BTC,06/01/2019,10000.00,03/01/2020,9990.00
+BTC,VARIOUS,0.00,03/01/2020,4995.00
+`},
+		{"nope", ""},
+	}
+	for _, tt := range tests {
+		if got := captureStdout(t, func() { Tax(cfg, 2020, tt.format) }); got != tt.want {
+			t.Errorf("%s:\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	// Only what was sold during the year.
+	want := `Currency Name,Purchase Date,Cost Basis,Date Sold,Proceeds
+BTC,VARIOUS,0.00,03/01/2021,6000.00
+`
+	if got := captureStdout(t, func() { Tax(cfg, 2021, FormatTurboTax) }); got != want {
+		t.Errorf("2021:\n%s\nwant\n%s", got, want)
+	}
+}
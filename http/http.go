@@ -1,21 +1,83 @@
 package http
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/bostontrader/okconnect/metrics"
+	"github.com/gojektech/heimdall/httpclient"
+	log "github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-func GetHTTPClient(urlBase string) (client *http.Client) {
+// Get an http.Client suitable for talking to the server at urlBase.  Every request it makes is logged, using upstream
+// to say which server it's talking to, such as "okex" or "bookwerx".
+func GetHTTPClient(upstream string, urlBase string) (client *http.Client) {
 
+	var base http.RoundTripper = http.DefaultTransport
 	if len(urlBase) >= 6 && urlBase[:6] == "https:" {
-		tr := &http.Transport{
+		base = &http.Transport{
 			MaxIdleConns:       10,
 			IdleConnTimeout:    30 * time.Second,
 			DisableCompression: true,
 		}
-		return &http.Client{Transport: tr}
 	}
 
-	return &http.Client{}
+	return &http.Client{Transport: &loggingTransport{upstream: upstream, base: base}}
 
 }
+
+// Log every request with its upstream, endpoint, status, latency, and a correlation id that ties together all the log
+// entries about the same request.  The query string is never logged because it might contain an apikey.
+// Count them too, for the metrics, by the route of the endpoint.
+type loggingTransport struct {
+	upstream string
+	base     http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields := log.Fields{
+		"upstream":       t.upstream,
+		"method":         req.Method,
+		"endpoint":       req.URL.Path,
+		"correlation_id": newCorrelationID(),
+	}
+	log.WithFields(fields).Debug("request")
+	endpoint := route(req.URL.Path)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+	fields["latency"] = latency.String()
+	metrics.UpstreamLatency.Observe(latency.Seconds(), t.upstream, endpoint)
+
+	if err != nil {
+		log.WithFields(fields).WithError(err).Error("request failed")
+		metrics.UpstreamErrors.Add(1, t.upstream, endpoint)
+		return resp, err
+	}
+
+	fields["status"] = resp.StatusCode
+	metrics.UpstreamRequests.Add(1, t.upstream, endpoint, strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		log.WithFields(fields).Warn("response")
+	} else {
+		log.WithFields(fields).Debug("response")
+	}
+
+	return resp, err
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Get a heimdall client, such as bwapi.Get wants, that makes its requests using GetHTTPClient.
+func GetHeimdallClient(upstream string, urlBase string, timeout time.Duration) *httpclient.Client {
+	client := GetHTTPClient(upstream, urlBase)
+	client.Timeout = timeout
+	return httpclient.NewClient(httpclient.WithHTTPClient(client))
+}
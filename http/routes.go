@@ -0,0 +1,61 @@
+package http
+
+import "strings"
+
+// The routes of the endpoints that we use, so that the metrics count the requests to each route rather than to each
+// path.  Otherwise every currency and instrument would have a label of its own.  A segment in braces matches any
+// segment, and the first route that matches wins, so list the literal ones first.
+var routes = []string{
+	// OKEx
+	"/api/account/v3/ledger",
+	"/api/account/v3/transfer",
+	"/api/account/v3/transfer/state",
+	"/api/account/v3/wallet",
+	"/api/account/v3/wallet/{currency}",
+	"/api/futures/v3/accounts",
+	"/api/futures/v3/accounts/{underlying}",
+	"/api/general/v3/time",
+	"/api/margin/v3/accounts",
+	"/api/margin/v3/accounts/borrow",
+	"/api/margin/v3/accounts/repayment",
+	"/api/margin/v3/accounts/{instrument_id}",
+	"/api/spot/v3/accounts",
+	"/api/spot/v3/accounts/{currency}",
+	"/api/spot/v3/accounts/{currency}/ledger",
+	"/api/spot/v3/fills",
+	"/api/spot/v3/instruments/{instrument_id}/ticker",
+	"/api/swap/v3/accounts",
+	"/api/swap/v3/accounts/{instrument_id}",
+	"/api/swap/v3/accounts/{instrument_id}/ledger",
+
+	// Bookwerx
+	"/category_dist_sums",
+	"/distribution/{id}",
+	"/distributions",
+	"/sql",
+	"/transaction/{id}",
+	"/transactions",
+}
+
+// The route of the given path, or "other" if it's none of them.
+func route(path string) string {
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	for _, r := range routes {
+		if matches(strings.Split(r, "/"), segments) {
+			return r
+		}
+	}
+	return "other"
+}
+
+func matches(route []string, segments []string) bool {
+	if len(route) != len(segments) {
+		return false
+	}
+	for i, s := range route {
+		if s != segments[i] && !(strings.HasPrefix(s, "{") && segments[i] != "") {
+			return false
+		}
+	}
+	return true
+}
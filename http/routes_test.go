@@ -0,0 +1,28 @@
+package http
+
+import "testing"
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/account/v3/wallet", "/api/account/v3/wallet"},
+		{"/api/account/v3/wallet/BTC", "/api/account/v3/wallet/{currency}"},
+		{"/api/spot/v3/accounts/USDT/ledger", "/api/spot/v3/accounts/{currency}/ledger"},
+		{"/api/spot/v3/instruments/BTC-USDT/ticker", "/api/spot/v3/instruments/{instrument_id}/ticker"},
+		{"/api/margin/v3/accounts/borrow", "/api/margin/v3/accounts/borrow"},
+		{"/api/margin/v3/accounts/BTC-USDT", "/api/margin/v3/accounts/{instrument_id}"},
+		{"/api/swap/v3/accounts/BTC-USD-SWAP/ledger", "/api/swap/v3/accounts/{instrument_id}/ledger"},
+		{"/transaction/42", "/transaction/{id}"},
+		{"/sql", "/sql"},
+		{"/api/spot/v3/accounts//ledger", "other"},
+		{"/api/unheard/of", "other"},
+		{"/", "other"},
+	}
+	for _, tt := range tests {
+		if got := route(tt.path); got != tt.want {
+			t.Errorf("route(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// The purpose of this package is to remember, on the local disk, every state-changing request that OKConnect makes to OKEx.
+//
+// Each such request is identified by a client_oid that we generate.  If we cannot tell whether or not a request succeeded,
+// such as after a timeout, then the journal tells a later run what was asked for so that it can ask OKEx what really
+// happened, instead of blindly trying again and perhaps doing the same thing twice.
+//
+// The journal is a file of JSON lines, one line per change of state, and only ever appended to.  The last line for
+// any given client_oid wins.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+// The journal file to use if the config does not say otherwise.
+const DefaultPath = "okconnect.journal"
+
+// The life-cycle of a request.
+const (
+	StatePending = "pending" // We have sent, or are about to send, the request but don't know the outcome.
+	StateDone    = "done"    // OKEx has done what we asked.
+	StateFailed  = "failed"  // OKEx has refused to do what we asked.
+	StateBooked  = "booked"  // OKEx has done what we asked and we have recorded this in Bookwerx.
+)
+
+type Entry struct {
+	ClientOID string    `json:"client_oid"`
+	Kind      string    `json:"kind"` // transfer, order, withdrawal, etc.
+	Endpoint  string    `json:"endpoint"`
+	Request   string    `json:"request"`
+	State     string    `json:"state"`
+	Response  string    `json:"response,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+type Journal struct {
+	path    string
+	entries map[string]Entry
+	order   []string // client_oids in the order first seen
+	mu      sync.Mutex
+}
+
+// Open the journal at the given path and read whatever it already contains.  If the file does not exist yet then
+// that's ok, it will be created upon the first Record.
+func Open(path string) (*Journal, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	j := &Journal{path: path, entries: make(map[string]Entry)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("journal", path).Error("Cannot open the journal.")
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := Entry{}
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			log.WithError(err).WithField("journal", path).Error("Cannot parse the journal.")
+			return nil, err
+		}
+		j.remember(e)
+	}
+
+	return j, scanner.Err()
+}
+
+func (j *Journal) remember(e Entry) {
+	if _, ok := j.entries[e.ClientOID]; !ok {
+		j.order = append(j.order, e.ClientOID)
+	}
+	j.entries[e.ClientOID] = e
+}
+
+// Append the given entry to the journal and make sure it's really on the disk before returning.
+func (j *Journal) Record(e Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.WithError(err).WithField("journal", j.path).Error("Cannot open the journal.")
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		log.WithError(err).WithField("journal", j.path).Error("Cannot write to the journal.")
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		log.WithError(err).WithField("journal", j.path).Error("Cannot write to the journal.")
+		return err
+	}
+
+	j.remember(e)
+	return nil
+}
+
+// Remember that the request with the given client_oid has been booked, so that nobody tries to do so again.
+func (j *Journal) MarkBooked(clientOID string) error {
+	return j.mark(clientOID, StateBooked, "")
+}
+
+// Settle a pending request once somebody has found out by hand whether it happened.  A request that happened is done,
+// and one that didn't has failed.
+func (j *Journal) Resolve(clientOID string, happened bool, note string) error {
+	if happened {
+		return j.mark(clientOID, StateDone, note)
+	}
+	return j.mark(clientOID, StateFailed, note)
+}
+
+func (j *Journal) mark(clientOID string, state string, response string) error {
+	entry, ok := j.Get(clientOID)
+	if !ok {
+		err := errors.New("journal: there is no request with the client_oid " + clientOID)
+		log.WithError(err).Error("Cannot change the state of the request.")
+		return err
+	}
+
+	entry.State = state
+	if response != "" {
+		entry.Response = response
+	}
+	entry.Time = time.Time{}
+	err := j.Record(entry)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"client_oid": clientOID, "state": state}).Error("Cannot change the state of the request.")
+	}
+	return err
+}
+
+// Find the latest entry for the given client_oid.
+func (j *Journal) Get(clientOID string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[clientOID]
+	return e, ok
+}
+
+// Return the latest entry for each client_oid whose state is one of the given states, in the order first seen.
+func (j *Journal) InState(states ...string) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	retVal := make([]Entry, 0)
+	for _, oid := range j.order {
+		e := j.entries[oid]
+		for _, s := range states {
+			if e.State == s {
+				retVal = append(retVal, e)
+				break
+			}
+		}
+	}
+	return retVal
+}
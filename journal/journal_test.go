@@ -0,0 +1,82 @@
+package journal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "okconnect.journal")
+	lines := `{"client_oid":"a","kind":"transfer","endpoint":"/api/account/v3/transfer","request":"{}","state":"pending","time":"2020-10-10T00:00:00Z"}
+{"client_oid":"b","kind":"transfer","endpoint":"/api/account/v3/transfer","request":"{}","state":"pending","time":"2020-10-10T00:00:01Z"}
+
+{"client_oid":"a","kind":"transfer","endpoint":"/api/account/v3/transfer","request":"{}","state":"done","response":"ok","time":"2020-10-10T00:00:02Z"}
+{"client_oid":"c","kind":"margin-borrow","endpoint":"/api/margin/v3/accounts/borrow","request":"{}","state":"pending","time":"2020-10-10T00:00:03Z"}
+{"client_oid":"a","kind":"transfer","endpoint":"/api/account/v3/transfer","request":"{}","state":"booked","response":"ok","time":"2020-10-10T00:00:04Z"}
+`
+	if err := ioutil.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last line for each client_oid wins.
+	if e, ok := j.Get("a"); !ok || e.State != StateBooked || e.Response != "ok" {
+		t.Errorf("a = %+v, %v, want booked", e, ok)
+	}
+	if _, ok := j.Get("d"); ok {
+		t.Errorf("d is in the journal")
+	}
+
+	// In the order first seen.
+	pending := j.InState(StatePending)
+	if len(pending) != 2 || pending[0].ClientOID != "b" || pending[1].ClientOID != "c" {
+		t.Errorf("pending = %+v, want b and c", pending)
+	}
+
+	// Changes of state are appended, so a later run sees them too.
+	if err := j.MarkBooked("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Resolve("c", false, "it did not happen"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MarkBooked("d"); err == nil {
+		t.Errorf("MarkBooked(d) = nil, want an error")
+	}
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := j.Get("b"); e.State != StateBooked {
+		t.Errorf("b = %s, want booked", e.State)
+	}
+	if e, _ := j.Get("c"); e.State != StateFailed || e.Response != "it did not happen" {
+		t.Errorf("c = %+v, want failed", e)
+	}
+	if all := j.InState(StatePending, StateDone, StateFailed, StateBooked); len(all) != 3 || all[0].ClientOID != "a" {
+		t.Errorf("all = %+v, want a, b, and c", all)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	j, err := Open(filepath.Join(t.TempDir(), "okconnect.journal"))
+	if err != nil || len(j.InState(StatePending)) != 0 {
+		t.Errorf("Open = %v, %v, want an empty journal", j, err)
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "okconnect.journal")
+	if err := ioutil.WriteFile(path, []byte("{\"client_oid\":\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Errorf("Open = nil, want an error")
+	}
+}
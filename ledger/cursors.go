@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"encoding/json"
+	"github.com/bostontrader/okconnect/atomicfile"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// The cursors file to use if the config does not say otherwise.
+const DefaultCursorsPath = "okconnect.cursors"
+
+// Cursors remember, for each OKEx ledger that we follow, the ledger_id of the newest entry that we have booked.  They
+// are kept in a small JSON file so that a later run, or a restarted watch, picks up where the last one stopped.
+type Cursors struct {
+	path      string
+	positions map[string]string
+	mu        sync.Mutex
+}
+
+// Open the cursors file at the given path.  If the file does not exist yet then that's ok, every ledger starts
+// without a cursor and the file will be created upon the first Set.
+func OpenCursors(path string) (*Cursors, error) {
+	if path == "" {
+		path = DefaultCursorsPath
+	}
+
+	c := &Cursors{path: path, positions: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("cursors", path).Error("Cannot read the cursors file.")
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &c.positions)
+	if err != nil {
+		log.WithError(err).WithField("cursors", path).Error("Cannot parse the cursors file.")
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get the cursor for the given ledger, or "" if we have never followed it.
+func (c *Cursors) Get(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.positions[name]
+}
+
+// Move the cursor for the given ledger and save all the cursors.
+func (c *Cursors) Set(name string, ledgerID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.positions[name] = ledgerID
+
+	err := c.save()
+	if err != nil {
+		log.WithError(err).WithField("cursors", c.path).Error("Cannot write the cursors file.")
+	}
+	return err
+}
+
+// Write the file, replacing the existing file only after the new one is safely on the disk.
+func (c *Cursors) save() error {
+	data, err := json.MarshalIndent(c.positions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(c.path, data, 0600)
+}
@@ -0,0 +1,53 @@
+package ledger
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "okconnect.cursors")
+
+	// A file that isn't there yet is ok.
+	c, err := OpenCursors(path)
+	if err != nil || c.Get(accountLedger) != "" {
+		t.Fatalf("OpenCursors = %v, %v", c, err)
+	}
+	if err := c.Set(accountLedger, "7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(spotFillsPrefix+"BTC-USDT", "12345678901234567890"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenCursors(path)
+	if err != nil || c.Get(accountLedger) != "7" || c.Get(spotFillsPrefix+"BTC-USDT") != "12345678901234567890" {
+		t.Errorf("reopened = %v, %v", c.positions, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCursors(path); err == nil {
+		t.Errorf("OpenCursors of a broken file = nil, want an error")
+	}
+}
+
+func TestIDLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"9", "10", true},
+		{"10", "9", false},
+		{"12345678901234567890", "12345678901234567891", true},
+		{"7", "7", false},
+		{"0", "1", true},
+	}
+	for _, tt := range tests {
+		if got := idLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("idLess(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ledger
+
+import (
+	"path/filepath"
+	"testing"
+
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseFee(t *testing.T) {
+	tests := []struct {
+		fee  string
+		want string // what we paid
+	}{
+		{"", "0"},
+		{"0", "0"},
+		{"-0.001", "0.001"},
+		{"0.0001", "-0.0001"}, // a rebate
+	}
+	for _, tt := range tests {
+		got, err := parseFee(tt.fee)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseFee(%q) = %s, %v, want %s", tt.fee, got, err, tt.want)
+		}
+	}
+	if _, err := parseFee("some"); err == nil {
+		t.Errorf("parseFee(some) = nil, want an error")
+	}
+}
+
+// A Syncer that also follows the lots.
+func newLotsSyncer(t *testing.T) (*Syncer, *fakeBookwerx) {
+	s, b := newTestSyncer(t, nil)
+	var err error
+	s.Lots, err = lots.Open(config.LotsConfig{Method: lots.MethodFIFO, State: filepath.Join(t.TempDir(), "okconnect.lots")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, b
+}
+
+// The quantity and the cost of each open lot of the currency, such as "1.999@0".
+func lotsString(b *lots.Book, currency string) string {
+	s := ""
+	for _, lot := range b.Lots()[currency] {
+		s += lot.Quantity.String() + "@" + lot.Cost.String() + " "
+	}
+	return s
+}
+
+func TestBookAccountEntryFees(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry utils.LedgerEntry
+		want  string
+	}{
+		{"deposit", utils.LedgerEntry{Typename: "Deposit", Currency: "BTC", Amount: "2", Fee: "-0.001"},
+			"1 BTC 2, 3 BTC -2, 5 BTC 0.001, 1 BTC -0.001"},
+		{"withdrawal", utils.LedgerEntry{Typename: "Withdrawal", Currency: "BTC", Amount: "-1", Fee: "-0.0005"},
+			"1 BTC -1, 3 BTC 1, 5 BTC 0.0005, 1 BTC -0.0005"},
+
+		// The funding ledger never gives a rebate, so a positive fee is still one that we paid.
+		{"withdrawal with a positive fee", utils.LedgerEntry{Typename: "Withdrawal", Currency: "BTC", Amount: "-1", Fee: "0.0005"},
+			"1 BTC -1, 3 BTC 1, 5 BTC 0.0005, 1 BTC -0.0005"},
+	}
+	for _, tt := range tests {
+		s, b := newTestSyncer(t, nil)
+		tt.entry.LedgerID, tt.entry.Timestamp = "7", "2020-10-10T00:00:00Z"
+		ok, err := s.bookAccountEntry(tt.entry)
+		if !ok || err != nil || len(b.posted()) != 1 {
+			t.Errorf("%s: bookAccountEntry = %v, %v", tt.name, ok, err)
+			continue
+		}
+		if got := legsString(b.posted()[0].legs); got != tt.want {
+			t.Errorf("%s: legs = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// A deposit acquires what's left after the fee, and a withdrawal consumes the fee too.
+	s, _ := newLotsSyncer(t)
+	_, err := s.bookAccountEntry(utils.LedgerEntry{LedgerID: "1", Typename: "Deposit", Currency: "BTC", Amount: "2", Fee: "-0.001", Timestamp: "2020-10-10T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lotsString(s.Lots, "BTC"); got != "1.999@0 " {
+		t.Errorf("after the deposit, lots = %s, want 1.999@0", got)
+	}
+	_, err = s.bookAccountEntry(utils.LedgerEntry{LedgerID: "2", Typename: "Withdrawal", Currency: "BTC", Amount: "-1", Fee: "0.0005", Timestamp: "2020-10-11T00:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lotsString(s.Lots, "BTC"); got != "0.9985@0 " {
+		t.Errorf("after the withdrawal, lots = %s, want 0.9985@0", got)
+	}
+}
+
+// A trade of 0.001 BTC for 10 USDT, with the given fees, on the given side.
+func trade(tradeID string, side string, btc string, btcFee string, usdt string, usdtFee string) []Fill {
+	other := "sell"
+	if side == "sell" {
+		other = "buy"
+	}
+	return []Fill{
+		{LedgerID: tradeID + "1", TradeID: tradeID, OrderID: "9", InstrumentID: "BTC-USDT", Price: "10000", Timestamp: "2020-10-10T00:00:00Z",
+			Side: side, Currency: "BTC", Size: btc, Fee: btcFee},
+		{LedgerID: tradeID + "2", TradeID: tradeID, OrderID: "9", InstrumentID: "BTC-USDT", Price: "10000", Timestamp: "2020-10-10T00:00:00Z",
+			Side: other, Currency: "USDT", Size: usdt, Fee: usdtFee},
+	}
+}
+
+func TestBookTradeFees(t *testing.T) {
+	tests := []struct {
+		name  string
+		fills []Fill
+		want  string
+		lots  string // of BTC, afterwards
+	}{
+		{"no fee", trade("1", "buy", "0.001", "", "10", ""),
+			"2 BTC 0.001, 4 BTC -0.001, 2 USDT -10, 4 USDT 10", "0.001@10 "},
+		{"paid in what we received", trade("1", "buy", "0.001", "-0.000001", "10", ""),
+			"2 BTC 0.001, 4 BTC -0.001, 5 BTC 0.000001, 2 BTC -0.000001, 2 USDT -10, 4 USDT 10", "0.000999@9.99 "},
+		{"paid in what we gave", trade("1", "buy", "0.001", "", "10", "-0.01"),
+			"2 BTC 0.001, 4 BTC -0.001, 2 USDT -10, 4 USDT 10, 5 USDT 0.01, 2 USDT -0.01", "0.001@10 "},
+		{"a rebate in what we received", trade("1", "buy", "0.001", "0.000001", "10", ""),
+			"2 BTC 0.001, 4 BTC -0.001, 5 BTC -0.000001, 2 BTC 0.000001, 2 USDT -10, 4 USDT 10", "0.001001@10.01 "},
+		{"a rebate in what we gave", trade("1", "buy", "0.001", "", "10", "0.002"),
+			"2 BTC 0.001, 4 BTC -0.001, 2 USDT -10, 4 USDT 10, 5 USDT -0.002, 2 USDT 0.002", "0.001@10 "},
+	}
+	for _, tt := range tests {
+		s, b := newLotsSyncer(t)
+		ok, err := s.bookTrade(tt.fills)
+		if !ok || err != nil || len(b.posted()) != 1 {
+			t.Errorf("%s: bookTrade = %v, %v", tt.name, ok, err)
+			continue
+		}
+		if got := legsString(b.posted()[0].legs); got != tt.want {
+			t.Errorf("%s: legs = %s, want %s", tt.name, got, tt.want)
+		}
+		if got := lotsString(s.Lots, "BTC"); got != tt.lots {
+			t.Errorf("%s: lots = %s, want %s", tt.name, got, tt.lots)
+		}
+	}
+}
+
+func TestBookTradeFeeOnSale(t *testing.T) {
+	s, b := newLotsSyncer(t)
+	if _, err := s.bookTrade(trade("1", "buy", "0.001", "", "10", "-0.01")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sell it for 12 USDT and pay 0.012 USDT for it.  The fee is an expense, so it's left out of the gain, but the sale
+	// remembers it.
+	ok, err := s.bookTrade(trade("2", "sell", "0.001", "", "12", "-0.012"))
+	if !ok || err != nil || len(b.posted()) != 2 {
+		t.Fatalf("bookTrade = %v, %v", ok, err)
+	}
+	want := "2 BTC -0.001, 4 BTC 0.001, 2 USDT 12, 4 USDT -12, 5 USDT 0.012, 2 USDT -0.012, 4 USDT 2, 10 USDT -2"
+	if got := legsString(b.posted()[1].legs); got != want {
+		t.Errorf("legs = %s, want %s", got, want)
+	}
+	disposals := s.Lots.Disposals()
+	if len(disposals) != 1 || !disposals[0].Fee.Equal(decimal.New(12, -3)) || disposals[0].FeeCurrency != "USDT" ||
+		!disposals[0].Proceeds.Equal(decimal.New(12, 0)) || !disposals[0].Cost.Equal(decimal.New(10, 0)) {
+		t.Errorf("disposals = %+v", disposals)
+	}
+	if got := lotsString(s.Lots, "BTC"); got != "" {
+		t.Errorf("lots = %s, want none", got)
+	}
+}
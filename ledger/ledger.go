@@ -0,0 +1,610 @@
+// The purpose of this package is to follow the ledgers that OKEx keeps for us and to book in Bookwerx whatever happens
+// there that OKConnect did not itself cause, such as deposits, spot fills, and their fees.
+//
+// OKEx will not tell us when anything happens so we must ask.  Each ledger that we follow has a cursor that remembers
+// the newest entry we have booked.  Every transaction that we book also carries the OKEx ids in its notes so that if
+// we die after booking but before moving the cursor, the next run will notice and not book it twice.
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/gojektech/heimdall/httpclient"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OKEx returns at most this many entries per request.
+const pageLimit = 100
+
+// The names of the ledgers that we follow, as used for their cursors.
+const (
+	accountLedger   = "account/ledger"
+	spotFillsPrefix = "spot/fills:"
+)
+
+// A spot fill, as returned by /api/spot/v3/fills.  OKEx gives us one of these for each currency involved in a trade.
+type Fill struct {
+	LedgerID     string `json:"ledger_id"`
+	TradeID      string `json:"trade_id"`
+	InstrumentID string `json:"instrument_id"`
+	Price        string `json:"price"`
+	Size         string `json:"size"`
+	OrderID      string `json:"order_id"`
+	Timestamp    string `json:"timestamp"`
+	ExecType     string `json:"exec_type"`
+	Fee          string `json:"fee"`
+	Side         string `json:"side"`
+	Currency     string `json:"currency"`
+}
+
+// One distribution that we intend to make: this amount of this currency into the account tagged with this category.
+type leg struct {
+	category uint32
+	currency string
+	amount   decimal.Decimal
+}
+
+// A Syncer books whatever is new in the ledgers that we follow.
+type Syncer struct {
+	cfg      *config.Config
+	client   *okex.Client
+	clientB  *httpclient.Client
+	cursors  *Cursors
+	accounts map[string]uint32 // Bookwerx account ids by category and currency
+
+	// If a ledger has no cursor yet then book its entire history.  Otherwise merely start following it from now on.
+	Backfill bool
+
+	// If not nil, follow the lots of each currency and book the realised gains.
+	Lots *lots.Book
+}
+
+func NewSyncer(cfg *config.Config, client *okex.Client, cursors *Cursors) *Syncer {
+	return &Syncer{
+		cfg:      cfg,
+		client:   client,
+		clientB:  okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond),
+		cursors:  cursors,
+		accounts: make(map[string]uint32),
+	}
+}
+
+// Book whatever is new in the OKEx ledgers and tell the user how many transactions that took.
+//
+// Example:
+// okconnect sync ledger -config okconnect.yaml
+func Sync(cfg *config.Config, backfill bool) {
+
+	// 1. Read the credentials file for OKEx
+	credentials, err := config.ReadCredentials(cfg.OKExConfig.Credentials)
+	if err != nil {
+		log.Error("Cannot read the OKEx credentials file.")
+		return
+	}
+
+	// 2. Where did we stop last time?
+	cursors, err := OpenCursors(cfg.Cursors)
+	if err != nil {
+		return
+	}
+
+	// 3. Book everything since then.
+	s := NewSyncer(cfg, okex.NewClient(*cfg, *credentials), cursors)
+	s.Backfill = backfill
+	s.Lots, err = lots.Open(cfg.LotsConfig)
+	if err != nil {
+		return
+	}
+	booked, err := s.Sync(context.Background())
+	if err != nil {
+		log.WithError(err).WithField("booked", booked).Error("Cannot book everything in the OKEx ledgers.  Run this again to continue.")
+		return
+	}
+
+	fmt.Printf("{\"booked\":%d}\n", booked)
+}
+
+// Book whatever is new in every ledger that we follow and return how many transactions were booked.  If ctx is
+// cancelled then stop early, but only between transactions.
+func (s *Syncer) Sync(ctx context.Context) (int, error) {
+	booked, err := s.syncAccountLedger(ctx)
+	if err != nil {
+		return booked, err
+	}
+
+	for _, instrument := range s.cfg.OKExConfig.Instruments {
+		n, err := s.syncFills(ctx, instrument)
+		booked += n
+		if err != nil {
+			return booked, err
+		}
+	}
+
+	return booked, nil
+}
+
+// 1. The funding account ledger.  Book the deposits and withdrawals.  The transfers are booked by okconnect transfer
+// and the rest we don't know how to book yet.
+func (s *Syncer) syncAccountLedger(ctx context.Context) (int, error) {
+	endpoint := "/api/account/v3/ledger"
+
+	// 1.1 If we have never followed this ledger, then either start from now on or read the entire history.
+	cursor := s.cursors.Get(accountLedger)
+	if cursor == "" && !s.Backfill {
+		entries := make([]utils.LedgerEntry, 0)
+		err := s.page(endpoint, "", "", &entries)
+		if err != nil {
+			return 0, err
+		}
+		newest := "0"
+		for _, e := range entries {
+			if idLess(newest, e.LedgerID) {
+				newest = e.LedgerID
+			}
+		}
+		log.WithFields(log.Fields{"ledger": accountLedger, "ledger_id": newest}).Info("Following this ledger from now on.  Use -backfill to book its history instead.")
+		return 0, s.cursors.Set(accountLedger, newest)
+	}
+
+	booked := 0
+	for {
+		if ctx.Err() != nil {
+			return booked, nil
+		}
+
+		// 1.2 Get the next page of entries that are newer than the cursor, or all of them if we're backfilling.
+		entries := make([]utils.LedgerEntry, 0)
+		var err error
+		if cursor == "" {
+			err = s.history(func(param string, ledgerID string) (string, int, error) {
+				page := make([]utils.LedgerEntry, 0)
+				err := s.page(endpoint, param, ledgerID, &page)
+				oldest := ""
+				for _, e := range page {
+					if oldest == "" || idLess(e.LedgerID, oldest) {
+						oldest = e.LedgerID
+					}
+				}
+				entries = append(entries, page...)
+				return oldest, len(page), err
+			})
+		} else {
+			err = s.page(endpoint, "before", cursor, &entries)
+		}
+		if err != nil {
+			return booked, err
+		}
+		if len(entries) == 0 {
+			return booked, nil
+		}
+		sort.Slice(entries, func(i, j int) bool { return idLess(entries[i].LedgerID, entries[j].LedgerID) })
+
+		// 1.3 Book them, oldest first, and move the cursor after each one.
+		for _, e := range entries {
+			if ctx.Err() != nil {
+				return booked, nil
+			}
+			ok, err := s.bookAccountEntry(e)
+			if err != nil {
+				return booked, err
+			}
+			if ok {
+				booked++
+			}
+			cursor = e.LedgerID
+			err = s.cursors.Set(accountLedger, cursor)
+			if err != nil {
+				return booked, err
+			}
+		}
+
+		if len(entries) < pageLimit {
+			return booked, nil
+		}
+	}
+}
+
+// Book a single entry from the funding account ledger, if it's something that we know how to book.  A deposit:
+//
+// DR Funding     amount
+// CR In Transit  amount
+//
+// or a withdrawal:
+//
+// DR In Transit  amount
+// CR Funding     amount
+//
+// and if OKEx charged a fee for it
+//
+// DR Fee         fee
+// CR Funding     fee
+func (s *Syncer) bookAccountEntry(e utils.LedgerEntry) (bool, error) {
+	elog := log.WithFields(log.Fields{"ledger_id": e.LedgerID, "typename": e.Typename})
+	deposit := strings.EqualFold(e.Typename, "Deposit")
+	if !deposit && !strings.EqualFold(e.Typename, "Withdrawal") {
+		elog.Debug("Not a deposit or a withdrawal.  Not booking it.")
+		return false, nil
+	}
+
+	// OKEx might or might not give a withdrawal a negative amount.  We know which way it went anyway.
+	amount, err := decimal.NewFromString(e.Amount)
+	if err != nil {
+		elog.WithError(err).WithField("amount", e.Amount).Error("Cannot parse the amount.")
+		return false, err
+	}
+	amount = amount.Abs()
+	if !deposit {
+		amount = amount.Neg()
+	}
+
+	legs := []leg{
+		{s.cfg.BookwerxConfig.CatFunding, e.Currency, amount},
+		{s.cfg.BookwerxConfig.CatInTransit, e.Currency, amount.Neg()},
+	}
+
+	// The funding ledger never gives us a rebate, so whatever the sign, it's a fee that we paid.
+	fee, err := parseFee(e.Fee)
+	if err != nil {
+		elog.WithError(err).WithField("fee", e.Fee).Error("Cannot parse the fee.")
+		return false, err
+	}
+	fee = fee.Abs()
+	if !fee.IsZero() {
+		legs = append(legs,
+			leg{s.cfg.BookwerxConfig.CatFee, e.Currency, fee},
+			leg{s.cfg.BookwerxConfig.CatFunding, e.Currency, fee.Neg()},
+		)
+	}
+
+	tag := fmt.Sprintf("[okex ledger_id=%s]", e.LedgerID)
+	var change *lots.Change
+	if s.Lots != nil {
+		if deposit {
+			change = s.Lots.Deposit(tag, e.Timestamp, e.Currency, amount.Sub(fee))
+		} else {
+			change = s.Lots.Withdraw(tag, e.Currency, amount.Neg().Add(fee))
+		}
+	}
+
+	notes := fmt.Sprintf("Deposit %s %s", amount.String(), e.Currency)
+	if !deposit {
+		notes = fmt.Sprintf("Withdrawal %s %s", amount.Neg().String(), e.Currency)
+	}
+	ok, err := s.book(tag, e.Timestamp, notes, legs)
+	if err != nil {
+		return ok, err
+	}
+	return ok, s.commit(change)
+}
+
+// 2. The spot fills for a single instrument.  Book each trade, with its fee.
+func (s *Syncer) syncFills(ctx context.Context, instrument string) (int, error) {
+	name := spotFillsPrefix + instrument
+	endpoint := "/api/spot/v3/fills?instrument_id=" + instrument
+
+	// 2.1 If we have never followed this ledger, then either start from now on or read the entire history.
+	cursor := s.cursors.Get(name)
+	if cursor == "" && !s.Backfill {
+		fills := make([]Fill, 0)
+		err := s.page(endpoint, "", "", &fills)
+		if err != nil {
+			return 0, err
+		}
+		newest := "0"
+		for _, f := range fills {
+			if idLess(newest, f.LedgerID) {
+				newest = f.LedgerID
+			}
+		}
+		log.WithFields(log.Fields{"ledger": name, "ledger_id": newest}).Info("Following this ledger from now on.  Use -backfill to book its history instead.")
+		return 0, s.cursors.Set(name, newest)
+	}
+
+	booked := 0
+	for {
+		if ctx.Err() != nil {
+			return booked, nil
+		}
+
+		// 2.2 Get the next page of fills that are newer than the cursor, or all of them if we're backfilling.
+		fills := make([]Fill, 0)
+		var err error
+		full := false
+		if cursor == "" {
+			err = s.history(func(param string, ledgerID string) (string, int, error) {
+				page := make([]Fill, 0)
+				err := s.page(endpoint, param, ledgerID, &page)
+				oldest := ""
+				for _, f := range page {
+					if oldest == "" || idLess(f.LedgerID, oldest) {
+						oldest = f.LedgerID
+					}
+				}
+				fills = append(fills, page...)
+				return oldest, len(page), err
+			})
+		} else {
+			err = s.page(endpoint, "before", cursor, &fills)
+			full = len(fills) >= pageLimit
+		}
+		if err != nil {
+			return booked, err
+		}
+		if len(fills) == 0 {
+			return booked, nil
+		}
+		sort.Slice(fills, func(i, j int) bool { return idLess(fills[i].LedgerID, fills[j].LedgerID) })
+
+		// 2.3 Each trade produces one fill per currency.  Put them back together.
+		trades := make([][]Fill, 0)
+		index := make(map[string]int)
+		for _, f := range fills {
+			key := f.OrderID + ":" + f.TradeID
+			i, ok := index[key]
+			if !ok {
+				i = len(trades)
+				index[key] = i
+				trades = append(trades, nil)
+			}
+			trades[i] = append(trades[i], f)
+		}
+
+		// 2.4 If the page is full then the newest trade might be missing a fill that's on the next page.  Leave it
+		// for next time.
+		if full && len(trades) > 1 {
+			trades = trades[:len(trades)-1]
+		}
+
+		// 2.5 Book them, oldest first, and move the cursor after each one.
+		for _, trade := range trades {
+			if ctx.Err() != nil {
+				return booked, nil
+			}
+			ok, err := s.bookTrade(trade)
+			if err != nil {
+				return booked, err
+			}
+			if ok {
+				booked++
+			}
+			for _, f := range trade {
+				if idLess(cursor, f.LedgerID) {
+					cursor = f.LedgerID
+				}
+			}
+			err = s.cursors.Set(name, cursor)
+			if err != nil {
+				return booked, err
+			}
+		}
+
+		if !full {
+			return booked, nil
+		}
+	}
+}
+
+// Book a single trade, given its fills.  For each currency:
+//
+// DR Spot     size  (if we bought it, else CR)
+// CR Trading  size  (if we bought it, else DR)
+//
+// and if OKEx charged a fee in this currency
+//
+// DR Fee      fee
+// CR Spot     fee
+//
+// and if we're following the lots and sold something for their quote currency
+//
+// DR Trading        gain  (or CR, and DR the Realised Loss, for a loss)
+// CR Realised Gain  gain
+func (s *Syncer) bookTrade(trade []Fill) (bool, error) {
+	first := trade[0]
+	tlog := log.WithFields(log.Fields{"instrument_id": first.InstrumentID, "order_id": first.OrderID, "trade_id": first.TradeID})
+	tag := fmt.Sprintf("[okex order_id=%s trade_id=%s]", first.OrderID, first.TradeID)
+
+	legs := make([]leg, 0)
+	var received, given lots.Flow
+	for _, f := range trade {
+		size, err := decimal.NewFromString(f.Size)
+		if err != nil {
+			tlog.WithError(err).WithField("size", f.Size).Error("Cannot parse the size.")
+			return false, err
+		}
+		if f.Side != "buy" {
+			size = size.Neg()
+		}
+		legs = append(legs,
+			leg{s.cfg.BookwerxConfig.CatSpotAvailable, f.Currency, size},
+			leg{s.cfg.BookwerxConfig.CatTrading, f.Currency, size.Neg()},
+		)
+
+		fee, err := parseFee(f.Fee)
+		if err != nil {
+			tlog.WithError(err).WithField("fee", f.Fee).Error("Cannot parse the fee.")
+			return false, err
+		}
+		if !fee.IsZero() {
+			legs = append(legs,
+				leg{s.cfg.BookwerxConfig.CatFee, f.Currency, fee},
+				leg{s.cfg.BookwerxConfig.CatSpotAvailable, f.Currency, fee.Neg()},
+			)
+		}
+
+		if f.Side == "buy" {
+			received = lots.Flow{Currency: f.Currency, Quantity: size, Fee: fee}
+		} else {
+			given = lots.Flow{Currency: f.Currency, Quantity: size.Neg(), Fee: fee}
+		}
+	}
+
+	var change *lots.Change
+	if s.Lots != nil {
+		var err error
+		change, err = s.Lots.Trade(tag, first.Timestamp, received, given)
+		if err != nil {
+			tlog.WithError(err).Error("Cannot apply this trade to the lots.")
+			return false, err
+		}
+		if change != nil && change.Realised && !change.Gain.IsZero() {
+			quote := s.Lots.Quote()
+			if change.Gain.IsPositive() {
+				legs = append(legs,
+					leg{s.cfg.BookwerxConfig.CatTrading, quote, change.Gain},
+					leg{s.cfg.BookwerxConfig.CatRealisedGain, quote, change.Gain.Neg()},
+				)
+			} else {
+				legs = append(legs,
+					leg{s.cfg.BookwerxConfig.CatRealisedLoss, quote, change.Gain.Neg()},
+					leg{s.cfg.BookwerxConfig.CatTrading, quote, change.Gain},
+				)
+			}
+		}
+	}
+
+	notes := fmt.Sprintf("Spot trade %s at %s", first.InstrumentID, first.Price)
+	ok, err := s.book(tag, first.Timestamp, notes, legs)
+	if err != nil {
+		return ok, err
+	}
+	return ok, s.commit(change)
+}
+
+// Apply the change to the lots, now that its transaction is booked.  Do this even if an earlier run booked it, because
+// that run might have died before getting this far.
+func (s *Syncer) commit(change *lots.Change) error {
+	if change == nil {
+		return nil
+	}
+	return s.Lots.Commit(change)
+}
+
+// Book a transaction made of the given legs, unless Bookwerx already has a transaction whose notes contain the tag.
+// Return true if we booked it.
+func (s *Syncer) book(tag string, timestamp string, notes string, legs []leg) (bool, error) {
+	blog := log.WithField("tag", tag)
+
+	// 1. Perhaps an earlier run has already booked this.
+	txids, err := bookwerx.FindTransactionsByNotes(s.clientB, tag, *s.cfg)
+	if err != nil {
+		blog.WithError(err).Error("Cannot determine whether bookwerx already has this.")
+		return false, err
+	}
+	if len(txids) > 0 {
+		blog.WithField("transaction_id", txids[0]).Info("Bookwerx already has this.")
+		return false, nil
+	}
+
+	// 2. Find all the accounts before we write anything.  Write the time as Bookwerx writes its own, whatever OKEx said.
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		blog.WithError(err).WithField("timestamp", timestamp).Error("Cannot parse the time.")
+		return false, err
+	}
+	tx := bookwerx.NewTransaction(t.UTC().Format(bookwerx.TimeFormat), notes+" "+tag)
+	for _, l := range legs {
+		accountID, err := s.account(l.category, l.currency)
+		if err != nil {
+			return false, err
+		}
+		tx.Add(accountID, l.currency, l.amount)
+	}
+
+	// 3. Create the tx and all of its distributions, or none of them.
+	txid, err := tx.Post(s.clientB, *s.cfg)
+	if err != nil {
+		return false, err
+	}
+
+	blog.WithFields(log.Fields{"transaction_id": txid, "notes": notes}).Info("Booked.")
+	return true, nil
+}
+
+// Find the Bookwerx account for the given category and currency.  Remember it because we'll want it again.
+func (s *Syncer) account(category uint32, currency string) (uint32, error) {
+	key := fmt.Sprintf("%d:%s", category, currency)
+	if id, ok := s.accounts[key]; ok {
+		return id, nil
+	}
+
+	id, err := bookwerx.FindCategoryAccount(s.clientB, category, currency, *s.cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	s.accounts[key] = id
+	return id, nil
+}
+
+// Get one page of a ledger, newest first.  The param is "before" to get entries newer than the ledgerID, "after" to
+// get entries older than it, or "" to get the newest entries.
+func (s *Syncer) page(endpoint string, param string, ledgerID string, entries interface{}) error {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	endpoint = fmt.Sprintf("%s%slimit=%d", endpoint, sep, pageLimit)
+	if param != "" {
+		endpoint = fmt.Sprintf("%s&%s=%s", endpoint, param, ledgerID)
+	}
+
+	body, err := s.client.Do("GET", endpoint, "")
+	if err != nil {
+		return err
+	}
+
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(entries)
+	if err != nil {
+		log.WithError(err).WithField("endpoint", endpoint).Error("Cannot decode the OKEx ledger.")
+		return err
+	}
+	return nil
+}
+
+// Walk backwards through the entire history of a ledger.  Each call to page gets the page older than the given
+// ledgerID and returns the oldest ledger_id found in it and how many entries it had.
+func (s *Syncer) history(page func(param string, ledgerID string) (string, int, error)) error {
+	param, ledgerID := "", ""
+	for {
+		oldest, n, err := page(param, ledgerID)
+		if err != nil {
+			return err
+		}
+		if n < pageLimit {
+			return nil
+		}
+		param, ledgerID = "after", oldest
+	}
+}
+
+// OKEx ledger_ids are numbers that may be too big for us, so compare them as strings of digits.
+func idLess(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// OKEx reports fees as negative numbers, or positive for a rebate, or sometimes as "".  Return what we paid.
+func parseFee(fee string) (decimal.Decimal, error) {
+	if fee == "" {
+		return decimal.Zero, nil
+	}
+	f, err := decimal.NewFromString(fee)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return f.Neg(), nil
+}
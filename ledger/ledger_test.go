@@ -0,0 +1,387 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+)
+
+// The categories of the test config.
+var testBookwerxConfig = config.BookwerxConfig{
+	CatFunding: 1, CatSpotAvailable: 2, CatInTransit: 3, CatTrading: 4, CatFee: 5, CatSwap: 6, CatSwapPNL: 7,
+	CatSwapFunding: 8, CatSwapLiquidation: 9, CatRealisedGain: 10, CatRealisedLoss: 11,
+}
+
+// The currencies that the fake Bookwerx has accounts for.  The account of a category and a currency is numbered
+// 100 * the category + the index of the currency.
+var testCurrencies = []string{"BTC", "USDT", "ETH"}
+
+type fakeTransaction struct {
+	time  string
+	notes string
+	legs  []leg
+}
+
+// A Bookwerx that has an account for every category and currency and that remembers the transactions that are posted.
+type fakeBookwerx struct {
+	mu           sync.Mutex
+	transactions []*fakeTransaction
+}
+
+var (
+	notesQuery   = regexp.MustCompile(`LIKE '%(.*)%' ESCAPE '!'`)
+	accountQuery = regexp.MustCompile(`category_id=(\d+) AND currencies.symbol='(\w+)'`)
+	likeEscape   = regexp.MustCompile(`!(.)`)
+)
+
+func (b *fakeBookwerx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_ = r.ParseForm()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/sql":
+		query := r.Form.Get("query")
+		if m := notesQuery.FindStringSubmatch(query); m != nil {
+			text := strings.ReplaceAll(likeEscape.ReplaceAllString(m[1], "$1"), "''", "'")
+			ids := make([]string, 0)
+			for i, tx := range b.transactions {
+				if strings.Contains(tx.notes, text) {
+					ids = append(ids, fmt.Sprintf(`{"transactions.id":%d}`, i+1))
+				}
+			}
+			fmt.Fprint(w, "["+strings.Join(ids, ",")+"]")
+			return
+		}
+		if m := accountQuery.FindStringSubmatch(query); m != nil {
+			category, _ := strconv.Atoi(m[1])
+			for i, currency := range testCurrencies {
+				if currency == m[2] {
+					fmt.Fprintf(w, `[{"accounts.id":%d}]`, 100*category+i)
+					return
+				}
+			}
+			fmt.Fprint(w, "[]")
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+
+	case r.Method == "POST" && r.URL.Path == "/transactions":
+		b.transactions = append(b.transactions, &fakeTransaction{time: r.Form.Get("time"), notes: r.Form.Get("notes")})
+		fmt.Fprintf(w, `{"LastInsertId":%d}`, len(b.transactions))
+
+	case r.Method == "POST" && r.URL.Path == "/distributions":
+		accountID, _ := strconv.Atoi(r.Form.Get("account_id"))
+		amount, _ := strconv.ParseInt(r.Form.Get("amount"), 10, 64)
+		exp, _ := strconv.Atoi(r.Form.Get("amount_exp"))
+		txid, _ := strconv.Atoi(r.Form.Get("transaction_id"))
+		tx := b.transactions[txid-1]
+		tx.legs = append(tx.legs, leg{uint32(accountID / 100), testCurrencies[accountID%100], decimal.New(amount, int32(exp))})
+		fmt.Fprintf(w, `{"LastInsertId":%d}`, 1000+accountID)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// The transactions posted so far.
+func (b *fakeBookwerx) posted() []*fakeTransaction {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*fakeTransaction(nil), b.transactions...)
+}
+
+// A Syncer that talks to the given OKEx, if any, and to a fake Bookwerx, with its cursors in a temporary directory.
+func newTestSyncer(t *testing.T, okexHandler http.Handler) (*Syncer, *fakeBookwerx) {
+	b := &fakeBookwerx{}
+	bookwerxServer := httptest.NewServer(b)
+	t.Cleanup(bookwerxServer.Close)
+
+	client := &okex.Client{HTTPClient: http.DefaultClient}
+	if okexHandler != nil {
+		okexServer := httptest.NewServer(okexHandler)
+		t.Cleanup(okexServer.Close)
+		client.BaseURL = okexServer.URL
+	}
+
+	cursors, err := OpenCursors(filepath.Join(t.TempDir(), "okconnect.cursors"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BookwerxConfig: testBookwerxConfig}
+	cfg.BookwerxConfig.BaseURL = bookwerxServer.URL
+	return NewSyncer(cfg, client, cursors), b
+}
+
+// Describe the legs, such as "6 BTC 0.01, 7 BTC -0.01", in order to compare them.
+func legsString(legs []leg) string {
+	s := make([]string, 0, len(legs))
+	for _, l := range legs {
+		s = append(s, fmt.Sprintf("%d %s %s", l.category, l.currency, l.amount.String()))
+	}
+	return strings.Join(s, ", ")
+}
+
+// An OKEx ledger whose entries have the ledger_ids 1 to last, made by the given func.  It pages as OKEx does, newest
+// first: before gets the entries just newer than a ledger_id, after gets those just older, and neither gets the newest.
+type fakeLedger struct {
+	mu    sync.Mutex
+	last  int
+	entry func(id int) interface{}
+}
+
+func (l *fakeLedger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	from, to := l.last-limit+1, l.last
+	if before := q.Get("before"); before != "" {
+		id, _ := strconv.Atoi(before)
+		from, to = id+1, id+limit
+	}
+	if after := q.Get("after"); after != "" {
+		id, _ := strconv.Atoi(after)
+		from, to = id-limit, id-1
+	}
+	if from < 1 {
+		from = 1
+	}
+	if to > l.last {
+		to = l.last
+	}
+
+	page := make([]interface{}, 0)
+	for id := to; id >= from; id-- {
+		page = append(page, l.entry(id))
+	}
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+func (l *fakeLedger) setLast(last int) {
+	l.mu.Lock()
+	l.last = last
+	l.mu.Unlock()
+}
+
+// Every tenth entry of the funding ledger is a transfer, which isn't booked, and the rest are deposits.
+func accountEntry(id int) interface{} {
+	typename := "Deposit"
+	if id%10 == 0 {
+		typename = "Transfer"
+	}
+	return utils.LedgerEntry{LedgerID: strconv.Itoa(id), Typename: typename, Currency: "BTC", Amount: "1", Timestamp: "2020-10-10T00:00:00Z"}
+}
+
+// The ledger_ids in the notes of the transactions, in the order that they were posted.
+func bookedIDs(transactions []*fakeTransaction) []int {
+	retVal := make([]int, 0, len(transactions))
+	for _, tx := range transactions {
+		i := strings.LastIndex(tx.notes, "ledger_id=")
+		id, _ := strconv.Atoi(strings.TrimSuffix(tx.notes[i+len("ledger_id="):], "]"))
+		retVal = append(retVal, id)
+	}
+	return retVal
+}
+
+// The deposits between the given ledger_ids, inclusive, that should have been booked.
+func deposits(from int, to int) []int {
+	retVal := make([]int, 0)
+	for id := from; id <= to; id++ {
+		if id%10 != 0 {
+			retVal = append(retVal, id)
+		}
+	}
+	return retVal
+}
+
+func TestSyncAccountLedgerFollow(t *testing.T) {
+	l := &fakeLedger{last: 150, entry: accountEntry}
+	s, b := newTestSyncer(t, l)
+
+	// 1. Without a cursor, merely start following the ledger from now on.
+	booked, err := s.syncAccountLedger(context.Background())
+	if err != nil || booked != 0 || len(b.posted()) != 0 {
+		t.Fatalf("syncAccountLedger = %d, %v, posted %d", booked, err, len(b.posted()))
+	}
+	if cursor := s.cursors.Get(accountLedger); cursor != "150" {
+		t.Fatalf("cursor = %s, want 150", cursor)
+	}
+
+	// 2. Then book whatever is new, oldest first, a page at a time.
+	l.setLast(365)
+	booked, err = s.syncAccountLedger(context.Background())
+	want := deposits(151, 365)
+	if err != nil || booked != len(want) {
+		t.Fatalf("syncAccountLedger = %d, %v, want %d", booked, err, len(want))
+	}
+	if got := bookedIDs(b.posted()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("booked %v, want %v", got, want)
+	}
+	if cursor := s.cursors.Get(accountLedger); cursor != "365" {
+		t.Errorf("cursor = %s, want 365", cursor)
+	}
+
+	// 3. And nothing more until there's something new.
+	booked, err = s.syncAccountLedger(context.Background())
+	if err != nil || booked != 0 {
+		t.Errorf("syncAccountLedger again = %d, %v, want 0", booked, err)
+	}
+
+	// 4. The cursor is kept in the file.
+	cursors, err := OpenCursors(s.cursors.path)
+	if err != nil || cursors.Get(accountLedger) != "365" {
+		t.Errorf("the cursors file says %s, %v", cursors.Get(accountLedger), err)
+	}
+}
+
+func TestSyncAccountLedgerBackfill(t *testing.T) {
+	l := &fakeLedger{last: 250, entry: accountEntry}
+	s, b := newTestSyncer(t, l)
+	s.Backfill = true
+
+	// An earlier run died after booking ledger_id 3 but before moving the cursor.
+	b.transactions = append(b.transactions, &fakeTransaction{notes: "Deposit 1 BTC [okex ledger_id=3]"})
+
+	booked, err := s.syncAccountLedger(context.Background())
+	want := deposits(1, 250)
+	if err != nil || booked != len(want)-1 {
+		t.Fatalf("syncAccountLedger = %d, %v, want %d", booked, err, len(want)-1)
+	}
+	want = append([]int{3}, append(want[:2:2], want[3:]...)...)
+	if got := bookedIDs(b.posted()); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("booked %v, want %v", got, want)
+	}
+	if cursor := s.cursors.Get(accountLedger); cursor != "250" {
+		t.Errorf("cursor = %s, want 250", cursor)
+	}
+}
+
+func TestSyncAccountLedgerCancelled(t *testing.T) {
+	s, b := newTestSyncer(t, &fakeLedger{last: 20, entry: accountEntry})
+	s.Backfill = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	booked, err := s.syncAccountLedger(ctx)
+	if err != nil || booked != 0 || len(b.posted()) != 0 || s.cursors.Get(accountLedger) != "" {
+		t.Errorf("syncAccountLedger = %d, %v, posted %d, cursor %s", booked, err, len(b.posted()), s.cursors.Get(accountLedger))
+	}
+}
+
+func TestBook(t *testing.T) {
+	s, b := newTestSyncer(t, nil)
+	legs := []leg{{1, "BTC", decimal.New(15, -1)}, {3, "BTC", decimal.New(-15, -1)}}
+
+	ok, err := s.book("[okex ledger_id=7]", "2020-10-10T12:34:56Z", "Deposit 1.5 BTC", legs)
+	if !ok || err != nil {
+		t.Fatalf("book = %v, %v", ok, err)
+	}
+	tx := b.posted()[0]
+	if tx.time != "2020-10-10T12:34:56.000Z" || tx.notes != "Deposit 1.5 BTC [okex ledger_id=7]" || legsString(tx.legs) != "1 BTC 1.5, 3 BTC -1.5" {
+		t.Errorf("posted %+v", tx)
+	}
+
+	// The same tag is not booked twice, but another is, even if it looks alike.
+	ok, err = s.book("[okex ledger_id=7]", "2020-10-10T12:34:56Z", "Deposit 1.5 BTC", legs)
+	if ok || err != nil || len(b.posted()) != 1 {
+		t.Errorf("book again = %v, %v, posted %d", ok, err, len(b.posted()))
+	}
+	ok, err = s.book("[okex ledger_id=70]", "2020-10-10T12:34:56Z", "Deposit 1.5 BTC", legs)
+	if !ok || err != nil || len(b.posted()) != 2 {
+		t.Errorf("book another = %v, %v, posted %d", ok, err, len(b.posted()))
+	}
+
+	// Nothing is written unless every account is found first.
+	_, err = s.book("[okex ledger_id=8]", "2020-10-10T12:34:56Z", "Deposit 1 LTC", []leg{{1, "LTC", decimal.New(1, 0)}, {3, "LTC", decimal.New(-1, 0)}})
+	if err == nil || len(b.posted()) != 2 {
+		t.Errorf("book without an account = %v, posted %d", err, len(b.posted()))
+	}
+}
+
+func TestBookAccountEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry utils.LedgerEntry
+		want  string // the legs, or "" if nothing is booked
+		notes string
+	}{
+		{"deposit", utils.LedgerEntry{Typename: "Deposit", Currency: "BTC", Amount: "1.5"}, "1 BTC 1.5, 3 BTC -1.5", "Deposit 1.5 BTC"},
+		{"in any case", utils.LedgerEntry{Typename: "deposit", Currency: "USDT", Amount: "100"}, "1 USDT 100, 3 USDT -100", "Deposit 100 USDT"},
+		{"withdrawal", utils.LedgerEntry{Typename: "Withdrawal", Currency: "BTC", Amount: "2"}, "1 BTC -2, 3 BTC 2", "Withdrawal 2 BTC"},
+		{"negative withdrawal", utils.LedgerEntry{Typename: "Withdrawal", Currency: "BTC", Amount: "-2"}, "1 BTC -2, 3 BTC 2", "Withdrawal 2 BTC"},
+		{"transfer", utils.LedgerEntry{Typename: "Transfer", Currency: "BTC", Amount: "-2"}, "", ""},
+	}
+	for _, tt := range tests {
+		s, b := newTestSyncer(t, nil)
+		tt.entry.LedgerID, tt.entry.Timestamp = "7", "2020-10-10T00:00:00Z"
+
+		ok, err := s.bookAccountEntry(tt.entry)
+		posted := b.posted()
+		switch {
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == "" && (ok || len(posted) != 0):
+			t.Errorf("%s: booked %d transactions, want none", tt.name, len(posted))
+		case tt.want != "" && (!ok || len(posted) != 1):
+			t.Errorf("%s: booked = %v, %d transactions, want one", tt.name, ok, len(posted))
+		case tt.want != "" && (legsString(posted[0].legs) != tt.want || posted[0].notes != tt.notes+" [okex ledger_id=7]"):
+			t.Errorf("%s: legs = %s, notes = %s, want %s, %s", tt.name, legsString(posted[0].legs), posted[0].notes, tt.want, tt.notes)
+		}
+	}
+}
+
+func TestSyncFills(t *testing.T) {
+	// Each trade has two fills, one per currency, except the first, which has only one.  So the first page ends with
+	// only the first fill of a trade.
+	fill := func(id int) interface{} {
+		f := Fill{LedgerID: strconv.Itoa(id), TradeID: strconv.Itoa(id / 2), OrderID: "9", InstrumentID: "BTC-USDT", Price: "10000",
+			Timestamp: "2020-10-10T00:00:00Z", Side: "buy", Currency: "BTC", Size: "0.001"}
+		if id%2 == 1 {
+			f.Side, f.Currency, f.Size = "sell", "USDT", "10"
+		}
+		return f
+	}
+	l := &fakeLedger{last: 131, entry: fill}
+	s, b := newTestSyncer(t, l)
+	name := spotFillsPrefix + "BTC-USDT"
+	if err := s.cursors.Set(name, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	booked, err := s.syncFills(context.Background(), "BTC-USDT")
+	if err != nil || booked != 66 {
+		t.Fatalf("syncFills = %d, %v, want 66", booked, err)
+	}
+	posted := b.posted()
+	for i, tx := range posted {
+		want := "2 BTC 0.001, 4 BTC -0.001, 2 USDT -10, 4 USDT 10"
+		if i == 0 {
+			want = "2 USDT -10, 4 USDT 10"
+		}
+		if got := legsString(tx.legs); got != want {
+			t.Errorf("trade %d: legs = %s, want %s", i, got, want)
+		}
+		if wantNotes := fmt.Sprintf("Spot trade BTC-USDT at 10000 [okex order_id=9 trade_id=%d]", i); tx.notes != wantNotes {
+			t.Errorf("trade %d: notes = %s, want %s", i, tx.notes, wantNotes)
+		}
+	}
+	if cursor := s.cursors.Get(name); cursor != "131" {
+		t.Errorf("cursor = %s, want 131", cursor)
+	}
+}
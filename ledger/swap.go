@@ -0,0 +1,251 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// The prefix of the names of the perpetual swap ledgers, as used for their cursors.
+const swapLedgerPrefix = "swap/ledger:"
+
+// An entry in the ledger of a perpetual swap account, as returned by /api/swap/v3/accounts/<instrument_id>/ledger.
+type SwapLedgerEntry struct {
+	LedgerID     string `json:"ledger_id"`
+	Amount       string `json:"amount"`
+	Type         string `json:"type"`
+	Fee          string `json:"fee"`
+	Timestamp    string `json:"timestamp"`
+	InstrumentID string `json:"instrument_id"`
+	Currency     string `json:"currency"`
+}
+
+// Book whatever is new in the ledgers of the perpetual swap accounts and tell the user how many transactions that
+// took.
+//
+// Example:
+// okconnect sync swap-ledger -config okconnect.yaml
+func SyncSwap(cfg *config.Config, backfill bool) {
+	bc := cfg.BookwerxConfig
+	if bc.CatSwap == 0 || bc.CatSwapPNL == 0 || bc.CatSwapFunding == 0 || bc.CatSwapLiquidation == 0 || bc.CatFee == 0 {
+		log.Error("The config must say which categories are cat_swap, cat_swap_pnl, cat_swap_funding, cat_swap_liquidation, and cat_fee.")
+		return
+	}
+
+	// 1. Read the credentials file for OKEx
+	credentials, err := config.ReadCredentials(cfg.OKExConfig.Credentials)
+	if err != nil {
+		log.Error("Cannot read the OKEx credentials file.")
+		return
+	}
+
+	// 2. Where did we stop last time?
+	cursors, err := OpenCursors(cfg.Cursors)
+	if err != nil {
+		return
+	}
+
+	// 3. Book everything since then.
+	s := NewSyncer(cfg, okex.NewClient(*cfg, *credentials), cursors)
+	s.Backfill = backfill
+	booked := 0
+	for _, instrument := range cfg.OKExConfig.SwapInstruments {
+		n, err := s.syncSwapLedger(context.Background(), instrument)
+		booked += n
+		if err != nil {
+			log.WithError(err).WithField("booked", booked).Error("Cannot book everything in the OKEx swap ledgers.  Run this again to continue.")
+			return
+		}
+	}
+
+	fmt.Printf("{\"booked\":%d}\n", booked)
+}
+
+// 3. The ledger of a single perpetual swap account.  Book the realised PnL, funding fees, liquidation fees, and other
+// fees.  The transfers are booked by okconnect transfer and the rest we don't know how to book yet.
+func (s *Syncer) syncSwapLedger(ctx context.Context, instrument string) (int, error) {
+	name := swapLedgerPrefix + instrument
+	endpoint := "/api/swap/v3/accounts/" + instrument + "/ledger"
+
+	// 3.1 If we have never followed this ledger, then either start from now on or read the entire history.
+	cursor := s.cursors.Get(name)
+	if cursor == "" && !s.Backfill {
+		entries := make([]SwapLedgerEntry, 0)
+		err := s.page(endpoint, "", "", &entries)
+		if err != nil {
+			return 0, err
+		}
+		newest := "0"
+		for _, e := range entries {
+			if idLess(newest, e.LedgerID) {
+				newest = e.LedgerID
+			}
+		}
+		log.WithFields(log.Fields{"ledger": name, "ledger_id": newest}).Info("Following this ledger from now on.  Use -backfill to book its history instead.")
+		return 0, s.cursors.Set(name, newest)
+	}
+
+	booked := 0
+	for {
+		if ctx.Err() != nil {
+			return booked, nil
+		}
+
+		// 3.2 Get the next page of entries that are newer than the cursor, or all of them if we're backfilling.
+		entries := make([]SwapLedgerEntry, 0)
+		var err error
+		if cursor == "" {
+			err = s.history(func(param string, ledgerID string) (string, int, error) {
+				page := make([]SwapLedgerEntry, 0)
+				err := s.page(endpoint, param, ledgerID, &page)
+				oldest := ""
+				for _, e := range page {
+					if oldest == "" || idLess(e.LedgerID, oldest) {
+						oldest = e.LedgerID
+					}
+				}
+				entries = append(entries, page...)
+				return oldest, len(page), err
+			})
+		} else {
+			err = s.page(endpoint, "before", cursor, &entries)
+		}
+		if err != nil {
+			return booked, err
+		}
+		if len(entries) == 0 {
+			return booked, nil
+		}
+		sort.Slice(entries, func(i, j int) bool { return idLess(entries[i].LedgerID, entries[j].LedgerID) })
+
+		// 3.3 Book them, oldest first, and move the cursor after each one.
+		for _, e := range entries {
+			if ctx.Err() != nil {
+				return booked, nil
+			}
+			if e.InstrumentID == "" {
+				e.InstrumentID = instrument
+			}
+			ok, err := s.bookSwapEntry(e)
+			if err != nil {
+				return booked, err
+			}
+			if ok {
+				booked++
+			}
+			cursor = e.LedgerID
+			err = s.cursors.Set(name, cursor)
+			if err != nil {
+				return booked, err
+			}
+		}
+
+		if len(entries) < pageLimit {
+			return booked, nil
+		}
+	}
+}
+
+// Book a single entry from a swap ledger, if it's something that we know how to book.  The amount is signed, so for
+// a realised profit, or funding that we received:
+//
+// DR Swap      amount
+// CR PnL       amount  (or Funding, Liquidation, or Fee, according to the type)
+//
+// and the other way around for a loss or a fee that we paid.  If OKEx charged a fee for it too
+//
+// DR Fee       fee
+// CR Swap      fee
+func (s *Syncer) bookSwapEntry(e SwapLedgerEntry) (bool, error) {
+	elog := log.WithFields(log.Fields{"instrument_id": e.InstrumentID, "ledger_id": e.LedgerID, "type": e.Type})
+	bc := s.cfg.BookwerxConfig
+
+	var category uint32
+	var what string
+	switch strings.ToLower(e.Type) {
+	case "match", "settlement":
+		category, what = bc.CatSwapPNL, "realised PnL"
+	case "funding":
+		category, what = bc.CatSwapFunding, "funding fee"
+	case "liquidation":
+		category, what = bc.CatSwapLiquidation, "liquidation fee"
+	case "fee":
+		category, what = bc.CatFee, "fee"
+	default:
+		elog.Debug("Not something that we know how to book.  Not booking it.")
+		return false, nil
+	}
+
+	currency := e.Currency
+	if currency == "" {
+		currency = swapCurrency(e.InstrumentID)
+	}
+	if currency == "" {
+		err := errors.New("ledger:swap.go:bookSwapEntry: Cannot tell the currency of the swap")
+		elog.WithError(err).Error("Cannot book this entry.")
+		return false, err
+	}
+
+	amount, err := decimal.NewFromString(e.Amount)
+	if err != nil {
+		elog.WithError(err).WithField("amount", e.Amount).Error("Cannot parse the amount.")
+		return false, err
+	}
+
+	fee, err := parseFee(e.Fee)
+	if err != nil {
+		elog.WithError(err).WithField("fee", e.Fee).Error("Cannot parse the fee.")
+		return false, err
+	}
+
+	// An entry that is itself a fee may say so in its amount, its fee, or both.  It's the same fee, so book it once.
+	if strings.EqualFold(e.Type, "fee") {
+		if amount.IsZero() {
+			amount = fee.Neg()
+		}
+		fee = decimal.Zero
+	}
+
+	legs := make([]leg, 0, 4)
+	if !amount.IsZero() {
+		legs = append(legs,
+			leg{bc.CatSwap, currency, amount},
+			leg{category, currency, amount.Neg()},
+		)
+	}
+
+	if !fee.IsZero() {
+		legs = append(legs,
+			leg{bc.CatFee, currency, fee},
+			leg{bc.CatSwap, currency, fee.Neg()},
+		)
+	}
+
+	if len(legs) == 0 {
+		elog.Debug("Nothing to book.")
+		return false, nil
+	}
+
+	tag := fmt.Sprintf("[okex instrument_id=%s ledger_id=%s]", e.InstrumentID, e.LedgerID)
+	notes := fmt.Sprintf("Swap %s %s %s on %s", what, amount.String(), currency, e.InstrumentID)
+	return s.book(tag, e.Timestamp, notes, legs)
+}
+
+// The margin of a coin-margined swap, such as BTC-USD-SWAP, is the coin.  Otherwise, such as for BTC-USDT-SWAP, it's
+// the quote currency.
+func swapCurrency(instrument string) string {
+	parts := strings.Split(instrument, "-")
+	if len(parts) != 3 {
+		return ""
+	}
+	if parts[1] == "USD" {
+		return parts[0]
+	}
+	return parts[1]
+}
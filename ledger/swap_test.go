@@ -0,0 +1,96 @@
+package ledger
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBookSwapEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry SwapLedgerEntry
+		want  string // the legs, or "" if nothing is booked
+	}{
+		{"realised profit", SwapLedgerEntry{Type: "match", Amount: "0.01", InstrumentID: "BTC-USD-SWAP"}, "6 BTC 0.01, 7 BTC -0.01"},
+		{"realised loss, with a fee", SwapLedgerEntry{Type: "match", Amount: "-0.02", Fee: "-0.0005", InstrumentID: "BTC-USD-SWAP"},
+			"6 BTC -0.02, 7 BTC 0.02, 5 BTC 0.0005, 6 BTC -0.0005"},
+		{"a maker rebate", SwapLedgerEntry{Type: "match", Amount: "0.01", Fee: "0.0001", InstrumentID: "BTC-USD-SWAP"},
+			"6 BTC 0.01, 7 BTC -0.01, 5 BTC -0.0001, 6 BTC 0.0001"},
+		{"settlement", SwapLedgerEntry{Type: "settlement", Amount: "-3", InstrumentID: "BTC-USDT-SWAP"}, "6 USDT -3, 7 USDT 3"},
+		{"funding received", SwapLedgerEntry{Type: "funding", Amount: "0.001", InstrumentID: "BTC-USD-SWAP"}, "6 BTC 0.001, 8 BTC -0.001"},
+		{"funding paid", SwapLedgerEntry{Type: "funding", Amount: "-0.001", InstrumentID: "BTC-USD-SWAP"}, "6 BTC -0.001, 8 BTC 0.001"},
+		{"liquidation", SwapLedgerEntry{Type: "liquidation", Amount: "-0.5", InstrumentID: "BTC-USDT-SWAP"}, "6 USDT -0.5, 9 USDT 0.5"},
+		{"a fee in the amount", SwapLedgerEntry{Type: "fee", Amount: "-0.0003", InstrumentID: "BTC-USD-SWAP"}, "6 BTC -0.0003, 5 BTC 0.0003"},
+		{"a fee in the fee", SwapLedgerEntry{Type: "fee", Amount: "0", Fee: "-0.0003", InstrumentID: "BTC-USD-SWAP"}, "6 BTC -0.0003, 5 BTC 0.0003"},
+		{"a fee in both, booked once", SwapLedgerEntry{Type: "fee", Amount: "-0.0003", Fee: "-0.0003", InstrumentID: "BTC-USD-SWAP"}, "6 BTC -0.0003, 5 BTC 0.0003"},
+		{"a fee rebate", SwapLedgerEntry{Type: "fee", Amount: "0", Fee: "0.0001", InstrumentID: "BTC-USD-SWAP"}, "6 BTC 0.0001, 5 BTC -0.0001"},
+		{"the currency that OKEx gives", SwapLedgerEntry{Type: "funding", Amount: "1", Currency: "ETH", InstrumentID: "BTC-USDT-SWAP"}, "6 ETH 1, 8 ETH -1"},
+		{"a transfer is booked by okconnect transfer", SwapLedgerEntry{Type: "transfer", Amount: "1", InstrumentID: "BTC-USD-SWAP"}, ""},
+		{"nothing at all", SwapLedgerEntry{Type: "match", Amount: "0", InstrumentID: "BTC-USD-SWAP"}, ""},
+	}
+	for _, tt := range tests {
+		s, b := newTestSyncer(t, nil)
+		tt.entry.LedgerID, tt.entry.Timestamp = "7", "2020-10-10T00:00:00.000Z"
+
+		ok, err := s.bookSwapEntry(tt.entry)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		posted := b.posted()
+		if !ok || len(posted) != 1 {
+			if tt.want != "" || len(posted) != 0 {
+				t.Errorf("%s: booked = %v, %d transactions, want %s", tt.name, ok, len(posted), tt.want)
+			}
+			continue
+		}
+		if got := legsString(posted[0].legs); got != tt.want {
+			t.Errorf("%s: legs = %s, want %s", tt.name, got, tt.want)
+		}
+		if !strings.HasSuffix(posted[0].notes, "[okex instrument_id="+tt.entry.InstrumentID+" ledger_id=7]") {
+			t.Errorf("%s: notes = %s", tt.name, posted[0].notes)
+		}
+
+		// It's only booked once.
+		ok, err = s.bookSwapEntry(tt.entry)
+		if ok || err != nil || len(b.posted()) != 1 {
+			t.Errorf("%s: booked again = %v, %v", tt.name, ok, err)
+		}
+	}
+}
+
+func TestBookSwapEntryErrors(t *testing.T) {
+	s, b := newTestSyncer(t, nil)
+	tests := []SwapLedgerEntry{
+		{Type: "match", Amount: "1", InstrumentID: "BTC-USDT"},
+		{Type: "match", Amount: "one", InstrumentID: "BTC-USD-SWAP"},
+		{Type: "match", Amount: "1", Fee: "some", InstrumentID: "BTC-USD-SWAP"},
+	}
+	for _, e := range tests {
+		e.LedgerID, e.Timestamp = "7", "2020-10-10T00:00:00.000Z"
+		if ok, err := s.bookSwapEntry(e); ok || err == nil {
+			t.Errorf("%+v: bookSwapEntry = %v, %v, want an error", e, ok, err)
+		}
+	}
+	if len(b.posted()) != 0 {
+		t.Errorf("booked %d transactions, want none", len(b.posted()))
+	}
+}
+
+func TestSwapCurrency(t *testing.T) {
+	tests := []struct {
+		instrument string
+		want       string
+	}{
+		{"BTC-USD-SWAP", "BTC"},
+		{"BTC-USDT-SWAP", "USDT"},
+		{"ETH-USDC-SWAP", "USDC"},
+		{"BTC-USDT", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := swapCurrency(tt.instrument); got != tt.want {
+			t.Errorf("swapCurrency(%q) = %q, want %q", tt.instrument, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// The purpose of this package is to configure the logging used by all of OKConnect.
+//
+// Diagnostics go to stderr via logrus so that stdout only contains the results of a command, such as the JSON
+// produced by compare.  Secrets such as the Bookwerx API key, the OKEx secret key and passphrase, and OK-ACCESS-SIGN
+// are redacted from every log entry no matter how they got there.
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// Secrets shorter than this are too likely to match something innocent.
+const minSecretLen = 4
+
+// Any field whose name contains one of these is redacted regardless of its value.
+var secretFieldNames = []string{"apikey", "api_key", "secret", "passphrase", "sign", "password"}
+
+// Any text that looks like these is redacted, such as a url with an apikey in its query string.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(apikey=)[^&\s"]+`),
+	regexp.MustCompile(`(?i)(OK-ACCESS-SIGN["']?\s*[:=]\s*["']?)[^\s"',]+`),
+	regexp.MustCompile(`(?i)(OK-ACCESS-PASSPHRASE["']?\s*[:=]\s*["']?)[^\s"',]+`),
+}
+
+var (
+	secrets   = make(map[string]bool)
+	secretsMu sync.RWMutex
+)
+
+// Send logs to stderr at the given level, using either the "text" or "json" format.
+func Setup(level string, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var formatter log.Formatter
+	switch format {
+	case "text", "":
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	case "json":
+		formatter = &log.JSONFormatter{}
+	default:
+		return errors.New(fmt.Sprintf("logging: unknown log format %s, use text or json", format))
+	}
+
+	log.SetOutput(os.Stderr)
+	log.SetLevel(lvl)
+	log.SetFormatter(&redactingFormatter{formatter})
+	return nil
+}
+
+// Never log the given secret.
+func AddSecret(secret string) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	secrets[secret] = true
+	secretsMu.Unlock()
+}
+
+// Remove all known secrets from the given text.
+func Redact(s string) string {
+	return string(redact([]byte(s)))
+}
+
+func redact(b []byte) []byte {
+	secretsMu.RLock()
+	for secret := range secrets {
+		b = bytes.ReplaceAll(b, []byte(secret), []byte(redacted))
+	}
+	secretsMu.RUnlock()
+
+	for _, p := range secretPatterns {
+		b = p.ReplaceAll(b, []byte("${1}"+redacted))
+	}
+	return b
+}
+
+type redactingFormatter struct {
+	inner log.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	data := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if isSecretFieldName(k) {
+			v = redacted
+		}
+		data[k] = v
+	}
+
+	e := *entry
+	e.Data = data
+
+	b, err := f.inner.Format(&e)
+	if err != nil {
+		return nil, err
+	}
+	return redact(b), nil
+}
+
+func isSecretFieldName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFieldNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
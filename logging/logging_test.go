@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedact(t *testing.T) {
+	AddSecret("s3cr3t-key")
+	AddSecret("abc") // too short to be redacted safely
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"the key is s3cr3t-key, twice s3cr3t-key", "the key is [REDACTED], twice [REDACTED]"},
+		{"GET http://bookwerx/sql?query=x&apikey=abcdef123&x=1", "GET http://bookwerx/sql?query=x&apikey=[REDACTED]&x=1"},
+		{"APIKEY=abcdef123 and more", "APIKEY=[REDACTED] and more"},
+		{`{"OK-ACCESS-SIGN": "c2lnbmF0dXJl", "other": 1}`, `{"OK-ACCESS-SIGN": "[REDACTED]", "other": 1}`},
+		{"OK-ACCESS-PASSPHRASE=hunter22", "OK-ACCESS-PASSPHRASE=[REDACTED]"},
+		{"abc is not a secret", "abc is not a secret"},
+		{"nothing to see here", "nothing to see here"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatter(t *testing.T) {
+	AddSecret("my-passphrase")
+
+	for _, format := range []string{"text", "json"} {
+		if err := Setup("info", format); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+
+		log.WithFields(log.Fields{"api_secret_key": "xyzzy-1", "Passphrase": "xyzzy-2", "currency": "BTC"}).
+			Info("url=http://bookwerx/accounts?apikey=xyzzy-3 typed my-passphrase")
+
+		out := buf.String()
+		for _, secret := range []string{"xyzzy-1", "xyzzy-2", "xyzzy-3", "my-passphrase"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s: the log has %s: %s", format, secret, out)
+			}
+		}
+		if !strings.Contains(out, "BTC") {
+			t.Errorf("%s: the log lost an innocent field: %s", format, out)
+		}
+	}
+
+	if err := Setup("info", "xml"); err == nil {
+		t.Errorf("Setup(xml) = nil, want an error")
+	}
+}
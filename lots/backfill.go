@@ -0,0 +1,217 @@
+package lots
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/gojektech/heimdall/httpclient"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// The tag that sync ledger puts in the notes of every spot trade that it books.
+var tradeTag = regexp.MustCompile(`\[okex order_id=[^\]]* trade_id=[^\]]*\]`)
+
+// Rebuild the disposals that are missing from the lots because they were sold before the disposals were recorded.
+// Bookwerx still has the transaction of every such sale, so look at each of them again.  Whatever was sold for the
+// quote currency, and is not yet a disposal, becomes one.
+//
+// The lots that were consumed are long gone, so each backfilled disposal is the whole sale, acquired at VARIOUS
+// times, with the cost figured from the realised gain that was booked.
+//
+// Example:
+// okconnect lots -backfill-disposals -config okconnect.yaml
+func Backfill(cfg *config.Config) {
+	b, err := Open(cfg.LotsConfig)
+	if err != nil {
+		return
+	}
+	if b == nil {
+		log.Error("The config does not say which lots method to use, so there are no disposals to backfill.")
+		return
+	}
+
+	// 1. Which trades have been applied to the lots but have no disposal?
+	missing := b.missingDisposals()
+	if len(missing) == 0 {
+		log.Info("No disposals are missing.")
+		_ = b.backfill(nil)
+		return
+	}
+
+	// 2. Find every spot trade in Bookwerx, and what each of them did.
+	clientB := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond)
+	dists, err := bookwerx.FindDistributionsByNotes(clientB, "[okex order_id=", *cfg)
+	if err != nil {
+		return
+	}
+
+	trades := make(map[uint32][]bookwerx.AccountDistribution)
+	txids := make([]uint32, 0)
+	for _, d := range dists {
+		if _, ok := trades[d.TransactionID]; !ok {
+			txids = append(txids, d.TransactionID)
+		}
+		trades[d.TransactionID] = append(trades[d.TransactionID], d)
+	}
+
+	// 3. Those that were missing, and sold something for the quote currency, are the missing disposals.
+	accounts := &accountFinder{client: clientB, cfg: cfg, ids: make(map[string]uint32)}
+	disposals := make([]Disposal, 0)
+	for _, txid := range txids {
+		trade := trades[txid]
+		tag := tradeTag.FindString(trade[0].Notes)
+		if !missing[tag] {
+			continue
+		}
+
+		d, ok, err := accounts.disposal(tag, b.Quote(), trade)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"tag": tag, "transaction_id": txid}).Error("Cannot figure the disposal of this trade.")
+			return
+		}
+		if ok {
+			disposals = append(disposals, d)
+		}
+	}
+
+	// 4. Remember them.
+	err = b.backfill(disposals)
+	if err != nil {
+		return
+	}
+	log.WithField("disposals", len(disposals)).Info("Backfilled.")
+}
+
+// The tags of the spot trades that have been applied to the lots but have no disposal.
+func (b *Book) missingDisposals() map[string]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	retVal := make(map[string]bool)
+	for tag := range b.state.Applied {
+		if tradeTag.MatchString(tag) {
+			retVal[tag] = true
+		}
+	}
+	for _, d := range b.state.Disposals {
+		delete(retVal, d.Tag)
+	}
+	return retVal
+}
+
+// Add the backfilled disposals, keep them all in the order that they were sold, and save the lots.  Now they're all
+// there.
+func (b *Book) backfill(disposals []Disposal) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state.Disposals = append(b.state.Disposals, disposals...)
+	sort.SliceStable(b.state.Disposals, func(i, j int) bool { return b.state.Disposals[i].Sold < b.state.Disposals[j].Sold })
+	b.state.Incomplete = false
+
+	err := b.save()
+	if err != nil {
+		log.WithError(err).WithField("lots", b.path).Error("Cannot write the lots file.")
+	}
+	return err
+}
+
+// Find, and remember, the Bookwerx accounts of the spot trades.
+type accountFinder struct {
+	client *httpclient.Client
+	cfg    *config.Config
+	ids    map[string]uint32 // by category:currency
+}
+
+func (f *accountFinder) find(category uint32, currency string) (uint32, error) {
+	key := fmt.Sprintf("%d:%s", category, currency)
+	if id, ok := f.ids[key]; ok {
+		return id, nil
+	}
+	id, err := bookwerx.FindCategoryAccount(f.client, category, currency, *f.cfg)
+	if err != nil {
+		return 0, err
+	}
+	f.ids[key] = id
+	return id, nil
+}
+
+// Figure the disposal of a spot trade from its distributions, as bookTrade booked them.  Only the spot and trading
+// accounts need be known.  For the currency that we gave, the trading account got the size and the spot account paid
+// the size and any fee.  For the quote currency that we received, the spot account got the size and paid any fee,
+// and the spot and trading accounts together got the gain.  Return false if nothing was sold for the quote currency.
+func (f *accountFinder) disposal(tag string, quote string, trade []bookwerx.AccountDistribution) (Disposal, bool, error) {
+
+	// 1. Which currency did we give?
+	given := ""
+	for _, d := range trade {
+		if d.Currency != quote {
+			given = d.Currency
+		}
+	}
+	if given == "" {
+		return Disposal{}, false, nil
+	}
+
+	spotQ, err := f.find(f.cfg.BookwerxConfig.CatSpotAvailable, quote)
+	if err != nil {
+		return Disposal{}, false, err
+	}
+	tradingQ, err := f.find(f.cfg.BookwerxConfig.CatTrading, quote)
+	if err != nil {
+		return Disposal{}, false, err
+	}
+	spotG, err := f.find(f.cfg.BookwerxConfig.CatSpotAvailable, given)
+	if err != nil {
+		return Disposal{}, false, err
+	}
+	tradingG, err := f.find(f.cfg.BookwerxConfig.CatTrading, given)
+	if err != nil {
+		return Disposal{}, false, err
+	}
+
+	// 2. Add up the legs.
+	var proceeds, feeQ, gain, quantity, spentG decimal.Decimal
+	for _, d := range trade {
+		amount := bookwerx.DFP{Amount: d.Amount, Exp: d.AmountExp}.Decimal()
+		switch {
+		case d.AccountID == spotQ && amount.IsPositive():
+			proceeds = proceeds.Add(amount)
+			gain = gain.Add(amount)
+		case d.AccountID == spotQ:
+			feeQ = feeQ.Sub(amount)
+			gain = gain.Add(amount)
+		case d.AccountID == tradingQ:
+			gain = gain.Add(amount)
+		case d.AccountID == tradingG:
+			quantity = quantity.Add(amount)
+		case d.AccountID == spotG:
+			spentG = spentG.Sub(amount)
+		}
+	}
+	gain = gain.Add(feeQ)
+
+	// 3. Did we sell it for the quote currency?
+	if !proceeds.IsPositive() || !quantity.IsPositive() {
+		return Disposal{}, false, nil
+	}
+
+	fee, feeCurrency := feeQ, quote
+	if fee.IsZero() {
+		fee, feeCurrency = spentG.Sub(quantity), given
+	}
+	if fee.IsZero() {
+		feeCurrency = ""
+	}
+	if fee.IsNegative() {
+		return Disposal{}, false, errors.New("lots:backfill.go:disposal: the fee of " + tag + " is negative")
+	}
+
+	return Disposal{tag, given, quantity, "", trade[0].Time, proceeds, proceeds.Sub(gain), fee, feeCurrency}, true, nil
+}
@@ -0,0 +1,89 @@
+package lots
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+func TestBackfillDisposal(t *testing.T) {
+	cfg := &config.Config{BookwerxConfig: config.BookwerxConfig{CatSpotAvailable: 1, CatTrading: 2}}
+	f := &accountFinder{cfg: cfg, ids: map[string]uint32{
+		"1:USDT": 10, "2:USDT": 20, "1:BTC": 11, "2:BTC": 21,
+	}}
+	const fee, gain = 30, 40 // accounts
+
+	dist := func(accountID uint32, currency string, amount string) bookwerx.AccountDistribution {
+		d, _ := bookwerx.ToDFP(decimal.RequireFromString(amount))
+		return bookwerx.AccountDistribution{AccountID: accountID, Currency: currency, Amount: d.Amount, AmountExp: d.Exp,
+			Time: "2020-10-10T00:00:00.000Z", Notes: "Spot trade BTC-USDT at 10000 [okex order_id=1 trade_id=2]"}
+	}
+
+	// Sold 0.5 BTC for 5000 USDT, paid a 5 USDT fee, and gained 1000 USDT.
+	sale := []bookwerx.AccountDistribution{
+		dist(11, "BTC", "-0.5"), dist(21, "BTC", "0.5"),
+		dist(10, "USDT", "5000"), dist(20, "USDT", "-5000"),
+		dist(fee, "USDT", "5"), dist(10, "USDT", "-5"),
+		dist(20, "USDT", "1000"), dist(gain, "USDT", "-1000"),
+	}
+	d, ok, err := f.disposal("[okex order_id=1 trade_id=2]", "USDT", sale)
+	if err != nil || !ok {
+		t.Fatalf("disposal = %v, %v", ok, err)
+	}
+	want := Disposal{"[okex order_id=1 trade_id=2]", "BTC", decimal.RequireFromString("0.5"), "", "2020-10-10T00:00:00.000Z",
+		decimal.New(5000, 0), decimal.New(4000, 0), decimal.New(5, 0), "USDT"}
+	if d.Tag != want.Tag || d.Currency != want.Currency || !d.Quantity.Equal(want.Quantity) || d.Acquired != want.Acquired ||
+		d.Sold != want.Sold || !d.Proceeds.Equal(want.Proceeds) || !d.Cost.Equal(want.Cost) || !d.Fee.Equal(want.Fee) ||
+		d.FeeCurrency != want.FeeCurrency {
+		t.Errorf("disposal = %+v, want %+v", d, want)
+	}
+
+	// Bought 0.5 BTC for 5000 USDT, and paid a 0.001 BTC fee.  That's not a sale.
+	buy := []bookwerx.AccountDistribution{
+		dist(11, "BTC", "0.5"), dist(21, "BTC", "-0.5"),
+		dist(fee, "BTC", "0.001"), dist(11, "BTC", "-0.001"),
+		dist(10, "USDT", "-5000"), dist(20, "USDT", "5000"),
+	}
+	_, ok, err = f.disposal("[okex order_id=3 trade_id=4]", "USDT", buy)
+	if err != nil || ok {
+		t.Errorf("a buy is a disposal: %v, %v", ok, err)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "okconnect.lots")
+	old := `{"Method":"fifo","Quote":"USDT","Lots":{},"Applied":{"[okex order_id=1 trade_id=2]":true,"[okex ledger_id=3]":true}}`
+	err := ioutil.WriteFile(path, []byte(old), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(config.LotsConfig{Method: "fifo", State: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Incomplete() {
+		t.Error("a lots file from before the disposals is not incomplete")
+	}
+	missing := b.missingDisposals()
+	if len(missing) != 1 || !missing["[okex order_id=1 trade_id=2]"] {
+		t.Errorf("missingDisposals = %v", missing)
+	}
+
+	// Once backfilled, it's complete, even after it's opened again.
+	err = b.backfill(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = Open(config.LotsConfig{Method: "fifo", State: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Incomplete() {
+		t.Error("the lots are still incomplete after the backfill")
+	}
+}
@@ -0,0 +1,390 @@
+// The purpose of this package is to figure the cost basis of whatever we sell, and thus the realised gain or loss.
+//
+// Bookwerx knows how many coins we have, but not what we paid for them.  So we follow the acquisition lots of each
+// currency, from its deposits and buys, and consume them when we sell, in the order that the configured method says:
+// fifo, lifo, or average.  The costs and the gains are figured in a single quote currency, such as USDT.
+//
+// The lots are kept in a small JSON file, along with the tags of the transactions that have already been applied to
+// them, so that the same fill is never applied twice.
+package lots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/atomicfile"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The state file to use if the config does not say otherwise.
+const DefaultStatePath = "okconnect.lots"
+
+// The quote currency to use if the config does not say otherwise.
+const DefaultQuote = "USDT"
+
+// The methods of choosing which lots to consume.
+const (
+	MethodFIFO    = "fifo"    // The oldest lots first.
+	MethodLIFO    = "lifo"    // The newest lots first.
+	MethodAverage = "average" // There is only a single lot per currency, at the average cost.
+)
+
+// Round the costs and the gains to this many decimal places.
+const places = 8
+
+// Some quantity of a currency that we acquired at the same time, for the same cost.
+type Lot struct {
+	Acquired string          // when, as OKEx says
+	Source   string          // the tag of the transaction that acquired it
+	Quantity decimal.Decimal // what's left of it
+	Cost     decimal.Decimal // of what's left of it, in the quote currency
+}
+
+// One side of a trade.
+type Flow struct {
+	Currency string
+	Quantity decimal.Decimal // before the fee
+	Fee      decimal.Decimal // that we paid in this currency
+}
+
+// The sale of some quantity, all from a single lot, for the quote currency.
+type Disposal struct {
+	Tag         string // of the transaction that sold it
+	Currency    string
+	Quantity    decimal.Decimal
+	Acquired    string // when, as OKEx says, or "" if we never saw it acquired
+	Sold        string // when, as OKEx says
+	Proceeds    decimal.Decimal
+	Cost        decimal.Decimal
+	Fee         decimal.Decimal // this disposal's share of the fee
+	FeeCurrency string          `json:",omitempty"`
+}
+
+// What a single transaction does to the lots.  Nothing happens until it's committed.
+type Change struct {
+	tag  string
+	lots map[string][]Lot // the new lots of each currency that changed
+
+	Realised  bool            // Did we sell something for the quote currency?
+	Gain      decimal.Decimal // If so, the realised gain, or loss if negative, in the quote currency
+	Disposals []Disposal      // and what we sold, one per lot consumed
+}
+
+type state struct {
+	Method  string
+	Quote   string
+	Lots    map[string][]Lot // by currency, oldest first
+	Applied map[string]bool  // the tags of the transactions that have been applied
+
+	Disposals []Disposal // oldest first
+
+	// Were some sales applied before the disposals were recorded?  If so then Disposals is missing them until they're
+	// backfilled.
+	Incomplete bool `json:",omitempty"`
+}
+
+// A Book holds the open lots of every currency.
+type Book struct {
+	path  string
+	state state
+	mu    sync.Mutex
+}
+
+// Open the lots file that the config says to use.  If the config does not say which method to use then we don't follow
+// any lots, so return nil.  If the file does not exist yet then that's ok, we start without any lots.
+func Open(cfg config.LotsConfig) (*Book, error) {
+	if cfg.Method == "" {
+		return nil, nil
+	}
+
+	method := strings.ToLower(cfg.Method)
+	if method != MethodFIFO && method != MethodLIFO && method != MethodAverage {
+		log.WithField("method", cfg.Method).Error("The lots method must be fifo, lifo, or average.")
+		return nil, errors.New("lots: unknown method " + cfg.Method)
+	}
+
+	quote := strings.ToUpper(cfg.Quote)
+	if quote == "" {
+		quote = DefaultQuote
+	}
+
+	path := cfg.State
+	if path == "" {
+		path = DefaultStatePath
+	}
+
+	b := &Book{path: path, state: state{Method: method, Quote: quote, Lots: make(map[string][]Lot), Applied: make(map[string]bool)}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("lots", path).Error("Cannot read the lots file.")
+		return nil, err
+	}
+
+	s := state{}
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		log.WithError(err).WithField("lots", path).Error("Cannot parse the lots file.")
+		return nil, err
+	}
+
+	// Switching horses in midstream would make a mess of the lots that we already have.
+	if s.Method != method || s.Quote != quote {
+		log.WithFields(log.Fields{"lots": path, "method": s.Method, "quote": s.Quote}).Error("The lots file was made using a different method or quote currency.  Use a new lots file instead.")
+		return nil, errors.New("lots: the lots file does not match the config")
+	}
+	if s.Lots == nil {
+		s.Lots = make(map[string][]Lot)
+	}
+	if s.Applied == nil {
+		s.Applied = make(map[string]bool)
+	}
+
+	// A lots file from before the disposals were recorded doesn't even mention them.
+	keys := make(map[string]json.RawMessage)
+	_ = json.Unmarshal(data, &keys)
+	if _, ok := keys["Disposals"]; !ok && len(s.Applied) > 0 {
+		s.Incomplete = true
+	}
+
+	b.state = s
+	return b, nil
+}
+
+// The currency in which the costs and the gains are figured.
+func (b *Book) Quote() string {
+	return b.state.Quote
+}
+
+// The open lots of every currency.
+func (b *Book) Lots() map[string][]Lot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	retVal := make(map[string][]Lot)
+	for currency, lots := range b.state.Lots {
+		retVal[currency] = append([]Lot(nil), lots...)
+	}
+	return retVal
+}
+
+// Everything that we have sold for the quote currency.
+func (b *Book) Disposals() []Disposal {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Disposal(nil), b.state.Disposals...)
+}
+
+// Are some of the disposals missing, because they were sold before the disposals were recorded?  See Backfill.
+func (b *Book) Incomplete() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.Incomplete
+}
+
+// A deposit acquires a lot.  OKEx can't tell us what it cost so it starts with a zero basis.  Return nil if there's
+// nothing to do.
+func (b *Book) Deposit(tag string, timestamp string, currency string, quantity decimal.Decimal) *Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state.Applied[tag] || currency == b.state.Quote || !quantity.IsPositive() {
+		return nil
+	}
+
+	log.WithFields(log.Fields{"tag": tag, "currency": currency}).Info("A deposit's cost is unknown.  Its lot starts with a zero basis.")
+	c := &Change{tag: tag, lots: make(map[string][]Lot)}
+	c.lots[currency] = acquire(b.state.Lots[currency], Lot{timestamp, tag, quantity, decimal.Zero}, b.state.Method)
+	return c
+}
+
+// A withdrawal consumes lots, fee and all, but it's not a sale so it neither realises a gain nor records a disposal.
+// Return nil if there's nothing to do.
+func (b *Book) Withdraw(tag string, currency string, quantity decimal.Decimal) *Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state.Applied[tag] || currency == b.state.Quote || !quantity.IsPositive() {
+		return nil
+	}
+
+	c := &Change{tag: tag, lots: make(map[string][]Lot)}
+	var short decimal.Decimal
+	c.lots[currency], _, short = consume(b.state.Lots[currency], quantity, b.state.Method)
+	if short.IsPositive() {
+		log.WithFields(log.Fields{"tag": tag, "currency": currency, "short": short.String()}).Warn("There are not enough lots to consume.")
+	}
+	return c
+}
+
+// A trade consumes lots of the given currency and acquires a lot of the received currency.  Return nil if there's
+// nothing to do.
+//
+// If we received the quote currency then the difference between what we received and the cost of the lots consumed is
+// the realised gain.  If we gave the quote currency then that's the cost of the new lot.  If neither, then the new lot
+// merely carries over the cost of the lots consumed.
+//
+// The fees are booked as an expense, so they're left out of the gain.  The fee paid in the received currency
+// reduces the new lot and takes its share of the cost with it.
+func (b *Book) Trade(tag string, timestamp string, received Flow, given Flow) (*Change, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state.Applied[tag] {
+		return nil, nil
+	}
+	if !received.Quantity.IsPositive() {
+		return nil, errors.New(fmt.Sprintf("lots:lots.go:Trade: %s did not receive anything", tag))
+	}
+
+	quote := b.state.Quote
+	c := &Change{tag: tag, lots: make(map[string][]Lot)}
+	net := received.Quantity.Sub(received.Fee)
+
+	// 1. Consume the lots of whatever we gave.
+	cost := given.Quantity
+	var taken []Lot
+	if given.Currency != quote {
+		var short decimal.Decimal
+		c.lots[given.Currency], taken, short = consume(b.state.Lots[given.Currency], given.Quantity.Add(given.Fee), b.state.Method)
+		if short.IsPositive() {
+			log.WithFields(log.Fields{"tag": tag, "currency": given.Currency, "short": short.String()}).Warn("There are not enough lots to consume.  The rest has a zero basis.")
+			taken = append(taken, Lot{Quantity: short, Cost: decimal.Zero})
+		}
+		cost = decimal.Zero
+		for _, lot := range taken {
+			cost = cost.Add(lot.Cost)
+		}
+	} else {
+		cost = cost.Mul(net).Div(received.Quantity).Round(places)
+	}
+
+	// 2. Acquire a lot of whatever we received, or else realise the gain.
+	if received.Currency == quote {
+		c.Realised = true
+		c.Gain = received.Quantity.Sub(cost).Round(places)
+		c.Disposals = disposals(tag, timestamp, given, received, taken)
+	} else if net.IsPositive() {
+		c.lots[received.Currency] = acquire(b.state.Lots[received.Currency], Lot{timestamp, tag, net, cost}, b.state.Method)
+	}
+
+	return c, nil
+}
+
+// Apply the change and save all the lots.
+func (b *Book) Commit(c *Change) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for currency, lots := range c.lots {
+		if len(lots) == 0 {
+			delete(b.state.Lots, currency)
+		} else {
+			b.state.Lots[currency] = lots
+		}
+	}
+	b.state.Applied[c.tag] = true
+	b.state.Disposals = append(b.state.Disposals, c.Disposals...)
+
+	err := b.save()
+	if err != nil {
+		log.WithError(err).WithField("lots", b.path).Error("Cannot write the lots file.")
+	}
+	return err
+}
+
+// Return a new slice of lots with the given lot added.
+func acquire(lots []Lot, lot Lot, method string) []Lot {
+	retVal := append([]Lot(nil), lots...)
+
+	if method == MethodAverage && len(retVal) > 0 {
+		retVal[0].Quantity = retVal[0].Quantity.Add(lot.Quantity)
+		retVal[0].Cost = retVal[0].Cost.Add(lot.Cost)
+		retVal[0].Source = "average"
+		return retVal
+	}
+
+	return append(retVal, lot)
+}
+
+// Split the proceeds, and the fee, of a sale among the lots taken, in proportion to their quantities.  The last one
+// gets whatever is left over from the rounding.
+func disposals(tag string, timestamp string, given Flow, received Flow, taken []Lot) []Disposal {
+	fee, feeCurrency := received.Fee, received.Currency
+	if fee.IsZero() {
+		fee, feeCurrency = given.Fee, given.Currency
+	}
+	if fee.IsZero() {
+		feeCurrency = ""
+	}
+
+	total := decimal.Zero
+	for _, lot := range taken {
+		total = total.Add(lot.Quantity)
+	}
+
+	retVal := make([]Disposal, 0, len(taken))
+	proceedsLeft, feeLeft := received.Quantity, fee
+	for i, lot := range taken {
+		proceeds, share := proceedsLeft, feeLeft
+		if i < len(taken)-1 {
+			proceeds = received.Quantity.Mul(lot.Quantity).Div(total).Round(places)
+			share = fee.Mul(lot.Quantity).Div(total).Round(places)
+		}
+		proceedsLeft = proceedsLeft.Sub(proceeds)
+		feeLeft = feeLeft.Sub(share)
+
+		retVal = append(retVal, Disposal{tag, given.Currency, lot.Quantity, lot.Acquired, timestamp, proceeds, lot.Cost, share, feeCurrency})
+	}
+	return retVal
+}
+
+// Return a new slice of lots with the given quantity taken out of them, what was taken, and however much was short.
+func consume(lots []Lot, quantity decimal.Decimal, method string) ([]Lot, []Lot, decimal.Decimal) {
+	retVal := append([]Lot(nil), lots...)
+	taken := make([]Lot, 0)
+
+	for quantity.IsPositive() && len(retVal) > 0 {
+		i := 0
+		if method == MethodLIFO {
+			i = len(retVal) - 1
+		}
+		lot := &retVal[i]
+
+		// Take the whole lot.
+		if !quantity.LessThan(lot.Quantity) {
+			quantity = quantity.Sub(lot.Quantity)
+			taken = append(taken, *lot)
+			retVal = append(retVal[:i], retVal[i+1:]...)
+			continue
+		}
+
+		// Take part of it, and its share of the cost.
+		share := lot.Cost.Mul(quantity).Div(lot.Quantity).Round(places)
+		taken = append(taken, Lot{lot.Acquired, lot.Source, quantity, share})
+		lot.Quantity = lot.Quantity.Sub(quantity)
+		lot.Cost = lot.Cost.Sub(share)
+		quantity = decimal.Zero
+	}
+
+	return retVal, taken, quantity
+}
+
+// Write the file, replacing the existing file only after the new one is safely on the disk.
+func (b *Book) save() error {
+	data, err := json.MarshalIndent(b.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(b.path, data, 0600)
+}
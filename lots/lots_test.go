@@ -0,0 +1,136 @@
+package lots
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+func d(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// Buy 1 BTC for 100 USDT and then 1 BTC for 300 USDT.  Then sell 1.5 BTC for 600 USDT, with a fee of 6 USDT.
+func trade(t *testing.T, method string) (*Book, *Change) {
+	path := filepath.Join(t.TempDir(), "okconnect.lots")
+	b, err := Open(config.LotsConfig{Method: method, State: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buys := []struct {
+		tag, timestamp, cost string
+	}{
+		{"buy1", "2020-01-01T00:00:00.000Z", "100"},
+		{"buy2", "2020-06-01T00:00:00.000Z", "300"},
+	}
+	for _, buy := range buys {
+		c, err := b.Trade(buy.tag, buy.timestamp, Flow{"BTC", d("1"), decimal.Zero}, Flow{"USDT", d(buy.cost), decimal.Zero})
+		if err != nil || c.Realised {
+			t.Fatalf("%s: %s = %+v, %v", method, buy.tag, c, err)
+		}
+		if err := b.Commit(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := b.Trade("sell", "2021-03-01T00:00:00.000Z", Flow{"USDT", d("600"), d("6")}, Flow{"BTC", d("1.5"), decimal.Zero})
+	if err != nil || !c.Realised {
+		t.Fatalf("%s: sell = %+v, %v", method, c, err)
+	}
+	if err := b.Commit(c); err != nil {
+		t.Fatal(err)
+	}
+	return b, c
+}
+
+func TestMethods(t *testing.T) {
+	type disposal struct {
+		quantity, acquired, proceeds, cost, fee string
+	}
+	tests := []struct {
+		method    string
+		gain      string
+		disposals []disposal
+		left      string // the cost of the 0.5 BTC left
+	}{
+		{MethodFIFO, "350", []disposal{
+			{"1", "2020-01-01T00:00:00.000Z", "400", "100", "4"},
+			{"0.5", "2020-06-01T00:00:00.000Z", "200", "150", "2"},
+		}, "150"},
+		{MethodLIFO, "250", []disposal{
+			{"1", "2020-06-01T00:00:00.000Z", "400", "300", "4"},
+			{"0.5", "2020-01-01T00:00:00.000Z", "200", "50", "2"},
+		}, "50"},
+		{MethodAverage, "300", []disposal{
+			{"1.5", "2020-01-01T00:00:00.000Z", "600", "300", "6"},
+		}, "100"},
+	}
+
+	for _, tt := range tests {
+		b, c := trade(t, tt.method)
+		if !c.Gain.Equal(d(tt.gain)) {
+			t.Errorf("%s: gain = %s, want %s", tt.method, c.Gain, tt.gain)
+		}
+
+		got := b.Disposals()
+		if len(got) != len(tt.disposals) {
+			t.Fatalf("%s: disposals = %+v", tt.method, got)
+		}
+		for i, w := range tt.disposals {
+			g := got[i]
+			if g.Tag != "sell" || g.Currency != "BTC" || g.Sold != "2021-03-01T00:00:00.000Z" || g.FeeCurrency != "USDT" ||
+				!g.Quantity.Equal(d(w.quantity)) || g.Acquired != w.acquired || !g.Proceeds.Equal(d(w.proceeds)) ||
+				!g.Cost.Equal(d(w.cost)) || !g.Fee.Equal(d(w.fee)) {
+				t.Errorf("%s: disposal %d = %+v, want %+v", tt.method, i, g, w)
+			}
+		}
+
+		lots := b.Lots()["BTC"]
+		if len(lots) != 1 || !lots[0].Quantity.Equal(d("0.5")) || !lots[0].Cost.Equal(d(tt.left)) {
+			t.Errorf("%s: lots left = %+v, want 0.5 BTC costing %s", tt.method, lots, tt.left)
+		}
+	}
+}
+
+func TestTradeTwice(t *testing.T) {
+	b, _ := trade(t, MethodFIFO)
+	c, err := b.Trade("sell", "2021-03-01T00:00:00.000Z", Flow{"USDT", d("600"), d("6")}, Flow{"BTC", d("1.5"), decimal.Zero})
+	if c != nil || err != nil {
+		t.Errorf("a trade applied twice = %+v, %v, want nothing", c, err)
+	}
+}
+
+func TestShort(t *testing.T) {
+	b, err := Open(config.LotsConfig{Method: MethodFIFO, State: filepath.Join(t.TempDir(), "okconnect.lots")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Whatever we never saw acquired has a zero basis.
+	c, err := b.Trade("sell", "2021-03-01T00:00:00.000Z", Flow{"USDT", d("100"), decimal.Zero}, Flow{"BTC", d("0.01"), decimal.Zero})
+	if err != nil || !c.Gain.Equal(d("100")) || len(c.Disposals) != 1 || c.Disposals[0].Acquired != "" {
+		t.Errorf("short sale = %+v, %v", c, err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "okconnect.lots")
+	b, err := Open(config.LotsConfig{Method: MethodLIFO, State: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(b.Deposit("deposit", "2020-01-01T00:00:00.000Z", "BTC", d("2"))); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(config.LotsConfig{Method: MethodLIFO, State: path})
+	if err != nil || len(b.Lots()["BTC"]) != 1 {
+		t.Fatalf("reopened = %+v, %v", b, err)
+	}
+	if _, err := Open(config.LotsConfig{Method: MethodFIFO, State: path}); err == nil {
+		t.Errorf("reopened with a different method = nil, want an error")
+	}
+}
@@ -0,0 +1,81 @@
+package lots
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// The open lots of a single currency.
+type Holding struct {
+	Currency string
+	Lots     []Lot
+	Quantity decimal.Decimal
+	Basis    decimal.Decimal // the total cost of the lots, in the quote currency
+}
+
+// Print the open lots of every currency, and their basis, in the given format: table or json.
+//
+// Example:
+// okconnect lots -config okconnect.yaml
+func Show(cfg *config.Config, format string) {
+	b, err := Open(cfg.LotsConfig)
+	if err != nil {
+		return
+	}
+	if b == nil {
+		log.Error("The config does not say which lots method to use, so there are no lots.")
+		return
+	}
+
+	holdings := make([]Holding, 0)
+	for currency, lots := range b.Lots() {
+		h := Holding{Currency: currency, Lots: lots}
+		for _, lot := range lots {
+			h.Quantity = h.Quantity.Add(lot.Quantity)
+			h.Basis = h.Basis.Add(lot.Cost)
+		}
+		holdings = append(holdings, h)
+	}
+	sort.Slice(holdings, func(i, j int) bool { return holdings[i].Currency < holdings[j].Currency })
+
+	switch format {
+	case "table":
+		fmt.Printf("Open lots, %s, with the basis in %s\n\n", b.state.Method, b.Quote())
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Currency\tAcquired\tQuantity\tCost\tUnit cost\tSource")
+		for _, h := range holdings {
+			for _, lot := range h.Lots {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", h.Currency, lot.Acquired, lot.Quantity.String(), lot.Cost.String(), unitCost(lot.Cost, lot.Quantity), lot.Source)
+			}
+			fmt.Fprintf(w, "Total %s\t\t%s\t%s\t%s\t\n", h.Currency, h.Quantity.String(), h.Basis.String(), unitCost(h.Basis, h.Quantity))
+		}
+		err = w.Flush()
+
+	case "json":
+		var data []byte
+		data, err = json.Marshal(holdings)
+		if err == nil {
+			fmt.Println(string(data))
+		}
+
+	default:
+		err = errors.New("unknown format")
+	}
+	if err != nil {
+		log.WithError(err).WithField("format", format).Error("Cannot print the lots.")
+	}
+}
+
+func unitCost(cost decimal.Decimal, quantity decimal.Decimal) string {
+	if quantity.IsZero() {
+		return ""
+	}
+	return cost.Div(quantity).Round(places).String()
+}
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/bostontrader/okconnect/alert"
 	"github.com/bostontrader/okconnect/compare"
 	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/credstore"
+	"github.com/bostontrader/okconnect/export"
+	"github.com/bostontrader/okconnect/ledger"
+	"github.com/bostontrader/okconnect/logging"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/bostontrader/okconnect/margin"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/bostontrader/okconnect/report"
+	"github.com/bostontrader/okconnect/snapshot"
+	"github.com/bostontrader/okconnect/tui"
+	"github.com/bostontrader/okconnect/valuation"
+	"github.com/bostontrader/okconnect/watch"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 func printUsage() {
@@ -16,15 +32,129 @@ func printUsage() {
 	fmt.Println("    okconnect <command> [arguments]")
 	fmt.Println("")
 	fmt.Println("The commands are:")
-	fmt.Println("    compare, transfer")
+	fmt.Println("    compare, credentials, export, lots, margin, report, snapshot, sync, transfer, tui, valuation, watch")
 	fmt.Println("")
 	fmt.Println("Use \"okconnect <command>\" without any arguments to see more info about that command.")
 }
 
-func readConfigFile(filename *string) (cfg *config.Config, err error) {
-	data, err := ioutil.ReadFile(*filename)
+func printCredentialsUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect credentials <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    import, rotate, show-key-id")
+	fmt.Println("")
+	fmt.Println("The passphrase is read from $" + credstore.EnvPassphrase + ", or from the file descriptor in $" + credstore.EnvPassphraseFD + ", or else from the terminal.")
+	fmt.Println("A new passphrase is likewise read from $" + credstore.EnvNewPassphrase + " or $" + credstore.EnvNewPassphraseFD + ".")
+}
+
+func printExportUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect export <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    tax")
+}
+
+func printMarginUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect margin <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    borrow, repay, resolve")
+}
+
+func printReportUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect report <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    balance, pnl")
+}
+
+func printSnapshotUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect snapshot <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    list, show, diff")
+}
+
+func printSyncUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("")
+	fmt.Println("    okconnect sync <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("The commands are:")
+	fmt.Println("    ledger, swap-ledger")
+}
+
+// Every command accepts these flags in order to control the logging.
+type logFlags struct {
+	verbose *bool
+	level   *string
+	format  *string
+}
+
+func addLogFlags(cmd *flag.FlagSet) logFlags {
+	return logFlags{
+		verbose: cmd.Bool("v", false, "Verbose. The same as -log-level debug"),
+		level:   cmd.String("log-level", "info", "Log at this level or above: trace, debug, info, warn, error"),
+		format:  cmd.String("log-format", "text", "Log using this format: text or json"),
+	}
+}
+
+// Parse the args for the given command and then configure the logging accordingly.
+func parseArgs(cmd *flag.FlagSet, lf logFlags, args []string) error {
+	err := cmd.Parse(args)
+	if err != nil {
+		log.WithError(err).Error("Cannot parse the args.")
+		return err
+	}
+
+	level := *lf.level
+	if *lf.verbose {
+		level = "debug"
+	}
+
+	err = logging.Setup(level, *lf.format)
+	if err != nil {
+		log.WithError(err).Error("Cannot configure the logging.")
+		return err
+	}
+
+	// Any client_oid given to continue an earlier request ends up in a URL and in a Bookwerx query, so it had better
+	// be one that OKEx could have given.
+	if f := cmd.Lookup("client_oid"); f != nil && f.Value.String() != "" && !okex.ValidClientOID(f.Value.String()) {
+		log.WithField("client_oid", f.Value.String()).Error("A client_oid starts with a letter and has at most 32 letters and digits.")
+		return errors.New("main: invalid client_oid " + f.Value.String())
+	}
+
+	return nil
+}
+
+// Every command that needs a config accepts these flags in order to find it.
+type configFlags struct {
+	filename *string
+	profile  *string
+}
+
+func addConfigFlags(cmd *flag.FlagSet) configFlags {
+	return configFlags{
+		filename: cmd.String("config", "/path/to/config.yml", "The config file for OKConnect"),
+		profile:  cmd.String("profile", "", "Use this profile from the config file, such as sandbox"),
+	}
+}
+
+func readConfigFile(cf configFlags) (cfg *config.Config, err error) {
+	data, err := ioutil.ReadFile(*cf.filename)
 	if err != nil {
-		fmt.Printf("ReadFile error: %v\n", err)
+		log.WithError(err).WithField("config", *cf.filename).Error("Cannot read the config file.")
 		return nil, err
 	}
 
@@ -32,7 +162,19 @@ func readConfigFile(filename *string) (cfg *config.Config, err error) {
 
 	err = yaml.Unmarshal(data, cfg)
 	if err != nil {
-		fmt.Printf("Cannot parse config file.\n")
+		log.WithError(err).WithField("config", *cf.filename).Error("Cannot parse the config file.")
+		return nil, err
+	}
+
+	if *cf.profile != "" {
+		cfg, err = cfg.UseProfile(*cf.profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = cfg.ResolveSecrets()
+	if err != nil {
 		return nil, err
 	}
 
@@ -41,16 +183,158 @@ func readConfigFile(filename *string) (cfg *config.Config, err error) {
 
 func main() {
 
+	// Until the command's flags say otherwise.
+	_ = logging.Setup("info", "text")
+
 	compareCmd := flag.NewFlagSet("compare", flag.ExitOnError)
-	compareConfig := compareCmd.String("config", "/path/to/config.yml", "The config file for OKConnect")
+	compareConfig := addConfigFlags(compareCmd)
+	compareAdjustRounding := compareCmd.Bool("adjust-rounding", false, "Book a rounding adjustment for each difference that's within tolerance")
+	comparePropose := compareCmd.Bool("propose", false, "Print a proposed adjusting transaction for each mismatch")
+	compareApply := compareCmd.Bool("apply", false, "Post the proposed adjusting transactions, after asking")
+	compareYes := compareCmd.Bool("yes", false, "With -apply, post them without asking")
+	compareAsOf := compareCmd.String("as-of", "", "Compare the balances as of this time, such as 2020-06-01T00:00:00Z, replaying the OKEx ledgers")
+	compareSnapshot := compareCmd.String("snapshot", "", "Compare the balances as of the time of this saved snapshot, using the OKEx balances in it")
+	compareSaveSnapshot := compareCmd.Bool("save-snapshot", false, "Save the balances in a snapshot")
+	compareLog := addLogFlags(compareCmd)
+
+	// okconnect credentials import -in okex.json -out okex.credentials
+	credentialsImportCmd := flag.NewFlagSet("credentials import", flag.ExitOnError)
+	credentialsImportIn := credentialsImportCmd.String("in", "/path/to/okex.json", "The plaintext credentials file to import")
+	credentialsImportOut := credentialsImportCmd.String("out", "/path/to/okex.credentials", "The encrypted credentials file to create")
+	credentialsImportRaw := credentialsImportCmd.Bool("raw", false, "Encrypt some other secret, such as a Bookwerx API key, as-is")
+	credentialsImportLog := addLogFlags(credentialsImportCmd)
+
+	// okconnect credentials rotate -file okex.credentials -in new-okex.json
+	credentialsRotateCmd := flag.NewFlagSet("credentials rotate", flag.ExitOnError)
+	credentialsRotateFile := credentialsRotateCmd.String("file", "/path/to/okex.credentials", "The encrypted credentials file")
+	credentialsRotateIn := credentialsRotateCmd.String("in", "", "Optionally replace the credentials with those in this plaintext file")
+	credentialsRotateLog := addLogFlags(credentialsRotateCmd)
+
+	// okconnect credentials show-key-id -file okex.credentials
+	credentialsShowKeyIDCmd := flag.NewFlagSet("credentials show-key-id", flag.ExitOnError)
+	credentialsShowKeyIDFile := credentialsShowKeyIDCmd.String("file", "/path/to/okex.credentials", "The encrypted credentials file")
+	credentialsShowKeyIDLog := addLogFlags(credentialsShowKeyIDCmd)
+
+	// okconnect export tax -year 2026 -format generic -config okconnect.yaml
+	exportTaxCmd := flag.NewFlagSet("export tax", flag.ExitOnError)
+	exportTaxConfig := addConfigFlags(exportTaxCmd)
+	exportTaxYear := exportTaxCmd.Int("year", time.Now().Year(), "Export the disposals sold during this year")
+	exportTaxFormat := exportTaxCmd.String("format", export.FormatGeneric, "generic, 8949, or turbotax")
+	exportTaxLog := addLogFlags(exportTaxCmd)
+
+	// okconnect lots -config okconnect.yaml
+	lotsCmd := flag.NewFlagSet("lots", flag.ExitOnError)
+	lotsConfig := addConfigFlags(lotsCmd)
+	lotsFormat := lotsCmd.String("format", "table", "table or json")
+	lotsBackfill := lotsCmd.Bool("backfill-disposals", false, "Find the disposals sold before they were recorded in the lots, using Bookwerx")
+	lotsLog := addLogFlags(lotsCmd)
+
+	// okconnect margin borrow -instrument BTC-USDT -currency USDT -quan 100 -config okconnect.yaml
+	marginBorrowCmd := flag.NewFlagSet("margin borrow", flag.ExitOnError)
+	marginBorrowConfig := addConfigFlags(marginBorrowCmd)
+	marginBorrowInstrument := marginBorrowCmd.String("instrument", "BTC-USDT", "The instrument of the margin account")
+	marginBorrowCurrency := marginBorrowCmd.String("currency", "USDT", "Which currency to borrow")
+	marginBorrowQuan := marginBorrowCmd.String("quan", "0.0", "How much to borrow")
+	marginBorrowClientOID := marginBorrowCmd.String("client_oid", "", "Continue an earlier borrowing that has this client_oid")
+	marginBorrowLog := addLogFlags(marginBorrowCmd)
 
-	// okconnect transfer -currency BTC -quan 1.25 -from 6 -to 3 -config okconnect.yaml
+	// okconnect margin repay -instrument BTC-USDT -currency USDT -quan 100 -config okconnect.yaml
+	marginRepayCmd := flag.NewFlagSet("margin repay", flag.ExitOnError)
+	marginRepayConfig := addConfigFlags(marginRepayCmd)
+	marginRepayInstrument := marginRepayCmd.String("instrument", "BTC-USDT", "The instrument of the margin account")
+	marginRepayCurrency := marginRepayCmd.String("currency", "USDT", "Which currency to repay")
+	marginRepayQuan := marginRepayCmd.String("quan", "0.0", "How much to repay")
+	marginRepayClientOID := marginRepayCmd.String("client_oid", "", "Continue an earlier repayment that has this client_oid")
+	marginRepayLog := addLogFlags(marginRepayCmd)
+
+	// okconnect margin resolve -client_oid okc0123456789abcdef0123456789 -happened=true -config okconnect.yaml
+	marginResolveCmd := flag.NewFlagSet("margin resolve", flag.ExitOnError)
+	marginResolveConfig := addConfigFlags(marginResolveCmd)
+	marginResolveClientOID := marginResolveCmd.String("client_oid", "", "The client_oid of the borrowing or repayment whose outcome is unknown")
+	marginResolveHappened := marginResolveCmd.Bool("happened", false, "Whether the margin history at OKEx shows that it happened")
+	marginResolveLog := addLogFlags(marginResolveCmd)
+
+	// okconnect report balance -as-of 2020-06-01 -config okconnect.yaml
+	reportBalanceCmd := flag.NewFlagSet("report balance", flag.ExitOnError)
+	reportBalanceConfig := addConfigFlags(reportBalanceCmd)
+	reportBalanceAsOf := reportBalanceCmd.String("as-of", "", "The time of the balance sheet, such as 2020-06-01T00:00:00Z.  Default is now")
+	reportBalanceFormat := reportBalanceCmd.String("format", "table", "table, csv, or json")
+	reportBalanceLog := addLogFlags(reportBalanceCmd)
+
+	// okconnect report pnl -from 2020-01-01 -to 2021-01-01 -config okconnect.yaml
+	reportPNLCmd := flag.NewFlagSet("report pnl", flag.ExitOnError)
+	reportPNLConfig := addConfigFlags(reportPNLCmd)
+	reportPNLFrom := reportPNLCmd.String("from", "", "The start of the P&L, such as 2020-01-01T00:00:00Z.  Default is the beginning of time")
+	reportPNLTo := reportPNLCmd.String("to", "", "The end of the P&L, such as 2021-01-01T00:00:00Z.  Default is now")
+	reportPNLFormat := reportPNLCmd.String("format", "table", "table, csv, or json")
+	reportPNLLog := addLogFlags(reportPNLCmd)
+
+	// okconnect snapshot list -config okconnect.yaml
+	snapshotListCmd := flag.NewFlagSet("snapshot list", flag.ExitOnError)
+	snapshotListConfig := addConfigFlags(snapshotListCmd)
+	snapshotListLog := addLogFlags(snapshotListCmd)
+
+	// okconnect snapshot show -id 20201010T000000.000Z -config okconnect.yaml
+	snapshotShowCmd := flag.NewFlagSet("snapshot show", flag.ExitOnError)
+	snapshotShowConfig := addConfigFlags(snapshotShowCmd)
+	snapshotShowID := snapshotShowCmd.String("id", "", "Which snapshot to show.  Default is the newest")
+	snapshotShowLog := addLogFlags(snapshotShowCmd)
+
+	// okconnect snapshot diff -from 20201009T000000.000Z -to 20201010T000000.000Z -config okconnect.yaml
+	snapshotDiffCmd := flag.NewFlagSet("snapshot diff", flag.ExitOnError)
+	snapshotDiffConfig := addConfigFlags(snapshotDiffCmd)
+	snapshotDiffFrom := snapshotDiffCmd.String("from", "", "The earlier snapshot.  Default is the one before -to")
+	snapshotDiffTo := snapshotDiffCmd.String("to", "", "The later snapshot.  Default is the newest")
+	snapshotDiffLog := addLogFlags(snapshotDiffCmd)
+
+	// okconnect sync ledger -config okconnect.yaml
+	syncLedgerCmd := flag.NewFlagSet("sync ledger", flag.ExitOnError)
+	syncLedgerConfig := addConfigFlags(syncLedgerCmd)
+	syncLedgerBackfill := syncLedgerCmd.Bool("backfill", false, "Book the entire history of any ledger that we have not followed before")
+	syncLedgerLog := addLogFlags(syncLedgerCmd)
+
+	// okconnect sync swap-ledger -config okconnect.yaml
+	syncSwapLedgerCmd := flag.NewFlagSet("sync swap-ledger", flag.ExitOnError)
+	syncSwapLedgerConfig := addConfigFlags(syncSwapLedgerCmd)
+	syncSwapLedgerBackfill := syncSwapLedgerCmd.Bool("backfill", false, "Book the entire history of any ledger that we have not followed before")
+	syncSwapLedgerLog := addLogFlags(syncSwapLedgerCmd)
+
+	// okconnect tui -config okconnect.yaml
+	tuiCmd := flag.NewFlagSet("tui", flag.ExitOnError)
+	tuiConfig := addConfigFlags(tuiCmd)
+	tuiLog := addLogFlags(tuiCmd)
+
+	// okconnect transfer -currency BTC -quan 1.25 -from funding -to spot -config okconnect.yaml
 	transferCmd := flag.NewFlagSet("transfer", flag.ExitOnError)
-	transferConfig := transferCmd.String("config", "/path/to/config.yml", "The config file for OKConnect")
+	transferConfig := addConfigFlags(transferCmd)
 	transferCurrency := transferCmd.String("currency", "BTC", "Which currency to transfer")
 	transferQuan := transferCmd.String("quan", "0.0", "How much to transfer")
-	transferFrom := transferCmd.String("from", "3", "Source: \"1\" (spot) or \"6\" (funding)")
-	transferTo := transferCmd.String("to", "3", "Destination: \"1\" (spot) or \"6\" (funding)")
+	transferFrom := transferCmd.String("from", "funding", "Source: funding, spot, futures, c2c, margin, swap, options, or sub-account")
+	transferTo := transferCmd.String("to", "spot", "Destination: funding, spot, futures, c2c, margin, swap, options, or sub-account")
+	transferInstrument := transferCmd.String("instrument", "", "The instrument, such as BTC-USDT, of a margin account")
+	transferToInstrument := transferCmd.String("to_instrument", "", "The instrument of the destination margin account, if the source is a margin account too")
+	transferSubAccount := transferCmd.String("sub_account", "", "The name, in the config, of the sub-account for a sub-account transfer")
+	transferClientOID := transferCmd.String("client_oid", "", "Continue an earlier transfer that has this client_oid")
+	transferFromAccount := transferCmd.String("from_account", config.MainAccount, "Source account: \"main\" or the name of a sub-account in the config")
+	transferToAccount := transferCmd.String("to_account", config.MainAccount, "Destination account: \"main\" or the name of a sub-account in the config")
+	transferLog := addLogFlags(transferCmd)
+
+	// okconnect valuation -quote USDT -config okconnect.yaml
+	valuationCmd := flag.NewFlagSet("valuation", flag.ExitOnError)
+	valuationConfig := addConfigFlags(valuationCmd)
+	valuationQuote := valuationCmd.String("quote", "USDT", "Value everything in this currency")
+	valuationFormat := valuationCmd.String("format", "table", "table or json")
+	valuationBook := valuationCmd.Bool("book", false, "Also book the change in value since the last revaluation as an unrealised gain or loss")
+	valuationLog := addLogFlags(valuationCmd)
+
+	// okconnect watch -interval 1m -config okconnect.yaml
+	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	watchConfig := addConfigFlags(watchCmd)
+	watchInterval := watchCmd.Duration("interval", time.Minute, "How long to wait between cycles")
+	watchBackfill := watchCmd.Bool("backfill", false, "Book the entire history of any ledger that we have not followed before")
+	watchWebsocket := watchCmd.Bool("websocket", false, "Also start a cycle whenever the OKEx WebSocket says that an order or balance has changed")
+	watchMetricsAddr := watchCmd.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, such as :9100")
+	watchLog := addLogFlags(watchCmd)
 
 	// Args[0] is okconnect
 	// Args[1] should be a subcommand
@@ -64,36 +348,370 @@ func main() {
 			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
 				compareCmd.Usage()
 			} else {
-				err := compareCmd.Parse(os.Args[2:])
+				err := parseArgs(compareCmd, compareLog, os.Args[2:])
 				if err != nil {
-					fmt.Printf("Cannot parse the args.\n")
 					return
 				}
 
 				cfg, err := readConfigFile(compareConfig)
 				if err != nil {
-					fmt.Printf("Cannot read the config file.\n")
 					return
 				}
-				compare.Compare(cfg)
+				opts := compare.Options{AdjustRounding: *compareAdjustRounding, Propose: *comparePropose, Apply: *compareApply, Yes: *compareYes, AsOf: *compareAsOf}
+				if *compareSnapshot != "" {
+					opts.Snapshot, err = compare.ReadSnapshot(*compareSnapshot)
+					if err != nil {
+						return
+					}
+				}
+				if *compareSaveSnapshot {
+					opts.Record = &compare.Snapshot{}
+				}
+				mismatches, err := compare.Compare(cfg, opts)
+				if err != nil {
+					return
+				}
+				if opts.Record != nil {
+					_, _ = snapshot.Save(cfg, opts.Record)
+				}
+
+				// The mismatches of the past are not news.
+				if opts.AsOf == "" && opts.Snapshot == nil {
+					_ = alert.Check(cfg, mismatches)
+				}
+			}
+
+		case "credentials":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printCredentialsUsage()
+				return
+			}
+
+			var cmd *flag.FlagSet
+			var lf logFlags
+			switch os.Args[2] {
+			case "import":
+				cmd, lf = credentialsImportCmd, credentialsImportLog
+			case "rotate":
+				cmd, lf = credentialsRotateCmd, credentialsRotateLog
+			case "show-key-id":
+				cmd, lf = credentialsShowKeyIDCmd, credentialsShowKeyIDLog
+			default:
+				fmt.Printf("The command credentials %s is not defined.\n", os.Args[2])
+				printCredentialsUsage()
+				return
+			}
+
+			if len(os.Args) <= 3 {
+				cmd.Usage()
+				return
+			}
+
+			err := parseArgs(cmd, lf, os.Args[3:])
+			if err != nil {
+				return
+			}
+
+			switch os.Args[2] {
+			case "import":
+				credstore.Import(*credentialsImportIn, *credentialsImportOut, *credentialsImportRaw)
+			case "rotate":
+				credstore.Rotate(*credentialsRotateFile, *credentialsRotateIn)
+			case "show-key-id":
+				credstore.ShowKeyID(*credentialsShowKeyIDFile)
+			}
+
+		case "export":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printExportUsage()
+				return
+			}
+
+			switch os.Args[2] {
+			case "tax":
+				if len(os.Args) <= 3 {
+					exportTaxCmd.Usage()
+					return
+				}
+
+				err := parseArgs(exportTaxCmd, exportTaxLog, os.Args[3:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(exportTaxConfig)
+				if err != nil {
+					return
+				}
+				export.Tax(cfg, *exportTaxYear, *exportTaxFormat)
+
+			default:
+				fmt.Printf("The command export %s is not defined.\n", os.Args[2])
+				printExportUsage()
+			}
+
+		case "lots":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				lotsCmd.Usage()
+			} else {
+				err := parseArgs(lotsCmd, lotsLog, os.Args[2:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(lotsConfig)
+				if err != nil {
+					return
+				}
+				if *lotsBackfill {
+					lots.Backfill(cfg)
+					return
+				}
+				lots.Show(cfg, *lotsFormat)
+			}
+
+		case "margin":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printMarginUsage()
+				return
+			}
+
+			var cmd *flag.FlagSet
+			var lf logFlags
+			var configFile configFlags
+			switch os.Args[2] {
+			case "borrow":
+				cmd, lf, configFile = marginBorrowCmd, marginBorrowLog, marginBorrowConfig
+			case "repay":
+				cmd, lf, configFile = marginRepayCmd, marginRepayLog, marginRepayConfig
+			case "resolve":
+				cmd, lf, configFile = marginResolveCmd, marginResolveLog, marginResolveConfig
+			default:
+				fmt.Printf("The command margin %s is not defined.\n", os.Args[2])
+				printMarginUsage()
+				return
+			}
+
+			if len(os.Args) <= 3 {
+				cmd.Usage()
+				return
+			}
+
+			err := parseArgs(cmd, lf, os.Args[3:])
+			if err != nil {
+				return
+			}
+
+			cfg, err := readConfigFile(configFile)
+			if err != nil {
+				return
+			}
+
+			switch os.Args[2] {
+			case "borrow":
+				margin.Borrow(cfg, *marginBorrowInstrument, *marginBorrowCurrency, *marginBorrowQuan, *marginBorrowClientOID)
+			case "repay":
+				margin.Repay(cfg, *marginRepayInstrument, *marginRepayCurrency, *marginRepayQuan, *marginRepayClientOID)
+			case "resolve":
+				margin.Resolve(cfg, *marginResolveClientOID, *marginResolveHappened)
+			}
+
+		case "report":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printReportUsage()
+				return
+			}
+
+			var cmd *flag.FlagSet
+			var lf logFlags
+			var configFile configFlags
+			switch os.Args[2] {
+			case "balance":
+				cmd, lf, configFile = reportBalanceCmd, reportBalanceLog, reportBalanceConfig
+			case "pnl":
+				cmd, lf, configFile = reportPNLCmd, reportPNLLog, reportPNLConfig
+			default:
+				fmt.Printf("The command report %s is not defined.\n", os.Args[2])
+				printReportUsage()
+				return
+			}
+
+			if len(os.Args) <= 3 {
+				cmd.Usage()
+				return
+			}
+
+			err := parseArgs(cmd, lf, os.Args[3:])
+			if err != nil {
+				return
+			}
+
+			cfg, err := readConfigFile(configFile)
+			if err != nil {
+				return
+			}
+
+			switch os.Args[2] {
+			case "balance":
+				report.Balance(cfg, *reportBalanceAsOf, *reportBalanceFormat)
+			case "pnl":
+				report.PNL(cfg, *reportPNLFrom, *reportPNLTo, *reportPNLFormat)
+			}
+
+		case "snapshot":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printSnapshotUsage()
+				return
+			}
+
+			var cmd *flag.FlagSet
+			var lf logFlags
+			var configFile configFlags
+			switch os.Args[2] {
+			case "list":
+				cmd, lf, configFile = snapshotListCmd, snapshotListLog, snapshotListConfig
+			case "show":
+				cmd, lf, configFile = snapshotShowCmd, snapshotShowLog, snapshotShowConfig
+			case "diff":
+				cmd, lf, configFile = snapshotDiffCmd, snapshotDiffLog, snapshotDiffConfig
+			default:
+				fmt.Printf("The command snapshot %s is not defined.\n", os.Args[2])
+				printSnapshotUsage()
+				return
+			}
+
+			if len(os.Args) <= 3 {
+				cmd.Usage()
+				return
+			}
+
+			err := parseArgs(cmd, lf, os.Args[3:])
+			if err != nil {
+				return
+			}
+
+			cfg, err := readConfigFile(configFile)
+			if err != nil {
+				return
+			}
+
+			switch os.Args[2] {
+			case "list":
+				snapshot.List(cfg)
+			case "show":
+				snapshot.Show(cfg, *snapshotShowID)
+			case "diff":
+				snapshot.Diff(cfg, *snapshotDiffFrom, *snapshotDiffTo)
+			}
+
+		case "sync":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				printSyncUsage()
+				return
+			}
+
+			switch os.Args[2] {
+			case "ledger":
+				if len(os.Args) <= 3 {
+					syncLedgerCmd.Usage()
+					return
+				}
+
+				err := parseArgs(syncLedgerCmd, syncLedgerLog, os.Args[3:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(syncLedgerConfig)
+				if err != nil {
+					return
+				}
+				ledger.Sync(cfg, *syncLedgerBackfill)
+
+			case "swap-ledger":
+				if len(os.Args) <= 3 {
+					syncSwapLedgerCmd.Usage()
+					return
+				}
+
+				err := parseArgs(syncSwapLedgerCmd, syncSwapLedgerLog, os.Args[3:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(syncSwapLedgerConfig)
+				if err != nil {
+					return
+				}
+				ledger.SyncSwap(cfg, *syncSwapLedgerBackfill)
+
+			default:
+				fmt.Printf("The command sync %s is not defined.\n", os.Args[2])
+				printSyncUsage()
 			}
 
 		case "transfer":
 			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
 				transferCmd.Usage()
 			} else {
-				err := transferCmd.Parse(os.Args[2:])
+				err := parseArgs(transferCmd, transferLog, os.Args[2:])
 				if err != nil {
-					fmt.Printf("Cannot parse the args.\n")
 					return
 				}
 
 				cfg, err := readConfigFile(transferConfig)
 				if err != nil {
-					fmt.Printf("Cannot read the config file.\n")
 					return
 				}
-				Transfer(cfg, transferCurrency, transferFrom, transferTo, transferQuan)
+				Transfer(cfg, transferCurrency, transferFrom, transferTo, transferQuan, transferClientOID, transferFromAccount, transferToAccount, transferInstrument, transferToInstrument, transferSubAccount)
+			}
+
+		case "tui":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				tuiCmd.Usage()
+			} else {
+				err := parseArgs(tuiCmd, tuiLog, os.Args[2:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(tuiConfig)
+				if err != nil {
+					return
+				}
+				tui.Run(cfg, tuiTransfer)
+			}
+
+		case "valuation":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				valuationCmd.Usage()
+			} else {
+				err := parseArgs(valuationCmd, valuationLog, os.Args[2:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(valuationConfig)
+				if err != nil {
+					return
+				}
+				valuation.Value(cfg, *valuationQuote, *valuationFormat, *valuationBook)
+			}
+
+		case "watch":
+			if len(os.Args) <= 2 { // Invoked with this command but w/o any other args
+				watchCmd.Usage()
+			} else {
+				err := parseArgs(watchCmd, watchLog, os.Args[2:])
+				if err != nil {
+					return
+				}
+
+				cfg, err := readConfigFile(watchConfig)
+				if err != nil {
+					return
+				}
+				watch.Watch(cfg, *watchInterval, *watchBackfill, *watchWebsocket, *watchMetricsAddr)
 			}
 
 		default:
@@ -0,0 +1,332 @@
+// The purpose of this package is to borrow and repay on an OKEx margin account and to book both in Bookwerx.
+//
+// What's borrowed is a liability.  OKEx accrues interest on it, which we book as an expense that adds to the
+// liability, just before we borrow or repay, so that the loan account in Bookwerx agrees with what OKEx says we owe.
+// OKEx takes the interest first out of whatever we repay, so a repayment merely reduces the liability.
+//
+// Like transfer, every request is identified by a client_oid that is remembered in the local journal and written into
+// the notes of the Bookwerx transaction.  If anything goes wrong, run the same command again with -client_oid.
+package margin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/journal"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/gojektech/heimdall/httpclient"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// The kinds of journal entry.
+const (
+	kindBorrow = "margin-borrow"
+	kindRepay  = "margin-repay"
+)
+
+type request struct {
+	InstrumentID string `json:"instrument_id"`
+	Currency     string `json:"currency"`
+	Amount       string `json:"amount"`
+	ClientOID    string `json:"client_oid"`
+}
+
+// Borrow the given quantity of the currency on the margin account for the given instrument, and book it.
+//
+// DR Margin           quan
+// CR Margin Borrowed  quan
+//
+// Example:
+// okconnect margin borrow -instrument BTC-USDT -currency USDT -quan 100 -config okconnect.yaml
+func Borrow(cfg *config.Config, instrument string, currency string, quan string, clientOID string) {
+	do(cfg, kindBorrow, "/api/margin/v3/accounts/borrow", instrument, currency, quan, clientOID)
+}
+
+// Repay the given quantity of the currency on the margin account for the given instrument, and book it.
+//
+// DR Margin Borrowed  quan
+// CR Margin           quan
+//
+// Example:
+// okconnect margin repay -instrument BTC-USDT -currency USDT -quan 100 -config okconnect.yaml
+func Repay(cfg *config.Config, instrument string, currency string, quan string, clientOID string) {
+	do(cfg, kindRepay, "/api/margin/v3/accounts/repayment", instrument, currency, quan, clientOID)
+}
+
+func do(cfg *config.Config, kind string, endpoint string, instrument string, currency string, quan string, clientOID string) {
+	bc := cfg.BookwerxConfig
+	instrument, currency = strings.ToUpper(instrument), strings.ToUpper(currency)
+
+	// 1. Validate the args.
+	if bc.CatMargin == 0 || bc.CatMarginBorrowed == 0 || (bc.CatMarginInterest == 0 && bc.CatFee == 0) {
+		log.Error("The config must say which categories are cat_margin, cat_margin_borrowed, and either cat_margin_interest or cat_fee.")
+		return
+	}
+
+	currencies := strings.Split(instrument, "-")
+	if len(currencies) != 2 || (currencies[0] != currency && currencies[1] != currency) {
+		log.WithFields(log.Fields{"instrument": instrument, "currency": currency}).Error("The currency must be one of the instrument's currencies.")
+		return
+	}
+
+	amount, err := decimal.NewFromString(quan)
+	if err != nil || !amount.IsPositive() {
+		log.WithField("quan", quan).Error("The quantity must be a positive number.")
+		return
+	}
+	_, err = bookwerx.ToDFP(amount)
+	if err != nil {
+		log.WithError(err).WithField("quan", quan).Error("Bookwerx cannot hold this quantity.")
+		return
+	}
+
+	// 2. Find the Bookwerx accounts before we do anything at OKEx.  The margin accounts may be kept for each instrument.
+	clientB := okchttp.GetHeimdallClient("bookwerx", bc.BaseURL, 5000*time.Millisecond)
+	marginID, err := bookwerx.FindInstrumentAccount(clientB, bc.CatMargin, currency, instrument, *cfg)
+	if err != nil {
+		log.WithError(err).Error("Cannot find the margin account.")
+		return
+	}
+	borrowedID, err := bookwerx.FindInstrumentAccount(clientB, bc.CatMarginBorrowed, currency, instrument, *cfg)
+	if err != nil {
+		log.WithError(err).Error("Cannot find the margin borrowed account.")
+		return
+	}
+	interestCategory := bc.CatMarginInterest
+	if interestCategory == 0 {
+		interestCategory = bc.CatFee
+	}
+	interestID, err := bookwerx.FindCategoryAccount(clientB, interestCategory, currency, *cfg)
+	if err != nil {
+		log.WithError(err).Error("Cannot find the margin interest account.")
+		return
+	}
+
+	// 3. Get ready to talk to OKEx.
+	credentials, err := config.ReadCredentials(cfg.OKExConfig.Credentials)
+	if err != nil {
+		log.Error("Cannot read the OKEx credentials file.")
+		return
+	}
+
+	jrnl, err := journal.Open(cfg.Journal)
+	if err != nil {
+		log.Error("Cannot open the journal.")
+		return
+	}
+
+	client := okex.NewClient(*cfg, *credentials)
+	client.Journal = jrnl
+
+	// 4. Which request is this?  If we are continuing an earlier attempt then perhaps there's nothing left to do.
+	fresh := clientOID == ""
+	if fresh {
+		clientOID = okex.NewClientOID()
+	} else if entry, ok := jrnl.Get(clientOID); ok && entry.State == journal.StateBooked {
+		log.WithField("client_oid", clientOID).Info("The request has already been made and booked.")
+		return
+	}
+	mlog := log.WithFields(log.Fields{"client_oid": clientOID, "kind": kind})
+	mlog.Info("Margin")
+
+	// 5. Book whatever interest has accrued so far.  Don't bother if we're continuing an earlier attempt, because
+	// whatever OKEx says we owe now might include that attempt, which is not yet booked.
+	if fresh {
+		err = accrueInterest(cfg, clientB, client, jrnl, currency, borrowedID, interestID)
+		if err != nil {
+			return
+		}
+	}
+
+	// 6. Make the Call!  OKEx can't tell us about a borrowing or a repayment by its client_oid, so if the outcome is
+	// uncertain then it stays pending in the journal until somebody checks by hand and resolves it.
+	reqBody, _ := json.Marshal(request{instrument, currency, amount.String(), clientOID})
+	_, err = client.Submit(kind, endpoint, clientOID, string(reqBody), nil)
+	if err == okex.ErrOutcomeUnknown {
+		mlog.Error("Nobody knows whether OKEx did this.  Look at the margin history at OKEx and then run okconnect margin resolve -client_oid with -happened=true or -happened=false.")
+		return
+	}
+	if err != nil {
+		mlog.WithError(err).Error("The OKEx API call failed.  Rerun with -client_oid to try again.")
+		return
+	}
+
+	// 7. Book it, but not if an earlier run has already done so.
+	txids, err := bookwerx.FindTransactionsByNotes(clientB, clientOID, *cfg)
+	if err != nil {
+		mlog.WithError(err).Error("Cannot determine whether bookwerx already has this.  Rerun with -client_oid to try again.")
+		return
+	}
+	if len(txids) == 0 {
+		dr, cr := marginID, borrowedID
+		verb := "borrow"
+		if kind == kindRepay {
+			dr, cr = borrowedID, marginID
+			verb = "repay"
+		}
+
+		notes := fmt.Sprintf("Margin %s %s %s on %s client_oid=%s", verb, amount.String(), currency, instrument, clientOID)
+		_, err = book(cfg, clientB, client.Timestamp(), notes, currency, dr, cr, amount)
+		if err != nil {
+			mlog.WithError(err).Error("Rerun with -client_oid to try again.")
+			return
+		}
+	} else {
+		mlog.WithField("transaction_id", txids[0]).Info("Bookwerx already has this.")
+	}
+
+	_ = jrnl.MarkBooked(clientOID)
+}
+
+// Settle a margin request whose outcome nobody knows, once somebody has looked at the margin history at OKEx.  If it
+// happened then book it, just as borrow or repay would have.  If not, then it has failed, so it no longer holds up the
+// accrual of the interest.
+//
+// Example:
+// okconnect margin resolve -client_oid okc0123456789abcdef0123456789 -happened=true -config okconnect.yaml
+func Resolve(cfg *config.Config, clientOID string, happened bool) {
+	rlog := log.WithField("client_oid", clientOID)
+
+	jrnl, err := journal.Open(cfg.Journal)
+	if err != nil {
+		log.Error("Cannot open the journal.")
+		return
+	}
+
+	// 1. Is there such a margin request, and is it still unknown?
+	entry, ok := jrnl.Get(clientOID)
+	if !ok || (entry.Kind != kindBorrow && entry.Kind != kindRepay) {
+		rlog.Error("The journal has no margin request with this client_oid.")
+		return
+	}
+	if entry.State != journal.StatePending {
+		rlog.WithField("state", entry.State).Error("The outcome of this request is already known.  If it's done, rerun it with -client_oid to book it.")
+		return
+	}
+
+	r := request{}
+	err = json.Unmarshal([]byte(entry.Request), &r)
+	if err != nil {
+		rlog.WithError(err).Error("Cannot decode the request in the journal.")
+		return
+	}
+
+	// 2. Say what happened.
+	if !happened {
+		_ = jrnl.Resolve(clientOID, false, "resolved by hand: it did not happen")
+		rlog.Info("The request did not happen.")
+		return
+	}
+	err = jrnl.Resolve(clientOID, true, "resolved by hand: it happened")
+	if err != nil {
+		return
+	}
+
+	// 3. ... and book it.
+	do(cfg, entry.Kind, entry.Endpoint, r.InstrumentID, r.Currency, r.Amount, clientOID)
+}
+
+// Book, as an expense, however much more OKEx says that we owe than Bookwerx does.  The expense is the margin interest
+// account, or if the config doesn't say which that is, the fee account.
+//
+// DR Margin Interest  interest
+// CR Margin Borrowed  interest
+func accrueInterest(cfg *config.Config, clientB *httpclient.Client, client *okex.Client, jrnl *journal.Journal, currency string, borrowedID uint32, interestID uint32) error {
+	ilog := log.WithField("currency", currency)
+
+	// 1. If some other margin request is not yet booked then the difference isn't all interest.
+	for _, e := range jrnl.InState(journal.StatePending, journal.StateDone) {
+		if e.Kind == kindBorrow || e.Kind == kindRepay {
+			ilog.WithField("client_oid", e.ClientOID).Warn("Another margin request is not yet booked, so the interest cannot be accrued now.  Finish that one first, or resolve it.")
+			return nil
+		}
+	}
+
+	// 2. What does Bookwerx say?  It's a credit.  If the borrowed account is kept for a single instrument then only that
+	// account counts.  Otherwise every account that names no instrument counts, as compare does.
+	sums, err := bookwerx.CategoryDistSums(*cfg, cfg.BookwerxConfig.CatMarginBorrowed, "", "")
+	if err != nil {
+		ilog.WithError(err).Error("Cannot get the margin borrowed balance from Bookwerx.")
+		return err
+	}
+	instrument := ""
+	own := make(map[string]bool) // the instruments that have their own account
+	for _, brd := range sums {
+		if brd.Account.Currency.Symbol != currency {
+			continue
+		}
+		named := bookwerx.TitleInstrument(brd.Account.Title)
+		if brd.Account.AccountID == borrowedID {
+			instrument = named
+		}
+		if named != "" {
+			own[named] = true
+		}
+	}
+	booked := decimal.Zero
+	for _, brd := range sums {
+		if brd.Account.Currency.Symbol == currency && bookwerx.TitleInstrument(brd.Account.Title) == instrument {
+			booked = booked.Sub(brd.Sum.Decimal())
+		}
+	}
+
+	// 3. What does OKEx say we owe, on the same instruments?
+	marginAccounts, err := client.MarginAccounts()
+	if err != nil {
+		ilog.WithError(err).Error("Cannot get the OKEx margin accounts.")
+		return err
+	}
+
+	owed := decimal.Zero
+	for _, a := range marginAccounts {
+		id := strings.ToUpper(a.InstrumentID)
+		if (instrument != "" && id != instrument) || (instrument == "" && own[id]) {
+			continue
+		}
+		b, ok := a.Balances[currency]
+		if !ok {
+			continue
+		}
+		for _, s := range []string{b.Borrowed, b.LendingFee} {
+			if s == "" {
+				continue
+			}
+			d, err := decimal.NewFromString(s)
+			if err != nil {
+				ilog.WithError(err).WithFields(log.Fields{"instrument_id": a.InstrumentID, "balance": s}).Error("Cannot parse the margin balance.")
+				return err
+			}
+			owed = owed.Add(d)
+		}
+	}
+
+	interest := owed.Sub(booked)
+	ilog = ilog.WithFields(log.Fields{"owed": owed.String(), "booked": booked.String()})
+	if !interest.IsPositive() {
+		if interest.IsNegative() {
+			ilog.Warn("Bookwerx says that we owe more than OKEx does.  Run compare to see why.")
+		}
+		return nil
+	}
+
+	// 4. Book the difference.
+	notes := fmt.Sprintf("Margin interest %s %s", interest.String(), currency)
+	_, err = book(cfg, clientB, client.Timestamp(), notes, currency, interestID, borrowedID, interest)
+	if err != nil {
+		return err
+	}
+	ilog.WithField("interest", interest.String()).Info("Accrued the margin interest.")
+	return nil
+}
+
+// Book a transaction that debits one account and credits another.
+func book(cfg *config.Config, clientB *httpclient.Client, timestamp string, notes string, currency string, dr uint32, cr uint32, amount decimal.Decimal) (uint32, error) {
+	tx := bookwerx.NewTransaction(timestamp, notes)
+	tx.Add(dr, currency, amount).Add(cr, currency, amount.Neg())
+	return tx.Post(clientB, *cfg)
+}
@@ -0,0 +1,128 @@
+package margin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/journal"
+	"github.com/bostontrader/okconnect/okex"
+)
+
+const (
+	testCatBorrowed = 7
+	testInterestID  = 81
+	testUnnamedID   = 71 // Borrowed USDT, for every instrument that has no account of its own
+	testBTCUSDTID   = 72 // Borrowed USDT BTC-USDT
+)
+
+// A Bookwerx that owes 100 USDT on the unnamed account and 50 USDT on BTC-USDT, and remembers the distributions that
+// are posted, as "account_id amount".
+type stubBookwerx struct {
+	mu            sync.Mutex
+	distributions []string
+}
+
+func (b *stubBookwerx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_ = r.ParseForm()
+	switch {
+	case r.URL.Path == "/category_dist_sums" && r.Form.Get("category_id") == fmt.Sprint(testCatBorrowed):
+		fmt.Fprintf(w, `{"sums":[
+			{"account":{"account_id":%d,"title":"Borrowed USDT","currency":{"currency_id":1,"symbol":"USDT"}},"sum":{"amount":-100,"exp":0}},
+			{"account":{"account_id":%d,"title":"Borrowed USDT BTC-USDT","currency":{"currency_id":1,"symbol":"USDT"}},"sum":{"amount":-50,"exp":0}},
+			{"account":{"account_id":73,"title":"Borrowed BTC BTC-USDT","currency":{"currency_id":2,"symbol":"BTC"}},"sum":{"amount":-1,"exp":0}}
+		]}`, testUnnamedID, testBTCUSDTID)
+	case r.Method == "POST" && r.URL.Path == "/transactions":
+		fmt.Fprint(w, `{"LastInsertId":1}`)
+	case r.Method == "POST" && r.URL.Path == "/distributions":
+		b.distributions = append(b.distributions, fmt.Sprintf("%s %se%s", r.Form.Get("account_id"), r.Form.Get("amount"), r.Form.Get("amount_exp")))
+		fmt.Fprintf(w, `{"LastInsertId":%d}`, len(b.distributions))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (b *stubBookwerx) posted() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Join(b.distributions, ", ")
+}
+
+func TestAccrueInterest(t *testing.T) {
+	marginAsked := 0
+	okexServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/margin/v3/accounts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		marginAsked++
+		fmt.Fprint(w, `[
+			{"instrument_id":"btc-usdt","currency:BTC":{"borrowed":"1","lending_fee":"0.01"},"currency:USDT":{"borrowed":"50","lending_fee":"0.5"}},
+			{"instrument_id":"ETH-USDT","currency:ETH":{"borrowed":"0","lending_fee":"0"},"currency:USDT":{"borrowed":"100","lending_fee":"1.25"}},
+			{"instrument_id":"LTC-USDT","currency:USDT":{"borrowed":"0","lending_fee":"0.25"}}
+		]`)
+	}))
+	defer okexServer.Close()
+	client := &okex.Client{BaseURL: okexServer.URL, HTTPClient: okexServer.Client()}
+
+	tests := []struct {
+		name       string
+		borrowedID uint32
+		pending    string // the kind of some other request that's still pending, if any
+		asked      bool   // whether OKEx is asked what we owe
+		want       string
+	}{
+		// OKEx says 50.5 on BTC-USDT, which has its own account, and Bookwerx says 50.
+		{"own account", testBTCUSDTID, "", true, "81 5e-1, 72 -5e-1"},
+		// OKEx says 101.25 + 0.25 on the instruments without their own account, and Bookwerx says 100.
+		{"unnamed account", testUnnamedID, "", true, "81 15e-1, 71 -15e-1"},
+		// A transfer doesn't change what we owe.
+		{"pending transfer", testBTCUSDTID, "transfer", true, "81 5e-1, 72 -5e-1"},
+		// But a borrowing or a repayment does, and it's not yet booked.
+		{"pending borrow", testBTCUSDTID, kindBorrow, false, ""},
+		{"pending repay", testUnnamedID, kindRepay, false, ""},
+	}
+	for _, tt := range tests {
+		b := &stubBookwerx{}
+		bookwerxServer := httptest.NewServer(b)
+		cfg := &config.Config{}
+		cfg.BookwerxConfig.BaseURL = bookwerxServer.URL
+		cfg.BookwerxConfig.CatMarginBorrowed = testCatBorrowed
+		clientB := okchttp.GetHeimdallClient("bookwerx", bookwerxServer.URL, time.Second)
+
+		jrnl, err := journal.Open(filepath.Join(t.TempDir(), "okconnect.journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.pending != "" {
+			err = jrnl.Record(journal.Entry{ClientOID: "okcpending", Kind: tt.pending, State: journal.StatePending})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		marginAsked = 0
+		err = accrueInterest(cfg, clientB, client, jrnl, "USDT", tt.borrowedID, testInterestID)
+		bookwerxServer.Close()
+		if err != nil {
+			t.Errorf("%s: accrueInterest = %v", tt.name, err)
+			continue
+		}
+		if asked := marginAsked > 0; asked != tt.asked {
+			t.Errorf("%s: asked OKEx = %v, want %v", tt.name, asked, tt.asked)
+		}
+		if got := b.posted(); got != tt.want {
+			t.Errorf("%s: posted %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// The purpose of this package is to tell Prometheus how OKConnect is doing while it runs as a daemon.
+//
+// We only need a handful of counters, gauges, and summaries, so rather than drag in the Prometheus client library we
+// write the text exposition format ourselves.
+package metrics
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The metrics that OKConnect keeps.
+var (
+	BalanceDifference = newVec("okconnect_balance_difference", "gauge",
+		"The absolute difference between the OKEx and Bookwerx balances, as found by compare.",
+		"account", "category", "instrument", "currency")
+
+	UpstreamRequests = newVec("okconnect_upstream_requests_total", "counter",
+		"The number of requests made to each upstream endpoint, by response status.",
+		"upstream", "endpoint", "status")
+
+	UpstreamErrors = newVec("okconnect_upstream_errors_total", "counter",
+		"The number of requests to each upstream endpoint that got no response at all.",
+		"upstream", "endpoint")
+
+	UpstreamLatency = newVec("okconnect_upstream_request_duration_seconds", "summary",
+		"How long the requests to each upstream endpoint took.",
+		"upstream", "endpoint")
+
+	LastSync = newVec("okconnect_last_successful_sync_timestamp_seconds", "gauge",
+		"When the OKEx ledgers were last booked without error, in seconds since the epoch.")
+
+	JournalBacklog = newVec("okconnect_journal_backlog", "gauge",
+		"The number of requests in the journal that are not yet known to be both done and booked.",
+		"state")
+
+	RateLimitWaits = newVec("okconnect_rate_limit_waits_total", "counter",
+		"The number of times that we had to wait because OKEx said we were asking too often.",
+		"upstream")
+
+	RateLimitWaitSeconds = newVec("okconnect_rate_limit_wait_seconds_total", "counter",
+		"How long we have waited because OKEx said we were asking too often.",
+		"upstream")
+)
+
+var registry = make([]*Vec, 0)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// A Vec is a family of metrics with the same name, one for each combination of label values.
+type Vec struct {
+	name   string
+	typ    string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by rendered label values
+	counts map[string]uint64  // for a summary only
+}
+
+func newVec(name string, typ string, help string, labels ...string) *Vec {
+	v := &Vec{name: name, typ: typ, help: help, labels: labels, values: make(map[string]float64), counts: make(map[string]uint64)}
+	registry = append(registry, v)
+	return v
+}
+
+// Set a gauge.
+func (v *Vec) Set(value float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	v.values[key] = value
+	v.mu.Unlock()
+}
+
+// Add to a counter.
+func (v *Vec) Add(value float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	v.values[key] += value
+	v.mu.Unlock()
+}
+
+// Add an observation to a summary.
+func (v *Vec) Observe(value float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	v.values[key] += value
+	v.counts[key]++
+	v.mu.Unlock()
+}
+
+// Set every gauge in the family to zero.  Do this before setting the current values, so that a series that is no
+// longer interesting says so instead of repeating its last value forever.
+func (v *Vec) Zero() {
+	v.mu.Lock()
+	for key := range v.values {
+		v.values[key] = 0
+	}
+	v.mu.Unlock()
+}
+
+// Render the label values as Prometheus wants to see them, such as {upstream="okex",status="200"}.
+func (v *Vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		// This is a programming error.
+		panic(fmt.Sprintf("metrics: %s wants %d label values, received %d", v.name, len(v.labels), len(labelValues)))
+	}
+	if len(v.labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(v.labels))
+	for i, l := range v.labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, l, labelEscaper.Replace(labelValues[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *Vec) write(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(b, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", v.name, v.typ)
+	for _, key := range keys {
+		if v.typ == "summary" {
+			fmt.Fprintf(b, "%s_sum%s %g\n", v.name, key, v.values[key])
+			fmt.Fprintf(b, "%s_count%s %d\n", v.name, key, v.counts[key])
+		} else {
+			fmt.Fprintf(b, "%s%s %g\n", v.name, key, v.values[key])
+		}
+	}
+}
+
+// Serve the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := &strings.Builder{}
+		for _, v := range registry {
+			v.write(b)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
+
+// Serve the metrics at /metrics on the given address, such as :9100, in the background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			log.WithError(err).WithField("addr", addr).Error("Cannot serve the metrics.")
+		}
+	}()
+
+	log.WithField("addr", addr).Info("Serving the metrics at /metrics.")
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	gauge := newVec("test_gauge", "gauge", "A gauge.", "currency")
+	counter := newVec("test_counter_total", "counter", "A counter.", "upstream", "status")
+	summary := newVec("test_duration_seconds", "summary", "A summary.", "upstream")
+	plain := newVec("test_timestamp_seconds", "gauge", "A gauge without labels.")
+	defer func() { registry = registry[:len(registry)-4] }()
+
+	gauge.Set(2, "USDT")
+	gauge.Set(0.5, `B"T\C`)
+	gauge.Set(7, "ETH")
+	gauge.Zero()
+	gauge.Set(1.25, "BTC")
+	counter.Add(1, "okex", "200")
+	counter.Add(2, "okex", "200")
+	counter.Add(1, "bookwerx", "500")
+	summary.Observe(0.25, "okex")
+	summary.Observe(0.5, "okex")
+	plain.Set(1600000000)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	body, _ := ioutil.ReadAll(w.Body)
+
+	want := `# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge{currency="BTC"} 1.25
+test_gauge{currency="B\"T\\C"} 0
+test_gauge{currency="ETH"} 0
+test_gauge{currency="USDT"} 0
+# HELP test_counter_total A counter.
+# TYPE test_counter_total counter
+test_counter_total{upstream="bookwerx",status="500"} 1
+test_counter_total{upstream="okex",status="200"} 3
+# HELP test_duration_seconds A summary.
+# TYPE test_duration_seconds summary
+test_duration_seconds_sum{upstream="okex"} 0.75
+test_duration_seconds_count{upstream="okex"} 2
+# HELP test_timestamp_seconds A gauge without labels.
+# TYPE test_timestamp_seconds gauge
+test_timestamp_seconds 1.6e+09
+`
+	if !strings.HasSuffix(string(body), want) {
+		t.Errorf("metrics =\n%s\nwant them to end with\n%s", body, want)
+	}
+}
+
+func TestKeyLabelCount(t *testing.T) {
+	v := &Vec{name: "test_labels", labels: []string{"upstream", "endpoint"}}
+	if got := v.key([]string{"okex", "/api"}); got != `{upstream="okex",endpoint="/api"}` {
+		t.Errorf("key = %s", got)
+	}
+
+	for _, values := range [][]string{nil, {"okex"}, {"okex", "/api", "200"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("key(%q) didn't panic", values)
+				}
+			}()
+			v.key(values)
+		}()
+	}
+}
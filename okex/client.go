@@ -0,0 +1,158 @@
+// The purpose of this package is to hold items required to communicate with an OKEx/OKCatbox server.
+package okex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/journal"
+	"github.com/bostontrader/okconnect/metrics"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OKEx rejects any signed request with this error code when the OK-ACCESS-TIMESTAMP is too far from the server's clock.
+const errCodeTimestampExpired = 30008
+
+// OKEx refuses any request with this error code, or with a 429, when we have been asking too often.
+const errCodeTooFrequent = 30014
+
+// OKEx refuses to talk about an instrument with this error code when there is no such currency pair.
+const ErrCodeNoSuchInstrument = 30032
+
+// How long to wait before asking again.  OKEx limits most endpoints to some number of requests per 2s.
+const rateLimitWait = 2 * time.Second
+
+// A Client knows how to make signed requests to an OKEx server.
+type Client struct {
+	BaseURL     string
+	Credentials utils.Credentials
+	HTTPClient  *http.Client
+
+	// Remember every state-changing request here.  See Submit.
+	Journal *journal.Journal
+
+	// The server's clock minus our clock.  Add this to the local time in order to get an OK-ACCESS-TIMESTAMP that the
+	// server will accept.
+	offset time.Duration
+	mu     sync.Mutex
+}
+
+// Build a new Client and sync its clock with the server.  If the clock cannot be synced we carry on using the local
+// clock, because the server (such as an OKCatbox) might not care.
+func NewClient(cfg config.Config, credentials utils.Credentials) *Client {
+	c := &Client{
+		BaseURL:     cfg.OKExConfig.BaseURL,
+		Credentials: credentials,
+		HTTPClient:  okchttp.GetHTTPClient("okex", cfg.OKExConfig.BaseURL),
+	}
+
+	err := c.SyncTime()
+	if err != nil {
+		log.WithError(err).Warn("Cannot sync with the OKEx server time, using the local clock instead.")
+	}
+
+	return c
+}
+
+// Make a signed request to the given endpoint and return the body of a successful response.
+// If the server complains that our timestamp has expired, then our clock has drifted. Re-sync and try again, once.
+// If the server complains that we're asking too often, then wait a bit and try again, once.
+func (c *Client) Do(method string, endpoint string, reqBody string) ([]byte, error) {
+	body, err := c.do(method, endpoint, reqBody)
+	if err != nil {
+		var okErr *Error
+		if errors.As(err, &okErr) && okErr.Code == errCodeTimestampExpired {
+			log.WithField("endpoint", endpoint).Warn("The request timestamp expired, re-syncing with the OKEx server time.")
+			err = c.SyncTime()
+			if err != nil {
+				return nil, err
+			}
+			return c.do(method, endpoint, reqBody)
+		}
+		if errors.As(err, &okErr) && (okErr.StatusCode == http.StatusTooManyRequests || okErr.Code == errCodeTooFrequent) {
+			log.WithFields(log.Fields{"endpoint": endpoint, "wait": rateLimitWait.String()}).Warn("OKEx says we are asking too often, waiting.")
+			metrics.RateLimitWaits.Add(1, "okex")
+			metrics.RateLimitWaitSeconds.Add(rateLimitWait.Seconds(), "okex")
+			time.Sleep(rateLimitWait)
+			return c.do(method, endpoint, reqBody)
+		}
+	}
+	return body, err
+}
+
+func (c *Client) do(method string, endpoint string, reqBody string) ([]byte, error) {
+	url := c.BaseURL + endpoint
+	timestamp := c.Timestamp()
+	prehash := timestamp + method + endpoint + reqBody
+	encoded, _ := utils.HmacSha256Base64Signer(prehash, c.Credentials.SecretKey)
+
+	req, err := http.NewRequest(method, url, strings.NewReader(reqBody))
+	if err != nil {
+		log.WithError(err).WithField("endpoint", endpoint).Error("Cannot build the OKEx request.")
+		return nil, err
+	}
+
+	if reqBody != "" {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("OK-ACCESS-KEY", c.Credentials.Key)
+	req.Header.Add("OK-ACCESS-SIGN", encoded)
+	req.Header.Add("OK-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Add("OK-ACCESS-PASSPHRASE", c.Credentials.Passphrase)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.WithError(err).WithField("endpoint", endpoint).Error("Cannot read the OKEx response.")
+		return nil, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		log.WithFields(log.Fields{"endpoint": endpoint, "status": resp.StatusCode, "body": string(body)}).Warn("OKEx did not like the request.")
+		return nil, newError(resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// An Error is a non-200 response from the server.  OKEx usually explains itself using an utils.OKError in the body.
+type Error struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("okex: status code error: received=%d, code=%d, message=%s", e.StatusCode, e.Code, e.Message)
+}
+
+func newError(statusCode int, body []byte) *Error {
+	e := &Error{StatusCode: statusCode, Message: string(body)}
+	okErr := utils.OKError{}
+
+	// Ignore any decoding error.  Whatever fields we got are better than none.
+	_ = json.NewDecoder(bytes.NewReader(body)).Decode(&okErr)
+	e.Code = okErr.Code
+	if e.Code == 0 {
+		e.Code, _ = strconv.Atoi(okErr.ErrorCode)
+	}
+	if okErr.Message != "" {
+		e.Message = okErr.Message
+	}
+	return e
+}
@@ -0,0 +1,93 @@
+package okex
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+// A futures or perpetual swap account.  OKEx keeps one for each underlying, such as BTC-USD, of the futures and one
+// for each instrument, such as BTC-USD-SWAP, of the swaps.
+type DerivativesAccount struct {
+	InstrumentID  string   // the swap instrument, or the futures underlying
+	Currency      string   // of the margin
+	MarginMode    string   // crossed or fixed
+	Equity        string   // the balance plus the unrealised PnL
+	UnrealisedPNL []string // of the whole account, or of each contract if OKEx gives it to us that way
+}
+
+// The fields of a futures or swap account that we care about.
+type derivativesAccount struct {
+	InstrumentID  string `json:"instrument_id"`
+	Underlying    string `json:"underlying"`
+	Currency      string `json:"currency"`
+	MarginMode    string `json:"margin_mode"`
+	Equity        string `json:"equity"`
+	UnrealizedPNL string `json:"unrealized_pnl"`
+	Contracts     []struct {
+		UnrealizedPNL string `json:"unrealized_pnl"`
+	} `json:"contracts"`
+}
+
+// Make the API call to get all perpetual swap accounts from OKEx.
+func (c *Client) SwapAccounts() ([]DerivativesAccount, error) {
+	body, err := c.Do("GET", "/api/swap/v3/accounts", "")
+	if err != nil {
+		return nil, err
+	}
+
+	raw := struct {
+		Info []derivativesAccount `json:"info"`
+	}{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&raw)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the OKEx swap accounts.")
+		return nil, err
+	}
+
+	retVal := make([]DerivativesAccount, 0, len(raw.Info))
+	for _, a := range raw.Info {
+		retVal = append(retVal, a.toDerivativesAccount(a.InstrumentID))
+	}
+	return retVal, nil
+}
+
+// Make the API call to get all futures accounts from OKEx.
+func (c *Client) FuturesAccounts() ([]DerivativesAccount, error) {
+	body, err := c.Do("GET", "/api/futures/v3/accounts", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// OKEx gives us the accounts by underlying, such as "btc-usd", instead of a list.
+	raw := struct {
+		Info map[string]derivativesAccount `json:"info"`
+	}{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&raw)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the OKEx futures accounts.")
+		return nil, err
+	}
+
+	retVal := make([]DerivativesAccount, 0, len(raw.Info))
+	for underlying, a := range raw.Info {
+		if a.Underlying != "" {
+			underlying = a.Underlying
+		}
+		retVal = append(retVal, a.toDerivativesAccount(underlying))
+	}
+	return retVal, nil
+}
+
+// In the crossed margin mode OKEx gives us the unrealised PnL of the whole account, but in the fixed mode only that
+// of each contract.
+func (a derivativesAccount) toDerivativesAccount(instrumentID string) DerivativesAccount {
+	upnl := []string{a.UnrealizedPNL}
+	if a.UnrealizedPNL == "" {
+		upnl = make([]string, 0, len(a.Contracts))
+		for _, contract := range a.Contracts {
+			upnl = append(upnl, contract.UnrealizedPNL)
+		}
+	}
+	return DerivativesAccount{instrumentID, a.Currency, a.MarginMode, a.Equity, upnl}
+}
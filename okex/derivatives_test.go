@@ -0,0 +1,59 @@
+package okex
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDerivativesAccounts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/swap/v3/accounts":
+			fmt.Fprint(w, `{"info":[
+				{"instrument_id":"BTC-USD-SWAP","currency":"BTC","margin_mode":"crossed","equity":"1.5","unrealized_pnl":"0.1"},
+				{"instrument_id":"ETH-USDT-SWAP","currency":"USDT","margin_mode":"fixed","equity":"100","unrealized_pnl":""}
+			]}`)
+		case "/api/futures/v3/accounts":
+			fmt.Fprint(w, `{"info":{
+				"btc-usd":{"currency":"BTC","margin_mode":"crossed","equity":"2","unrealized_pnl":"-0.2"},
+				"eth-usd":{"underlying":"ETH-USD","currency":"ETH","margin_mode":"fixed","equity":"10",
+					"contracts":[{"unrealized_pnl":"0.3"},{"unrealized_pnl":"-0.1"}]}
+			}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	swaps, err := client.SwapAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DerivativesAccount{
+		{"BTC-USD-SWAP", "BTC", "crossed", "1.5", []string{"0.1"}},
+		{"ETH-USDT-SWAP", "USDT", "fixed", "100", []string{}},
+	}
+	if !reflect.DeepEqual(swaps, want) {
+		t.Errorf("SwapAccounts = %+v, want %+v", swaps, want)
+	}
+
+	// The crossed account has the unrealised PnL of the whole account, and the fixed one has only that of each
+	// contract.  An account named by its key has no underlying of its own.
+	futures, err := client.FuturesAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(futures, func(i, j int) bool { return futures[i].InstrumentID < futures[j].InstrumentID })
+	want = []DerivativesAccount{
+		{"ETH-USD", "ETH", "fixed", "10", []string{"0.3", "-0.1"}},
+		{"btc-usd", "BTC", "crossed", "2", []string{"-0.2"}},
+	}
+	if !reflect.DeepEqual(futures, want) {
+		t.Errorf("FuturesAccounts = %+v, want %+v", futures, want)
+	}
+}
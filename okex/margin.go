@@ -0,0 +1,59 @@
+package okex
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// The balances of a single currency in a margin account.
+type MarginBalance struct {
+	Available  string `json:"available"`
+	Hold       string `json:"hold"`
+	Borrowed   string `json:"borrowed"`
+	LendingFee string `json:"lending_fee"` // the interest accrued on what's borrowed
+}
+
+// A margin account.  OKEx keeps one for each instrument, with the balances of both of its currencies.
+type MarginAccount struct {
+	InstrumentID string
+	Balances     map[string]MarginBalance // by currency
+}
+
+// Make the API call to get all margin accounts from OKEx.
+func (c *Client) MarginAccounts() ([]MarginAccount, error) {
+	body, err := c.Do("GET", "/api/margin/v3/accounts", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// OKEx says "currency:BTC": {...} instead of giving us a list of currencies.
+	raw := make([]map[string]json.RawMessage, 0)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&raw)
+	if err != nil {
+		log.WithError(err).Error("Cannot decode the OKEx margin accounts.")
+		return nil, err
+	}
+
+	retVal := make([]MarginAccount, 0, len(raw))
+	for _, r := range raw {
+		a := MarginAccount{Balances: make(map[string]MarginBalance)}
+		_ = json.Unmarshal(r["instrument_id"], &a.InstrumentID)
+		for key, value := range r {
+			if !strings.HasPrefix(key, "currency:") {
+				continue
+			}
+			b := MarginBalance{}
+			err = json.Unmarshal(value, &b)
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{"instrument_id": a.InstrumentID, "key": key}).Error("Cannot decode the OKEx margin balance.")
+				return nil, err
+			}
+			a.Balances[strings.TrimPrefix(key, "currency:")] = b
+		}
+		retVal = append(retVal, a)
+	}
+
+	return retVal, nil
+}
@@ -0,0 +1,132 @@
+package okex
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/journal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+)
+
+// How many times shall we re-submit a request whose outcome we know for certain did not happen?
+const maxSubmitAttempts = 3
+
+// OKEx wants a client_oid to start with a letter and contain at most 32 letters and digits.
+func NewClientOID() string {
+	b := make([]byte, 14)
+	_, err := rand.Read(b)
+	if err != nil {
+		// This should never happen.
+		panic(err)
+	}
+	return "okc" + hex.EncodeToString(b)
+}
+
+var clientOIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{1,31}$`)
+
+// Is this a client_oid that OKEx would accept, such as one given by the user in order to continue an earlier request?
+func ValidClientOID(clientOID string) bool {
+	return clientOIDPattern.MatchString(clientOID)
+}
+
+// A LookupFunc asks OKEx whether or not the request with the given client_oid has already happened.  If so, return the
+// body that describes it and true.  If OKEx is certain that there is no such request return false.  If we cannot tell,
+// return an error.
+type LookupFunc func(client *Client, clientOID string) ([]byte, bool, error)
+
+// ErrOutcomeUnknown means that a request might or might not have happened and we could not find out which.  The
+// journal remembers the request so that a later run can try to find out again.
+var ErrOutcomeUnknown = errors.New("okex: the outcome of the request is unknown")
+
+// Submit a state-changing POST request, identified by the given client_oid, at most once.
+//
+// The request is recorded in the client's journal before it's sent.  If the outcome of sending it is uncertain, such
+// as after a network error or a 5xx response, then we use lookup to ask OKEx whether the request happened before we
+// dare to send it again.
+//
+// If the journal says that this client_oid has already been done, then don't send anything and just return what
+// we got the first time.
+func (c *Client) Submit(kind string, endpoint string, clientOID string, reqBody string, lookup LookupFunc) ([]byte, error) {
+	if c.Journal == nil {
+		return nil, errors.New("okex:submit.go:Submit: The client has no journal")
+	}
+
+	entry, ok := c.Journal.Get(clientOID)
+	if ok {
+		switch entry.State {
+		case journal.StateDone, journal.StateBooked:
+			log.WithField("client_oid", clientOID).Info("The request has already been done.")
+			return []byte(entry.Response), nil
+		case journal.StateFailed:
+			return nil, errors.New(fmt.Sprintf("okex:submit.go:Submit: The request %s has already failed: %s", clientOID, entry.Response))
+		}
+		// Else it's pending so we must find out what happened before doing anything else.
+		if entry.Request != reqBody {
+			return nil, errors.New(fmt.Sprintf("okex:submit.go:Submit: The request %s is pending with a different body: %s", clientOID, entry.Request))
+		}
+		body, err := c.resolve(entry, lookup)
+		if err != nil || body != nil {
+			return body, err
+		}
+	} else {
+		entry = journal.Entry{ClientOID: clientOID, Kind: kind, Endpoint: endpoint, Request: reqBody, State: journal.StatePending}
+		err := c.Journal.Record(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		body, err := c.Do("POST", endpoint, reqBody)
+		if err == nil {
+			entry.State = journal.StateDone
+			entry.Response = string(body)
+			return body, c.Journal.Record(entry)
+		}
+
+		var okErr *Error
+		if errors.As(err, &okErr) && okErr.StatusCode < http.StatusInternalServerError {
+			// OKEx has definitely refused.
+			entry.State = journal.StateFailed
+			entry.Response = okErr.Error()
+			_ = c.Journal.Record(entry)
+			return nil, err
+		}
+
+		log.WithError(err).WithFields(log.Fields{"client_oid": clientOID, "attempt": attempt}).Warn("The outcome of the request is uncertain.")
+		if attempt >= maxSubmitAttempts {
+			return nil, ErrOutcomeUnknown
+		}
+
+		body, err = c.resolve(entry, lookup)
+		if err != nil || body != nil {
+			return body, err
+		}
+	}
+}
+
+// Ask OKEx about a pending entry.  If it has happened, record that and return its body.  If it certainly has not
+// happened, return nil, nil so that the caller may try again.
+func (c *Client) resolve(entry journal.Entry, lookup LookupFunc) ([]byte, error) {
+	if lookup == nil {
+		log.WithField("client_oid", entry.ClientOID).Error("There is no way to ask OKEx about the request.")
+		return nil, ErrOutcomeUnknown
+	}
+
+	body, found, err := lookup(c, entry.ClientOID)
+	if err != nil {
+		log.WithError(err).WithField("client_oid", entry.ClientOID).Error("Cannot ask OKEx about the request.")
+		return nil, ErrOutcomeUnknown
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	entry.State = journal.StateDone
+	entry.Response = string(body)
+	return body, c.Journal.Record(entry)
+}
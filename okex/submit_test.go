@@ -0,0 +1,127 @@
+package okex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bostontrader/okconnect/journal"
+)
+
+const transferEndpoint = "/api/account/v3/transfer"
+
+// An OKEx that answers each POST with the next of the given status codes, and then 200.
+func newSubmitServer(t *testing.T, statuses ...int) (*Client, *int) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		if posts <= len(statuses) {
+			w.WriteHeader(statuses[posts-1])
+			fmt.Fprint(w, `{"code":30001,"message":"nope"}`)
+			return
+		}
+		fmt.Fprint(w, `{"transfer_id":"754147","result":true}`)
+	}))
+	t.Cleanup(server.Close)
+
+	j, err := journal.Open(filepath.Join(t.TempDir(), "okconnect.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{BaseURL: server.URL, HTTPClient: server.Client(), Journal: j}, &posts
+}
+
+func TestSubmit(t *testing.T) {
+	found := func(client *Client, clientOID string) ([]byte, bool, error) {
+		return []byte(`{"transfer_id":"754147","found":true}`), true, nil
+	}
+	notFound := func(client *Client, clientOID string) ([]byte, bool, error) { return nil, false, nil }
+	cannotTell := func(client *Client, clientOID string) ([]byte, bool, error) {
+		return nil, false, errors.New("cannot tell")
+	}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		lookup   LookupFunc
+		wantBody string
+		wantErr  bool
+		state    string
+		posts    int
+	}{
+		{"ok", nil, nil, `{"transfer_id":"754147","result":true}`, false, journal.StateDone, 1},
+		{"refused", []int{http.StatusBadRequest}, found, "", true, journal.StateFailed, 1},
+		{"uncertain, but it happened", []int{http.StatusInternalServerError}, found, `{"transfer_id":"754147","found":true}`, false, journal.StateDone, 1},
+		{"uncertain, and it did not happen", []int{http.StatusBadGateway}, notFound, `{"transfer_id":"754147","result":true}`, false, journal.StateDone, 2},
+		{"uncertain, and nobody can tell", []int{http.StatusInternalServerError}, cannotTell, "", true, journal.StatePending, 1},
+		{"uncertain, with no lookup", []int{http.StatusInternalServerError}, nil, "", true, journal.StatePending, 1},
+		{"never certain", []int{500, 500, 500}, notFound, "", true, journal.StatePending, 3},
+	}
+	for _, tt := range tests {
+		client, posts := newSubmitServer(t, tt.statuses...)
+		body, err := client.Submit("transfer", transferEndpoint, "okc1", "{}", tt.lookup)
+		if string(body) != tt.wantBody || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Submit = %s, %v", tt.name, body, err)
+		}
+		if e, _ := client.Journal.Get("okc1"); e.State != tt.state {
+			t.Errorf("%s: state = %s, want %s", tt.name, e.State, tt.state)
+		}
+		if *posts != tt.posts {
+			t.Errorf("%s: %d POSTs, want %d", tt.name, *posts, tt.posts)
+		}
+	}
+}
+
+func TestSubmitAgain(t *testing.T) {
+	client, posts := newSubmitServer(t, http.StatusInternalServerError)
+
+	// The outcome is unknown, so it's pending.  Running it again asks OKEx before sending it again.
+	_, err := client.Submit("transfer", transferEndpoint, "okc1", "{}", nil)
+	if err != ErrOutcomeUnknown {
+		t.Fatalf("Submit = %v, want %v", err, ErrOutcomeUnknown)
+	}
+	asked := 0
+	lookup := func(client *Client, clientOID string) ([]byte, bool, error) {
+		asked++
+		return nil, false, nil
+	}
+	if _, err := client.Submit("transfer", transferEndpoint, "okc1", `{"different":true}`, lookup); err == nil {
+		t.Errorf("Submit with a different body = nil, want an error")
+	}
+	body, err := client.Submit("transfer", transferEndpoint, "okc1", "{}", lookup)
+	if err != nil || asked != 1 || *posts != 2 {
+		t.Errorf("Submit = %s, %v after asking %d times and %d POSTs", body, err, asked, *posts)
+	}
+
+	// Once it's done, it's never sent again.
+	again, err := client.Submit("transfer", transferEndpoint, "okc1", "{}", lookup)
+	if err != nil || string(again) != string(body) || *posts != 2 {
+		t.Errorf("Submit once done = %s, %v after %d POSTs", again, err, *posts)
+	}
+}
+
+func TestValidClientOID(t *testing.T) {
+	tests := []struct {
+		clientOID string
+		want      bool
+	}{
+		{NewClientOID(), true},
+		{"a1", true},
+		{"A0123456789012345678901234567890", true},
+		{"a", false},
+		{"A01234567890123456789012345678901", false},
+		{"1abc", false},
+		{"okc_123", false},
+		{"okc%", false},
+		{"okc' OR '1'='1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidClientOID(tt.clientOID); got != tt.want {
+			t.Errorf("ValidClientOID(%q) = %v, want %v", tt.clientOID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package okex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"time"
+)
+
+// OKEx rejects requests whose OK-ACCESS-TIMESTAMP is more than 30s away from its own clock.  Complain well before then.
+const skewWarning = 5 * time.Second
+
+// The response from /api/general/v3/time
+type ServerTime struct {
+	ISO   string `json:"iso"`
+	Epoch string `json:"epoch"`
+}
+
+// Return an OK-ACCESS-TIMESTAMP for right now, according to the server's clock.  It's also the time of whatever we
+// book in Bookwerx, so it always has exactly three decimal places, just as Bookwerx wants.
+func (c *Client) Timestamp() string {
+	c.mu.Lock()
+	offset := c.offset
+	c.mu.Unlock()
+	return time.Now().Add(offset).UTC().Format(bookwerx.TimeFormat)
+}
+
+// Ask the server what time it is and remember the difference between that and our own clock.
+// The server's time is assumed to be read halfway through the round trip.
+func (c *Client) SyncTime() error {
+	endpoint := "/api/general/v3/time"
+
+	before := time.Now()
+	resp, err := c.HTTPClient.Get(c.BaseURL + endpoint)
+	if err != nil {
+		return err
+	}
+	after := time.Now()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return errors.New(fmt.Sprintf("okex:time.go:SyncTime: Status code error: expected= 200, received=%d, body=%s", resp.StatusCode, string(body)))
+	}
+
+	serverTime := ServerTime{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&serverTime)
+	if err != nil {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, serverTime.ISO)
+	if err != nil {
+		return err
+	}
+
+	local := before.Add(after.Sub(before) / 2)
+	offset := t.Sub(local)
+
+	if offset > skewWarning || offset < -skewWarning {
+		log.WithField("offset", offset.String()).Warn("The local clock is far away from the OKEx server time. Timestamps will be corrected, but you should fix the local clock.")
+	}
+
+	c.mu.Lock()
+	c.offset = offset
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Return the most recently measured difference between the server's clock and ours.
+func (c *Client) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
@@ -0,0 +1,116 @@
+package okex
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestTimestamp(t *testing.T) {
+	format := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z$`)
+	c := &Client{}
+	for i := 0; i < 100; i++ {
+		if ts := c.Timestamp(); !format.MatchString(ts) {
+			t.Fatalf("Timestamp() = %s, want exactly three decimal places", ts)
+		}
+	}
+}
+
+// An OKEx whose clock is off from ours by the given skew.  It refuses any signed request whose timestamp is more than
+// a second from its own clock, and counts the requests to each endpoint.
+func newClockServer(t *testing.T, skew time.Duration, timeStatus int) (*Client, map[string]int) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		now := time.Now().Add(skew)
+		if r.URL.Path == "/api/general/v3/time" {
+			w.WriteHeader(timeStatus)
+			fmt.Fprintf(w, `{"iso":"%s","epoch":"%d.000"}`, now.UTC().Format(time.RFC3339Nano), now.Unix())
+			return
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, r.Header.Get("OK-ACCESS-TIMESTAMP"))
+		if err != nil || ts.Sub(now) > time.Second || now.Sub(ts) > time.Second {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":30008,"message":"Request timestamp expired"}`)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	t.Cleanup(server.Close)
+	return &Client{BaseURL: server.URL, HTTPClient: server.Client()}, requests
+}
+
+func TestSyncTime(t *testing.T) {
+	tests := []struct {
+		skew time.Duration
+		warn bool
+	}{
+		{20 * time.Second, true},
+		{-10 * time.Second, true},
+		{time.Second, false},
+		{-2 * time.Second, false},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		c, _ := newClockServer(t, tt.skew, http.StatusOK)
+		err := c.SyncTime()
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Errorf("%s: SyncTime = %v", tt.skew, err)
+			continue
+		}
+
+		if d := c.Offset() - tt.skew; d > 100*time.Millisecond || d < -100*time.Millisecond {
+			t.Errorf("%s: offset = %s", tt.skew, c.Offset())
+		}
+		if warned := strings.Contains(buf.String(), "far away from the OKEx server time"); warned != tt.warn {
+			t.Errorf("%s: warned = %v, want %v: %s", tt.skew, warned, tt.warn, buf.String())
+		}
+	}
+
+	c, _ := newClockServer(t, 0, http.StatusInternalServerError)
+	if err := c.SyncTime(); err == nil {
+		t.Errorf("SyncTime with a broken server = nil, want an error")
+	}
+}
+
+func TestDoResync(t *testing.T) {
+	// Our clock is 20s behind, but we don't know that yet.  The first request expires, and the second, after a
+	// resync, is accepted.
+	c, requests := newClockServer(t, 20*time.Second, http.StatusOK)
+	body, err := c.Do("GET", "/api/spot/v3/accounts", "")
+	if err != nil || string(body) != "[]" {
+		t.Fatalf("Do = %s, %v", body, err)
+	}
+	if requests["/api/general/v3/time"] != 1 || requests["/api/spot/v3/accounts"] != 2 {
+		t.Errorf("requests = %v, want one resync and one retry", requests)
+	}
+
+	// Now that we know, there's no need to resync again.
+	_, err = c.Do("GET", "/api/spot/v3/accounts", "")
+	if err != nil || requests["/api/general/v3/time"] != 1 || requests["/api/spot/v3/accounts"] != 3 {
+		t.Errorf("Do again = %v, requests = %v", err, requests)
+	}
+
+	// If the resync fails, then so does the request, without a retry.
+	c, requests = newClockServer(t, 20*time.Second, http.StatusInternalServerError)
+	_, err = c.Do("GET", "/api/spot/v3/accounts", "")
+	if err == nil || requests["/api/spot/v3/accounts"] != 1 {
+		t.Errorf("Do with a broken clock = %v, requests = %v", err, requests)
+	}
+}
@@ -0,0 +1,302 @@
+package okex
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OKEx pushes a WebSocket message whenever something happens to our orders or balances.  This is much quicker than
+// polling, but a connection can drop at any time and whatever happens meanwhile is lost.  So an Event is merely a hint
+// that we should go look, using the REST API, and not a substitute for doing so.
+const (
+	EventOrderPlaced    = "order-placed"
+	EventOrderFilled    = "order-filled"
+	EventOrderCancelled = "order-cancelled"
+	EventBalanceChanged = "balance-changed"
+	EventConnected      = "connected" // We have just (re)subscribed and might have missed something before that.
+)
+
+// OKEx drops a connection that has been quiet for 30s, so say something more often than that.
+const wsPingInterval = 20 * time.Second
+
+// Wait at most this long before trying to reconnect.
+const wsMaxBackoff = time.Minute
+
+type Event struct {
+	Kind         string
+	Channel      string // spot/order:BTC-USDT, spot/account:BTC, etc.
+	InstrumentID string `json:",omitempty"`
+	Currency     string `json:",omitempty"`
+	OrderID      string `json:",omitempty"`
+	Data         json.RawMessage
+}
+
+// A Stream is a private WebSocket subscription to the account and order channels for the configured instruments.
+type Stream struct {
+	URL      string
+	Client   *Client // for the credentials and the server's clock
+	Channels []string
+}
+
+// What OKEx sends us, other than "pong".
+type wsMessage struct {
+	Event     string            `json:"event"`
+	Success   bool              `json:"success"`
+	Channel   string            `json:"channel"`
+	Message   string            `json:"message"`
+	ErrorCode int               `json:"errorCode"`
+	Table     string            `json:"table"`
+	Data      []json.RawMessage `json:"data"`
+}
+
+type wsOrder struct {
+	InstrumentID string `json:"instrument_id"`
+	OrderID      string `json:"order_id"`
+	State        string `json:"state"`
+}
+
+type wsAccount struct {
+	Currency string `json:"currency"`
+}
+
+type wsRequest struct {
+	Op   string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+// Build a new Stream that subscribes to spot/order for each configured instrument and to spot/account for each
+// currency that those instruments use.
+func NewStream(cfg config.Config, client *Client) *Stream {
+	s := &Stream{URL: cfg.OKExConfig.WSURL, Client: client}
+
+	currencies := make(map[string]bool)
+	for _, instrument := range cfg.OKExConfig.Instruments {
+		s.Channels = append(s.Channels, "spot/order:"+instrument)
+		for _, currency := range strings.Split(instrument, "-") {
+			if !currencies[currency] {
+				currencies[currency] = true
+				s.Channels = append(s.Channels, "spot/account:"+currency)
+			}
+		}
+	}
+
+	return s
+}
+
+// Stay subscribed, reconnecting as necessary, and send every event to the given channel until ctx is cancelled.
+func (s *Stream) Run(ctx context.Context, events chan<- Event) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := s.session(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// If the connection was good for a while then this is just an ordinary drop, so try again right away-ish.
+		if time.Since(start) > wsMaxBackoff {
+			backoff = time.Second
+		}
+		log.WithError(err).WithField("retry_in", backoff.String()).Warn("The OKEx WebSocket connection is gone.")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > wsMaxBackoff {
+			backoff = wsMaxBackoff
+		}
+	}
+}
+
+// Connect, login, subscribe, and read events until the connection fails or ctx is cancelled.
+func (s *Stream) session(ctx context.Context, events chan<- Event) error {
+
+	// 1. Connect
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Reading blocks, so close the connection in order to stop.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	var writeMu sync.Mutex
+	write := func(data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(wsPingInterval))
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+
+	// 2. Login, using the same signature scheme as the REST API.
+	timestamp := fmt.Sprintf("%.3f", float64(time.Now().Add(s.Client.Offset()).UnixNano())/1e9)
+	sign, _ := utils.HmacSha256Base64Signer(timestamp+"GET/users/self/verify", s.Client.Credentials.SecretKey)
+	login, _ := json.Marshal(wsRequest{"login", []string{s.Client.Credentials.Key, s.Client.Credentials.Passphrase, timestamp, sign}})
+	err = write(login)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.read(conn)
+	if err != nil {
+		return err
+	}
+	if msg.Event != "login" || !msg.Success {
+		return errors.New(fmt.Sprintf("okex:websocket.go:session: Cannot login: code=%d, message=%s", msg.ErrorCode, msg.Message))
+	}
+
+	// 3. Subscribe
+	subscribe, _ := json.Marshal(wsRequest{"subscribe", s.Channels})
+	err = write(subscribe)
+	if err != nil {
+		return err
+	}
+	log.WithField("channels", strings.Join(s.Channels, ",")).Info("Subscribed to the OKEx WebSocket.")
+	if !send(ctx, events, Event{Kind: EventConnected}) {
+		return ctx.Err()
+	}
+
+	// 4. Keep the connection alive.
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if write([]byte("ping")) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// 5. Read events until something breaks.
+	for {
+		msg, err := s.read(conn)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case msg.Event == "error":
+			log.WithFields(log.Fields{"code": msg.ErrorCode, "message": msg.Message}).Error("OKEx did not like the WebSocket request.")
+		case msg.Event == "subscribe":
+			log.WithField("channel", msg.Channel).Debug("Subscribed.")
+		case msg.Table != "":
+			for _, data := range msg.Data {
+				e, ok := toEvent(msg.Table, data)
+				if ok && !send(ctx, events, e) {
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// Send the event unless we're told to stop first.
+func send(ctx context.Context, events chan<- Event, e Event) bool {
+	select {
+	case events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Read the next message, other than a pong.  OKEx deflates its messages but an OKCatbox might not bother.
+func (s *Stream) read(conn *websocket.Conn) (wsMessage, error) {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return wsMessage{}, err
+		}
+
+		if messageType == websocket.BinaryMessage {
+			// OKEx doesn't always bother to finish the deflate stream either.
+			data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+			if err != nil && !(err == io.ErrUnexpectedEOF && len(data) > 0) {
+				log.WithError(err).Error("Cannot inflate the OKEx WebSocket message.")
+				return wsMessage{}, err
+			}
+		}
+
+		if string(data) == "pong" {
+			continue
+		}
+		log.WithField("message", string(data)).Trace("OKEx WebSocket message.")
+
+		msg := wsMessage{}
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			log.WithError(err).WithField("message", string(data)).Error("Cannot decode the OKEx WebSocket message.")
+			return wsMessage{}, err
+		}
+		return msg, nil
+	}
+}
+
+// Figure out what a single item of pushed data means.
+func toEvent(table string, data json.RawMessage) (Event, bool) {
+	switch table {
+	case "spot/order":
+		o := wsOrder{}
+		err := json.Unmarshal(data, &o)
+		if err != nil {
+			log.WithError(err).Error("Cannot decode the OKEx order.")
+			return Event{}, false
+		}
+		e := Event{Channel: table + ":" + o.InstrumentID, InstrumentID: o.InstrumentID, OrderID: o.OrderID, Data: data}
+		switch o.State {
+		case "0":
+			e.Kind = EventOrderPlaced
+		case "1", "2":
+			e.Kind = EventOrderFilled
+		case "-1":
+			e.Kind = EventOrderCancelled
+		default:
+			// Submitting, cancelling, or failed.  Wait until it settles.
+			return Event{}, false
+		}
+		return e, true
+
+	case "spot/account":
+		a := wsAccount{}
+		err := json.Unmarshal(data, &a)
+		if err != nil {
+			log.WithError(err).Error("Cannot decode the OKEx account.")
+			return Event{}, false
+		}
+		return Event{Kind: EventBalanceChanged, Channel: table + ":" + a.Currency, Currency: a.Currency, Data: data}, true
+	}
+
+	return Event{}, false
+}
@@ -0,0 +1,184 @@
+package okex
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/gorilla/websocket"
+)
+
+func TestNewStream(t *testing.T) {
+	cfg := config.Config{OKExConfig: config.OKExConfig{WSURL: "wss://example.com/ws/v3", Instruments: []string{"BTC-USDT", "ETH-USDT"}}}
+	s := NewStream(cfg, &Client{})
+	want := []string{"spot/order:BTC-USDT", "spot/account:BTC", "spot/account:USDT", "spot/order:ETH-USDT", "spot/account:ETH"}
+	if s.URL != "wss://example.com/ws/v3" || !reflect.DeepEqual(s.Channels, want) {
+		t.Errorf("NewStream = %s %v, want %v", s.URL, s.Channels, want)
+	}
+}
+
+func TestToEvent(t *testing.T) {
+	tests := []struct {
+		table  string
+		data   string
+		want   Event
+		wantOK bool
+		name   string
+	}{
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"0"}`, Event{Kind: EventOrderPlaced, Channel: "spot/order:BTC-USDT", InstrumentID: "BTC-USDT", OrderID: "7"}, true, "open"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"1"}`, Event{Kind: EventOrderFilled, Channel: "spot/order:BTC-USDT", InstrumentID: "BTC-USDT", OrderID: "7"}, true, "partially filled"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"2"}`, Event{Kind: EventOrderFilled, Channel: "spot/order:BTC-USDT", InstrumentID: "BTC-USDT", OrderID: "7"}, true, "filled"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"-1"}`, Event{Kind: EventOrderCancelled, Channel: "spot/order:BTC-USDT", InstrumentID: "BTC-USDT", OrderID: "7"}, true, "cancelled"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"3"}`, Event{}, false, "submitting"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"4"}`, Event{}, false, "cancelling"},
+		{"spot/order", `{"instrument_id":"BTC-USDT","order_id":"7","state":"-2"}`, Event{}, false, "failed"},
+		{"spot/order", `[]`, Event{}, false, "undecodable order"},
+		{"spot/account", `{"currency":"BTC","balance":"1.5"}`, Event{Kind: EventBalanceChanged, Channel: "spot/account:BTC", Currency: "BTC"}, true, "account"},
+		{"spot/account", `"BTC"`, Event{}, false, "undecodable account"},
+		{"futures/order", `{"instrument_id":"BTC-USD-201225","state":"2"}`, Event{}, false, "another table"},
+	}
+	for _, tt := range tests {
+		got, ok := toEvent(tt.table, json.RawMessage(tt.data))
+		if ok != tt.wantOK {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && string(got.Data) != tt.data {
+			t.Errorf("%s: data = %s, want %s", tt.name, got.Data, tt.data)
+		}
+		got.Data = nil
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: event = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+var wsCredentials = utils.Credentials{Key: "key", SecretKey: "secret", Passphrase: "passphrase"}
+var wsChannels = []string{"spot/order:BTC-USDT", "spot/account:BTC"}
+
+// An OKEx WebSocket that checks each login and then lets the given func say what happens next on the nth connection.
+func newWSServer(t *testing.T, session func(n int, conn *websocket.Conn)) *Stream {
+	var mu sync.Mutex
+	connections := 0
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		login := wsRequest{}
+		if err := conn.ReadJSON(&login); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(login.Args) != 4 {
+			t.Errorf("login = %+v", login)
+			return
+		}
+		sign, _ := utils.HmacSha256Base64Signer(login.Args[2]+"GET/users/self/verify", wsCredentials.SecretKey)
+		if login.Op != "login" || login.Args[0] != wsCredentials.Key || login.Args[1] != wsCredentials.Passphrase || login.Args[3] != sign {
+			t.Errorf("login = %+v, want the sign %s", login, sign)
+		}
+
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+		session(n, conn)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Stream{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Client:   &Client{Credentials: wsCredentials},
+		Channels: wsChannels,
+	}
+}
+
+// Deflate the message as OKEx does, without bothering to finish the stream.
+func deflated(t *testing.T, message string) []byte {
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(message))
+	_ = w.Flush()
+	return b.Bytes()
+}
+
+func TestStreamLoginFailure(t *testing.T) {
+	s := newWSServer(t, func(n int, conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"error","message":"Invalid sign","errorCode":30013}`))
+	})
+
+	err := s.session(context.Background(), make(chan Event, 1))
+	if err == nil || !strings.Contains(err.Error(), "code=30013") {
+		t.Errorf("session = %v, want the login to fail", err)
+	}
+}
+
+func TestStreamRun(t *testing.T) {
+	s := newWSServer(t, func(n int, conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.BinaryMessage, deflated(t, `{"event":"login","success":true}`))
+
+		subscribe := wsRequest{}
+		if err := conn.ReadJSON(&subscribe); err != nil {
+			t.Error(err)
+			return
+		}
+		if subscribe.Op != "subscribe" || !reflect.DeepEqual(subscribe.Args, wsChannels) {
+			t.Errorf("subscribe = %+v", subscribe)
+		}
+
+		switch n {
+		case 1:
+			// Push an order, between pongs, and then drop the connection.
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("pong"))
+			_ = conn.WriteMessage(websocket.BinaryMessage, deflated(t, `{"table":"spot/order","data":[{"instrument_id":"BTC-USDT","order_id":"7","state":"2"}]}`))
+			_ = conn.WriteMessage(websocket.BinaryMessage, deflated(t, "pong"))
+		default:
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"table":"spot/account","data":[{"currency":"BTC"}]}`))
+
+			// Stay connected until the test is done.
+			_, _, _ = conn.ReadMessage()
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan Event)
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx, events)
+		close(stopped)
+	}()
+
+	want := []string{EventConnected, EventOrderFilled, EventConnected, EventBalanceChanged}
+	for i, kind := range want {
+		select {
+		case e := <-events:
+			if e.Kind != kind {
+				t.Fatalf("event %d = %+v, want %s", i, e, kind)
+			}
+		case <-ctx.Done():
+			t.Fatalf("event %d: timed out waiting for %s", i, kind)
+		}
+	}
+
+	cancel()
+	<-stopped
+}
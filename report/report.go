@@ -0,0 +1,292 @@
+// The purpose of this package is to produce a balance sheet or a P&L straight from Bookwerx, one statement per
+// currency, so that nobody has to visit the Report tab of the Bookwerx UI after every step.
+//
+// Bookwerx records debits as positive and credits as negative.  The statements show the liabilities, equity, and
+// revenue, whose balances are normally credits, as positive numbers instead.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type Line struct {
+	AccountID uint32
+	Title     string
+	Amount    decimal.Decimal
+}
+
+type Section struct {
+	Name  string // Assets, Liabilities, etc.
+	Lines []Line
+	Total decimal.Decimal
+}
+
+// The statement for a single currency.
+type Statement struct {
+	Currency string
+	Sections []Section
+
+	// For a balance sheet: Assets - Liabilities - Equity, which ought to be zero.
+	// For a P&L: Revenue - Expenses.
+	Net decimal.Decimal
+}
+
+type Report struct {
+	Kind       string // balance or pnl
+	From       string `json:",omitempty"`
+	To         string
+	Statements []Statement
+}
+
+// What goes into each section, and which way up to show it.
+type sectionSpec struct {
+	name     string
+	category uint32
+	credit   bool // the balance is normally a credit, so flip the sign
+}
+
+// Produce a balance sheet as of the given time and print it in the given format: table, csv, or json.
+//
+// Example:
+// okconnect report balance -as-of 2020-06-01T00:00:00Z -config okconnect.yaml
+func Balance(cfg *config.Config, asOf string, format string) {
+	to, err := parseTime(asOf, time.Now())
+	if err != nil {
+		return
+	}
+
+	bc := cfg.BookwerxConfig
+	specs := []sectionSpec{
+		{"Assets", bc.CatAssets, false},
+		{"Liabilities", bc.CatLiabilities, true},
+		{"Equity", bc.CatEquity, true},
+	}
+
+	r := Report{Kind: "balance", To: to}
+	statements, err := build(cfg, specs, "", to)
+	if err != nil {
+		return
+	}
+
+	// The equity includes whatever has been earned so far, which the P&L accounts have not yet closed into equity.
+	earnings, err := build(cfg, []sectionSpec{{"Revenue", bc.CatRevenue, true}, {"Expenses", bc.CatExpenses, false}}, "", to)
+	if err != nil {
+		return
+	}
+
+	for currency := range earnings {
+		if _, ok := statements[currency]; !ok {
+			statements[currency] = &Statement{Currency: currency, Sections: []Section{{Name: "Assets"}, {Name: "Liabilities"}, {Name: "Equity"}}}
+		}
+	}
+
+	for currency, s := range statements {
+		e := earnings[currency]
+		netIncome := total(e, "Revenue").Sub(total(e, "Expenses"))
+		for i := range s.Sections {
+			if s.Sections[i].Name == "Equity" && !netIncome.IsZero() {
+				s.Sections[i].Lines = append(s.Sections[i].Lines, Line{Title: "Net income", Amount: netIncome})
+				s.Sections[i].Total = s.Sections[i].Total.Add(netIncome)
+			}
+		}
+		s.Net = total(s, "Assets").Sub(total(s, "Liabilities")).Sub(total(s, "Equity"))
+		r.Statements = append(r.Statements, *s)
+	}
+
+	printReport(r, format)
+}
+
+// Produce a P&L for the given time span and print it in the given format: table, csv, or json.
+//
+// Example:
+// okconnect report pnl -from 2020-01-01T00:00:00Z -to 2021-01-01T00:00:00Z -config okconnect.yaml
+func PNL(cfg *config.Config, from string, to string, format string) {
+	fromT, err := parseTime(from, time.Time{})
+	if err != nil {
+		return
+	}
+	toT, err := parseTime(to, time.Now())
+	if err != nil {
+		return
+	}
+
+	bc := cfg.BookwerxConfig
+	specs := []sectionSpec{
+		{"Revenue", bc.CatRevenue, true},
+		{"Expenses", bc.CatExpenses, false},
+	}
+
+	r := Report{Kind: "pnl", From: fromT, To: toT}
+	statements, err := build(cfg, specs, fromT, toT)
+	if err != nil {
+		return
+	}
+
+	for _, s := range statements {
+		s.Net = total(s, "Revenue").Sub(total(s, "Expenses"))
+		r.Statements = append(r.Statements, *s)
+	}
+
+	printReport(r, format)
+}
+
+// Get the sums for each section and sort them into one statement per currency.
+func build(cfg *config.Config, specs []sectionSpec, from string, to string) (map[string]*Statement, error) {
+	statements := make(map[string]*Statement)
+
+	for i, spec := range specs {
+		if spec.category == 0 {
+			log.WithField("section", spec.name).Warn("The config does not say which category this is.  Leaving it empty.")
+		}
+
+		var sums []bookwerx.BalanceResultDecorated
+		var err error
+		if spec.category != 0 {
+			sums, err = bookwerx.CategoryDistSums(*cfg, spec.category, from, to)
+			if err != nil {
+				log.WithError(err).WithField("section", spec.name).Error("Cannot get the sums from Bookwerx.")
+				return nil, err
+			}
+		}
+
+		for _, brd := range sums {
+			currency := brd.Account.Currency.Symbol
+			s, ok := statements[currency]
+			if !ok {
+				s = &Statement{Currency: currency}
+				for _, sp := range specs {
+					s.Sections = append(s.Sections, Section{Name: sp.name})
+				}
+				statements[currency] = s
+			}
+
+			amount := brd.Sum.Decimal()
+			if spec.credit {
+				amount = amount.Neg()
+			}
+			section := &s.Sections[i]
+			section.Lines = append(section.Lines, Line{brd.Account.AccountID, brd.Account.Title, amount})
+			section.Total = section.Total.Add(amount)
+		}
+	}
+
+	return statements, nil
+}
+
+func total(s *Statement, name string) decimal.Decimal {
+	if s == nil {
+		return decimal.Zero
+	}
+	for _, section := range s.Sections {
+		if section.Name == name {
+			return section.Total
+		}
+	}
+	return decimal.Zero
+}
+
+// Accept an RFC3339 time or merely a date, and return it as Bookwerx wants to see it.  If given "", use the default,
+// or "" if that's zero.
+func parseTime(s string, def time.Time) (string, error) {
+	if s == "" {
+		if def.IsZero() {
+			return "", nil
+		}
+		return def.UTC().Format(bookwerx.TimeFormat), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	if err != nil {
+		log.WithField("time", s).Error("Cannot parse the time.  Use something like 2020-06-01T00:00:00Z or 2020-06-01.")
+		return "", err
+	}
+	return t.UTC().Format(bookwerx.TimeFormat), nil
+}
+
+func printReport(r Report, format string) {
+	sort.Slice(r.Statements, func(i, j int) bool { return r.Statements[i].Currency < r.Statements[j].Currency })
+
+	var err error
+	switch format {
+	case "table":
+		err = printTable(r)
+	case "csv":
+		err = printCSV(r)
+	case "json":
+		var b []byte
+		b, err = json.Marshal(r)
+		if err == nil {
+			fmt.Println(string(b))
+		}
+	default:
+		err = errors.New("unknown format")
+	}
+	if err != nil {
+		log.WithError(err).WithField("format", format).Error("Cannot print the report.")
+	}
+}
+
+func printTable(r Report) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	netName := "Assets - Liabilities - Equity"
+	title := "Balance sheet as of " + r.To
+	if r.Kind == "pnl" {
+		netName = "Net income"
+		title = fmt.Sprintf("P&L from %s to %s", r.From, r.To)
+		if r.From == "" {
+			title = "P&L up to " + r.To
+		}
+	}
+	fmt.Fprintln(w, title+"\t\t")
+
+	for _, s := range r.Statements {
+		fmt.Fprintf(w, "\t\t\n%s\t\t\n", s.Currency)
+		for _, section := range s.Sections {
+			fmt.Fprintf(w, "  %s\t\t\n", section.Name)
+			for _, l := range section.Lines {
+				fmt.Fprintf(w, "    %s\t%s\t\n", l.Title, l.Amount.String())
+			}
+			fmt.Fprintf(w, "  Total %s\t%s\t\n", section.Name, section.Total.String())
+		}
+		fmt.Fprintf(w, "  %s\t%s\t\n", netName, s.Net.String())
+	}
+
+	return w.Flush()
+}
+
+// One row per line and one per total: currency, section, account_id, title, amount.
+func printCSV(r Report) error {
+	w := csv.NewWriter(os.Stdout)
+	_ = w.Write([]string{"currency", "section", "account_id", "title", "amount"})
+	for _, s := range r.Statements {
+		for _, section := range s.Sections {
+			for _, l := range section.Lines {
+				id := ""
+				if l.AccountID != 0 {
+					id = strconv.FormatUint(uint64(l.AccountID), 10)
+				}
+				_ = w.Write([]string{s.Currency, section.Name, id, l.Title, l.Amount.String()})
+			}
+			_ = w.Write([]string{s.Currency, section.Name, "", "Total", section.Total.String()})
+		}
+		_ = w.Write([]string{s.Currency, "Net", "", "", s.Net.String()})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bostontrader/okconnect/config"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2020, 10, 10, 1, 2, 3, 456000000, time.UTC)
+	tests := []struct {
+		s       string
+		def     time.Time
+		want    string
+		wantErr bool
+	}{
+		{"2020-06-01T12:00:00+08:00", now, "2020-06-01T04:00:00.000Z", false},
+		{"2020-06-01T12:00:00.5Z", now, "2020-06-01T12:00:00.500Z", false},
+		{"2020-06-01", now, "2020-06-01T00:00:00.000Z", false},
+		{"", now, "2020-10-10T01:02:03.456Z", false},
+		{"", time.Time{}, "", false},
+		{"June 1, 2020", now, "", true},
+		{"2020-06-31", now, "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.s, tt.def)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseTime(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+// A Bookwerx with a few accounts in each category of the reports: 1 assets, 2 liabilities, 3 equity, 4 revenue, and
+// 5 expenses.  It remembers the last query.
+func newReportServer(t *testing.T) (*config.Config, *string) {
+	sums := map[string]string{
+		"1": `{"account":{"account_id":11,"title":"Funding","currency":{"symbol":"BTC"}},"sum":{"amount":2,"exp":0}},
+			{"account":{"account_id":12,"title":"Funding","currency":{"symbol":"USDT"}},"sum":{"amount":100,"exp":0}}`,
+		"2": `{"account":{"account_id":21,"title":"Margin borrowed","currency":{"symbol":"USDT"}},"sum":{"amount":-30,"exp":0}}`,
+		"3": `{"account":{"account_id":31,"title":"Capital","currency":{"symbol":"BTC"}},"sum":{"amount":-15,"exp":-1}},
+			{"account":{"account_id":32,"title":"Capital","currency":{"symbol":"USDT"}},"sum":{"amount":-50,"exp":0}}`,
+		"4": `{"account":{"account_id":41,"title":"Swap PnL","currency":{"symbol":"BTC"}},"sum":{"amount":-6,"exp":-1}},
+			{"account":{"account_id":42,"title":"Swap PnL","currency":{"symbol":"ETH"}},"sum":{"amount":-1,"exp":0}}`,
+		"5": `{"account":{"account_id":51,"title":"Fees","currency":{"symbol":"BTC"}},"sum":{"amount":1,"exp":-1}},
+			{"account":{"account_id":52,"title":"Fees","currency":{"symbol":"USDT"}},"sum":{"amount":20,"exp":0}}`,
+	}
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprintf(w, `{"sums":[%s]}`, sums[r.URL.Query().Get("category_id")])
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{BookwerxConfig: config.BookwerxConfig{BaseURL: server.URL, CatAssets: 1, CatLiabilities: 2, CatEquity: 3, CatRevenue: 4, CatExpenses: 5}}
+	return cfg, &query
+}
+
+// Return whatever the func prints.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	f()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestBuild(t *testing.T) {
+	cfg, _ := newReportServer(t)
+
+	// The credit sections are shown as positive numbers.
+	statements, err := build(cfg, []sectionSpec{{"Assets", 1, false}, {"Liabilities", 2, true}, {"Other", 0, false}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usdt := statements["USDT"]
+	if usdt == nil || len(statements) != 2 || len(usdt.Sections) != 3 {
+		t.Fatalf("statements = %+v", statements)
+	}
+	if l := usdt.Sections[1].Lines[0]; l.AccountID != 21 || l.Title != "Margin borrowed" || l.Amount.String() != "30" || usdt.Sections[1].Total.String() != "30" {
+		t.Errorf("liabilities = %+v", usdt.Sections[1])
+	}
+	if usdt.Sections[0].Total.String() != "100" || len(usdt.Sections[2].Lines) != 0 {
+		t.Errorf("sections = %+v", usdt.Sections)
+	}
+	if btc := statements["BTC"]; btc.Sections[0].Total.String() != "2" || len(btc.Sections[1].Lines) != 0 || !btc.Sections[1].Total.IsZero() {
+		t.Errorf("BTC = %+v", btc)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	cfg, query := newReportServer(t)
+	out := captureStdout(t, func() { Balance(cfg, "2020-06-01", "json") })
+	if !strings.Contains(*query, "time_stop=2020-06-01T00%3A00%3A00.000Z") || strings.Contains(*query, "time_start") {
+		t.Errorf("query = %s", *query)
+	}
+
+	r := Report{}
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if r.Kind != "balance" || r.To != "2020-06-01T00:00:00.000Z" || len(r.Statements) != 3 {
+		t.Fatalf("report = %+v", r)
+	}
+
+	// The net income, which hasn't been closed into equity yet, is shown in equity.  A currency that only has income
+	// gets a statement too.
+	tests := []struct {
+		currency string
+		equity   string
+		lines    int
+		net      string
+	}{
+		{"BTC", "2", 2, "0"},    // 1.5 + 0.6 - 0.1
+		{"ETH", "1", 1, "-1"},   // nothing + 1
+		{"USDT", "30", 2, "40"}, // 50 - 20, and 100 - 30 - 30
+	}
+	for i, tt := range tests {
+		s := r.Statements[i]
+		equity := s.Sections[2]
+		if s.Currency != tt.currency || equity.Name != "Equity" || equity.Total.String() != tt.equity || len(equity.Lines) != tt.lines || s.Net.String() != tt.net {
+			t.Errorf("%s: %+v", tt.currency, s)
+		}
+		if last := equity.Lines[len(equity.Lines)-1]; last.Title != "Net income" || last.AccountID != 0 {
+			t.Errorf("%s: the last line of equity = %+v", tt.currency, last)
+		}
+	}
+}
+
+func TestPNLCSV(t *testing.T) {
+	cfg, query := newReportServer(t)
+	out := captureStdout(t, func() { PNL(cfg, "2020-01-01", "2021-01-01T00:00:00Z", "csv") })
+	if !strings.Contains(*query, "time_start=2020-01-01T00%3A00%3A00.000Z&time_stop=2021-01-01T00%3A00%3A00.000Z") {
+		t.Errorf("query = %s", *query)
+	}
+
+	want := `currency,section,account_id,title,amount
+BTC,Revenue,41,Swap PnL,0.6
+BTC,Revenue,,Total,0.6
+BTC,Expenses,51,Fees,0.1
+BTC,Expenses,,Total,0.1
+BTC,Net,,,0.5
+ETH,Revenue,42,Swap PnL,1
+ETH,Revenue,,Total,1
+ETH,Expenses,,Total,0
+ETH,Net,,,1
+USDT,Revenue,,Total,0
+USDT,Expenses,52,Fees,20
+USDT,Expenses,,Total,20
+USDT,Net,,,-20
+`
+	if out != want {
+		t.Errorf("PNL =\n%s\nwant\n%s", out, want)
+	}
+}
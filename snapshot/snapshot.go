@@ -0,0 +1,267 @@
+// The purpose of this package is to keep the balances that compare finds, so that we can see later how they changed
+// from one run to another without asking OKEx or Bookwerx again.
+//
+// Each snapshot is a JSON file in a single directory.  Its name is its id, which is the time that it was taken, to the
+// millisecond, such as 20201010T000000.000Z, so the names sort in the order that the snapshots were taken.  If two are
+// taken in the same millisecond then the second gets a suffix, such as 20201010T000000.000Z-2.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/atomicfile"
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The directory to use if the config does not say otherwise.
+const DefaultDir = "okconnect.snapshots"
+
+// The format of the ids of the snapshots.
+const idFormat = "20060102T150405.000Z"
+
+// What list says about each snapshot.
+type Summary struct {
+	ID          string
+	Time        string
+	Comparisons int
+	Mismatches  int // not even within tolerance
+}
+
+// How a single balance changed from one snapshot to another.
+type Change struct {
+	Account        string `json:",omitempty"`
+	Category       string
+	CurrencySymbol string
+	Instrument     string `json:",omitempty"`
+	OKExBefore     compare.MaybeBalance
+	OKExAfter      compare.MaybeBalance
+	BookwerxBefore compare.MaybeBalance
+	BookwerxAfter  compare.MaybeBalance
+}
+
+func dir(cfg *config.Config) string {
+	if cfg.Snapshots == "" {
+		return DefaultDir
+	}
+	return cfg.Snapshots
+}
+
+// Save the snapshot and return its id.  Write it to a temporary file first so that a snapshot is either all there
+// or not there at all.
+func Save(cfg *config.Config, s *compare.Snapshot) (string, error) {
+	t, err := time.Parse(time.RFC3339, s.Time)
+	if err != nil {
+		log.WithError(err).WithField("time", s.Time).Error("Cannot parse the time of the snapshot.")
+		return "", err
+	}
+	err = os.MkdirAll(dir(cfg), 0700)
+	if err != nil {
+		log.WithError(err).WithField("snapshots", dir(cfg)).Error("Cannot make the snapshot directory.")
+		return "", err
+	}
+
+	// If the id is taken then add a suffix.
+	base := t.UTC().Format(idFormat)
+	id := base
+	path := filepath.Join(dir(cfg), id+".json")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+		path = filepath.Join(dir(cfg), id+".json")
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	err = atomicfile.WriteFile(path, data, 0600)
+	if err != nil {
+		log.WithError(err).WithField("snapshot", path).Error("Cannot save the snapshot.")
+		return "", err
+	}
+	log.WithField("id", id).Info("Saved the snapshot.")
+	return id, nil
+}
+
+// The ids of every snapshot, oldest first.
+func ids(cfg *config.Config) ([]string, error) {
+	files, err := ioutil.ReadDir(dir(cfg))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		log.WithError(err).WithField("snapshots", dir(cfg)).Error("Cannot read the snapshot directory.")
+		return nil, err
+	}
+
+	retVal := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			retVal = append(retVal, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(retVal)
+	return retVal, nil
+}
+
+func read(cfg *config.Config, id string) (*compare.Snapshot, error) {
+	return compare.ReadSnapshot(filepath.Join(dir(cfg), id+".json"))
+}
+
+// Print a summary of every snapshot, oldest first, as a JSON array.  Each snapshot is judged by the tolerances that
+// were used when it was taken.  An older snapshot that didn't keep them is judged by today's.
+//
+// Example:
+// okconnect snapshot list -config okconnect.yaml
+func List(cfg *config.Config) {
+	all, err := ids(cfg)
+	if err != nil {
+		return
+	}
+
+	retVal := make([]Summary, 0, len(all))
+	for _, id := range all {
+		s, err := read(cfg, id)
+		if err != nil {
+			return
+		}
+		summary := Summary{ID: id, Time: s.Time, Comparisons: len(s.Comparisons)}
+		for _, c := range s.Comparisons {
+			if c.Status(compareConfig(cfg, s, c.Account)) == compare.StatusMismatch {
+				summary.Mismatches++
+			}
+		}
+		retVal = append(retVal, summary)
+	}
+
+	b, _ := json.Marshal(retVal)
+	fmt.Println(string(b))
+}
+
+// Print the snapshot with the given id, or the newest if id is "".
+//
+// Example:
+// okconnect snapshot show -id 20201010T000000.000Z -config okconnect.yaml
+func Show(cfg *config.Config, id string) {
+	if id == "" {
+		all, err := ids(cfg)
+		if err != nil {
+			return
+		}
+		if len(all) == 0 {
+			log.WithField("snapshots", dir(cfg)).Error("There are no snapshots.")
+			return
+		}
+		id = all[len(all)-1]
+	}
+
+	s, err := read(cfg, id)
+	if err != nil {
+		return
+	}
+	b, _ := json.MarshalIndent(s, "", "  ")
+	fmt.Println(string(b))
+}
+
+// Print how the balances changed from one snapshot to another, as a JSON array.  If to is "" then use the newest
+// snapshot, and if from is "" then use the one before that.
+//
+// Example:
+// okconnect snapshot diff -from 20201009T000000.000Z -to 20201010T000000.000Z -config okconnect.yaml
+func Diff(cfg *config.Config, from string, to string) {
+
+	// 1. Which snapshots?
+	all, err := ids(cfg)
+	if err != nil {
+		return
+	}
+	if to == "" && len(all) > 0 {
+		to = all[len(all)-1]
+	}
+	if from == "" {
+		i := sort.SearchStrings(all, to)
+		if i > 0 {
+			from = all[i-1]
+		}
+	}
+	if from == "" || to == "" {
+		log.WithField("snapshots", dir(cfg)).Error("There are not two snapshots to diff.")
+		return
+	}
+
+	before, err := read(cfg, from)
+	if err != nil {
+		return
+	}
+	after, err := read(cfg, to)
+	if err != nil {
+		return
+	}
+
+	// 2. Line up the balances of each account, category, margin instrument, and currency.
+	changes := make(map[string]*Change)
+	keys := make([]string, 0)
+	get := func(c compare.Comparison) *Change {
+		key := c.Account + ":" + c.Key()
+		ch, ok := changes[key]
+		if !ok {
+			nothing := compare.MaybeBalance{Nil: true}
+			ch = &Change{c.Account, c.Category, c.CurrencySymbol, c.Instrument, nothing, nothing, nothing, nothing}
+			changes[key] = ch
+			keys = append(keys, key)
+		}
+		return ch
+	}
+	for _, c := range before.Comparisons {
+		ch := get(c)
+		ch.OKExBefore, ch.BookwerxBefore = c.OKExBalance, c.BookwerxBalance
+	}
+	for _, c := range after.Comparisons {
+		ch := get(c)
+		ch.OKExAfter, ch.BookwerxAfter = c.OKExBalance, c.BookwerxBalance
+	}
+
+	// 3. Which of them changed?
+	sort.Strings(keys)
+	retVal := make([]Change, 0)
+	for _, key := range keys {
+		ch := changes[key]
+		if !same(ch.OKExBefore, ch.OKExAfter) || !same(ch.BookwerxBefore, ch.BookwerxAfter) {
+			retVal = append(retVal, *ch)
+		}
+	}
+
+	b, _ := json.Marshal(retVal)
+	fmt.Println(string(b))
+}
+
+// Are these the same balance?  Nothing is not the same as zero.
+func same(a compare.MaybeBalance, b compare.MaybeBalance) bool {
+	if a.Nil || b.Nil {
+		return a.Nil == b.Nil
+	}
+	return a.Balance.Equal(b.Balance)
+}
+
+// How closely had the balances of the given OKEx account to agree when the snapshot was taken?  If there's only a
+// single account then it's "".
+func compareConfig(cfg *config.Config, s *compare.Snapshot, account string) config.CompareConfig {
+	if cc, ok := s.Tolerances[account]; ok {
+		return cc
+	}
+	if a, ok := cfg.Account(account); ok {
+		return cfg.ForAccount(a).CompareConfig
+	}
+	return cfg.CompareConfig
+}
@@ -0,0 +1,53 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/shopspring/decimal"
+)
+
+func TestSave(t *testing.T) {
+	cfg := &config.Config{Snapshots: t.TempDir()}
+
+	want := []string{"20201010T000000.123Z", "20201010T000000.123Z-2", "20201010T000000.124Z", "20201010T000000.123Z-3"}
+	for i, when := range []string{"2020-10-10T00:00:00.123Z", "2020-10-10T00:00:00.123Z", "2020-10-10T00:00:00.124Z", "2020-10-10T00:00:00.123Z"} {
+		id, err := Save(cfg, &compare.Snapshot{Time: when})
+		if err != nil || id != want[i] {
+			t.Errorf("%d: Save(%s) = %s, %v, want %s", i, when, id, err, want[i])
+		}
+	}
+
+	all, err := ids(cfg)
+	if err != nil || len(all) != len(want) {
+		t.Fatalf("ids = %v, %v", all, err)
+	}
+	if all[0] != "20201010T000000.123Z" || all[len(all)-1] != "20201010T000000.124Z" {
+		t.Errorf("ids are not oldest first: %v", all)
+	}
+}
+
+func TestCompareConfig(t *testing.T) {
+	today := config.CompareConfig{Dust: decimal.New(1, -2)}
+	then := config.CompareConfig{Dust: decimal.New(1, -8)}
+	cfg := &config.Config{CompareConfig: today}
+
+	// A difference that's dust today wasn't then.
+	c := compare.Comparison{
+		Category:        "F",
+		OKExBalance:     compare.MaybeBalance{Balance: decimal.New(1001, -3)},
+		BookwerxBalance: compare.MaybeBalance{Balance: decimal.New(1, 0)},
+		CurrencySymbol:  "BTC",
+	}
+	s := &compare.Snapshot{Comparisons: []compare.Comparison{c}, Tolerances: map[string]config.CompareConfig{"": then}}
+	if status := c.Status(compareConfig(cfg, s, "")); status != compare.StatusMismatch {
+		t.Errorf("with the tolerances of the snapshot: status = %s, want %s", status, compare.StatusMismatch)
+	}
+
+	// An older snapshot that didn't keep them is judged by today's.
+	s.Tolerances = nil
+	if status := c.Status(compareConfig(cfg, s, "")); status == compare.StatusMismatch {
+		t.Errorf("with today's tolerances: status = %s, want no mismatch", status)
+	}
+}
@@ -4,19 +4,31 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	bwapi "github.com/bostontrader/bookwerx-common-go"
-	utils "github.com/bostontrader/okcommon"
+	"github.com/bostontrader/okconnect/bookwerx"
 	"github.com/bostontrader/okconnect/config"
 	okchttp "github.com/bostontrader/okconnect/http"
-	"github.com/gojektech/heimdall/httpclient"
-	"github.com/pkg/errors"
+	"github.com/bostontrader/okconnect/journal"
+	"github.com/bostontrader/okconnect/okex"
 	"github.com/shopspring/decimal"
-	"io/ioutil"
-	"net/http"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
 
+type AccountTransferRequest struct {
+	CurrencySymbol string `json:"currency"`
+	Amount         string `json:"amount"`
+	From           string `json:"from"`
+	To             string `json:"to"`
+	ClientOID      string `json:"client_oid"`
+	Type           string `json:"type,omitempty"`             // 1 = from the main account to a sub-account, 2 = the other way
+	SubAccount     string `json:"sub_account,omitempty"`      // the OKEx name of the sub-account
+	InstrumentID   string `json:"instrument_id,omitempty"`    // if the source is a margin account
+	ToInstrumentID string `json:"to_instrument_id,omitempty"` // if the destination is a margin account
+}
+
 type AccountTransferResult struct {
 	TransferID     string `json:"transfer_id"`
 	CurrencySymbol string `json:"currency"`
@@ -24,34 +36,74 @@ type AccountTransferResult struct {
 	Amount         string
 	To             string
 	Result         string
+	ClientOID      string `json:"client_oid"`
+}
+
+// The kinds of OKEx account that a transfer can use, by name, such as funding.
+type transferAccountType struct {
+	code            string // what OKEx calls it, such as 6
+	needsInstrument bool   // A margin account is per instrument, so which one?
+	needsSubAccount bool   // Which sub-account?
+
+	// The category that tags the Bookwerx account for this kind of OKEx account.
+	category func(bc config.BookwerxConfig) uint32
+}
+
+var transferAccountTypes = map[string]transferAccountType{
+	"sub-account": {"0", false, true, func(bc config.BookwerxConfig) uint32 { return bc.CatFunding }},
+	"spot":        {"1", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatSpotAvailable }},
+	"futures":     {"3", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatFutures }},
+	"c2c":         {"4", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatC2C }},
+	"margin":      {"5", true, false, func(bc config.BookwerxConfig) uint32 { return bc.CatMargin }},
+	"funding":     {"6", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatFunding }},
+	"swap":        {"9", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatSwap }},
+	"options":     {"12", false, false, func(bc config.BookwerxConfig) uint32 { return bc.CatOptions }},
+}
+
+// Make a transfer for the tui, between two kinds of OKEx account of the named account, or of the main account if it's
+// "".
+func tuiTransfer(cfg *config.Config, account string, currency string, from string, to string, quan string, instrument string) {
+	if account == "" {
+		account = config.MainAccount
+	}
+	clientOID, none := "", ""
+	Transfer(cfg, &currency, &from, &to, &quan, &clientOID, &account, &account, &instrument, &none, &none)
 }
 
-// The bookwerx-core server will on occasion return JSON names that contain a '.'.  This vile habit
-// causes trouble here.
-// A good, bad, or ugly hack is to simply change the . to a -.  Do that here.
-func fixDot(b []byte) {
-	for i, num := range b {
-		if num == 46 { // .
-			b[i] = 45 // -
+// Find the kind of OKEx account by its name, or by its OKEx code for the sake of the old scripts.
+func findTransferAccountType(name string) (transferAccountType, bool) {
+	name = strings.ToLower(name)
+	if t, ok := transferAccountTypes[name]; ok {
+		return t, true
+	}
+	for _, t := range transferAccountTypes {
+		if t.code == name {
+			return t, true
 		}
 	}
+	return transferAccountType{}, false
 }
 
 // The purpose of this function is to make a transfer between two different locations on OKEx (such as funding to spot)
 // and to also create a transaction in the user's bookwerx to reflect said transfer.
 //
-// This function presently only supports transfers between 1 (spot) and 6 (funding).
+// The source and destination are kinds of OKEx account: funding, spot, futures, c2c, margin, swap, options, or
+// sub-account.  Each is booked to the Bookwerx account tagged with its own category.  A margin account needs
+// -instrument and a sub-account needs -sub_account.  The old OKEx codes, such as 6 for funding, still work too.
 // Example:
-// okconnect transfer -currency BTC -quan 1.25 -from 6 -to 1 -config okconnect.yaml
+// okconnect transfer -currency BTC -quan 1.25 -from funding -to spot -config okconnect.yaml
 // means transfer 1.25 BTC from funding to spot
+// okconnect transfer -currency USDT -quan 100 -from funding -to margin -instrument BTC-USDT -config okconnect.yaml
 //
 // This function presently has a handful of important issues to be aware of.
 //
 // 1. Generally, in order to make this transfer, this function will make several API calls to OKEx and bookwerx that will
 // change the state of each.  Each call has several ways to fail and unless everything works as h/o/p/e/d/ expected OKEx and bookwerx
-// will not agree with each other.  There's no practical way to implement "transactioning" for this process so in the event of
-// failure the user will just have to examine the state of OKEx _and_ bookwerx and cleanup whatever mess has been caused by
-// the failure.
+// will not agree with each other.  There's no practical way to implement "transactioning" for this process.  Instead
+// every transfer is identified by a client_oid which is remembered in the local journal, sent to OKEx, and written into
+// the notes of the bookwerx transaction.  This function prints the client_oid before it does anything.  If anything
+// goes wrong, run the same command again with -client_oid and it will pick up where it left off, without transferring
+// or booking anything twice.
 //
 // 2. A fruitful source of error would be for the user to specify source and destinations that aren't properly
 // configured in bookwerx.  When this function tries to create a bookwerx transaction it must determine actual account
@@ -59,76 +111,141 @@ func fixDot(b []byte) {
 // to merely create a new account, properly configured, to cure this woe, but doing so presents more trouble.  So at this time
 // we don't do this.  Make sure all the expected accounts are present first before you use this function.
 //
-// 3. We make the API call to OKEx first because we need info from the correct results in order to
-// subsequent create the bookwerx transaction.
+// 3. We therefore find the bookwerx accounts first and only then make the API call to OKEx.  There's no point in
+// moving coins on OKEx if we already know that we can't book it.
 //
 // 4. In the event of some error that leaves OKEx and bookwerx in a disagreeable state,
 // remember to use okconnect compare.
+//
+// 5. The transfer may also be between the main account and one of the sub-accounts configured in
+// OKExConfig.Accounts, using -from_account and -to_account.  OKEx only does this for the main account's credentials,
+// and only between the main account and a sub-account, not between two sub-accounts.  Each side is booked using the
+// categories of its own account.  If -from_account and -to_account are the same sub-account then the transfer is between
+// two kinds of OKEx account of that sub-account, made using its own credentials.
+// Example:
+// okconnect transfer -currency BTC -quan 1.25 -from 6 -to 6 -to_account sub1 -config okconnect.yaml
+// okconnect transfer -currency BTC -quan 1.25 -from funding -to spot -from_account sub1 -to_account sub1 -config okconnect.yaml
 
-func Transfer(cfg *config.Config, transferCurrency *string, transferFrom *string, transferTo *string, transferQuan *string) {
-
-	methodName := "okconnect:transfer.go:Transfer"
+func Transfer(cfg *config.Config, transferCurrency *string, transferFrom *string, transferTo *string, transferQuan *string, transferClientOID *string, transferFromAccount *string, transferToAccount *string, transferInstrument *string, transferToInstrument *string, transferSubAccount *string) {
 
-	// 1. First make the API call to OKEx
+	// 1. Validate the source and destination and determine the relevant bookwerx categories to use.
 
-	// 1.1 Read the credentials file for OKEx
-	credentials, err := config.ReadCredentialsFile(cfg.OKExConfig.Credentials)
-	if err != nil {
-		fmt.Printf("transfer.go:Transfer: Cannot read the OKEx credentials file.\n")
+	// 1.1 Which accounts?
+	fromAccount, ok := cfg.Account(*transferFromAccount)
+	if !ok {
+		log.WithField("from_account", *transferFromAccount).Error("The config does not have this account.")
+		return
+	}
+	toAccount, ok := cfg.Account(*transferToAccount)
+	if !ok {
+		log.WithField("to_account", *transferToAccount).Error("The config does not have this account.")
 		return
 	}
 
-	// 1.2 Make the Call!
-	_, err = accountTransfer(*cfg, *credentials)
-	if err != nil {
-		fmt.Printf("transfer.go:Transfer OKEx API call failed.\n")
+	// Whose credentials make the call?  Those of the account itself if both sides are the same account, else the main
+	// account's.
+	var transferType, subAccount string
+	caller := fromAccount
+	switch {
+	case fromAccount.Name == toAccount.Name:
+	case fromAccount.Name == config.MainAccount:
+		transferType, subAccount = "1", okexName(toAccount)
+	case toAccount.Name == config.MainAccount:
+		transferType, subAccount, caller = "2", okexName(fromAccount), toAccount
+	default:
+		log.Error("OKEx cannot transfer between two sub-accounts.  Transfer to the main account first.")
 		return
 	}
 
-	// 2. Validate the source and destination code and determine the relevant bookwerx categories to use.
-	var catSource uint32
-	var catDest uint32
+	// 1.2 Which kinds of account?
+	source, ok := findTransferAccountType(*transferFrom)
+	if !ok {
+		log.WithField("from", *transferFrom).Error("The transferFrom parameter must be one of " + transferAccountTypeNames())
+		return
+	}
+	dest, ok := findTransferAccountType(*transferTo)
+	if !ok {
+		log.WithField("to", *transferTo).Error("The transferTo parameter must be one of " + transferAccountTypeNames())
+		return
+	}
 
-	// 2.1 They should not be the same.
-	if *transferFrom == *transferTo {
-		fmt.Printf("transfer.go: The source and destination of this transfer are the same. No can do.\n")
+	// 1.3 They should not be the same.
+	if source.code == dest.code && transferType == "" && !source.needsInstrument {
+		log.Error("The source and destination of this transfer are the same. No can do.")
 		return
 	}
 
-	// 2.2 Source...
-	if *transferFrom == "1" {
-		catSource = cfg.BookwerxConfig.CatSpotAvailable
-	} else if *transferFrom == "6" {
-		catSource = cfg.BookwerxConfig.CatFunding
-	} else {
-		fmt.Printf("transfer.go: The transferFrom parameter %d must be 1 or 6\n", transferFrom)
+	// 1.4 Does either side need anything else?
+	var instrumentID, toInstrumentID string
+	if source.needsInstrument {
+		instrumentID = *transferInstrument
+	}
+	if dest.needsInstrument {
+		toInstrumentID = *transferInstrument
+		if source.needsInstrument {
+			toInstrumentID = *transferToInstrument
+		}
+	}
+	if (source.needsInstrument && instrumentID == "") || (dest.needsInstrument && toInstrumentID == "") {
+		log.Error("A margin account is per instrument.  Say which using -instrument, and -to_instrument if both sides are margin.")
+		return
+	}
+	if source.needsInstrument && dest.needsInstrument && instrumentID == toInstrumentID {
+		log.Error("The source and destination of this transfer are the same. No can do.")
 		return
 	}
 
-	// 2.3 Destination...
-	if *transferTo == "1" {
-		catDest = cfg.BookwerxConfig.CatSpotAvailable
-	} else if *transferTo == "6" {
-		catDest = cfg.BookwerxConfig.CatFunding
-	} else {
-		fmt.Printf("transfer.go: The transferTo parameter %d must be 1 or 6\n", transferTo)
+	if source.needsSubAccount || dest.needsSubAccount {
+		if transferType != "" {
+			log.Error("Use either -from_account and -to_account or a sub-account type, not both.")
+			return
+		}
+		if *transferSubAccount == "" {
+			log.Error("Say which sub-account using -sub_account.")
+			return
+		}
+		if caller.Name != config.MainAccount {
+			log.WithField("account", caller.Name).Error("OKEx only transfers to or from a sub-account using the main account's credentials.")
+			return
+		}
+		a, ok := cfg.Account(*transferSubAccount)
+		if !ok || a.Name == config.MainAccount {
+			log.WithField("sub_account", *transferSubAccount).Error("The config does not have this sub-account, so we cannot book it.")
+			return
+		}
+		subAccount = okexName(a)
+		if source.needsSubAccount {
+			fromAccount = a
+		} else {
+			toAccount = a
+		}
+	}
+
+	// 1.5 Each side is booked using the categories of its own account.
+	catSource := source.category(cfg.ForAccount(fromAccount).BookwerxConfig)
+	catDest := dest.category(cfg.ForAccount(toAccount).BookwerxConfig)
+	if catSource == 0 || catDest == 0 {
+		log.WithFields(log.Fields{"from": *transferFrom, "to": *transferTo}).Error("The config does not say which category tags the Bookwerx accounts for this kind of OKEx account.")
 		return
 	}
 
-	// 3. Parse the quantity
+	// 2. Parse the quantity
 	quan, err := decimal.NewFromString(*transferQuan)
 	if err != nil {
-		fmt.Printf("transfer.go: Cannot parse the quantity.\n")
+		log.WithError(err).WithField("quan", *transferQuan).Error("Cannot parse the quantity.")
+		return
+	}
+	_, err = bookwerx.ToDFP(quan)
+	if err != nil {
+		log.WithError(err).WithField("quan", *transferQuan).Error("Bookwerx cannot hold this quantity.")
 		return
 	}
-	quanCoff := quan.Coefficient().Int64()
 
-	// why two clients!? clean this up.
-	// We'll need an HTTP client for the subsequent requests.
+	// We'll need an HTTP client for the subsequent bookwerx requests.
 	timeout := 5000 * time.Millisecond
-	clientB := httpclient.NewClient(httpclient.WithHTTPTimeout(timeout))
+	clientB := okchttp.GetHeimdallClient("bookwerx", cfg.BookwerxConfig.BaseURL, timeout)
 
-	// 4. Verify that the user has said currency defined.  If not, then the user _cannot_ have
+	// 3. Verify that the user has said currency defined.  If not, then the user _cannot_ have
 	// a source account using said currency.
 
 	// Building the url is rather tedious generally because of the need to escape
@@ -161,192 +278,166 @@ func Transfer(cfg *config.Config, transferCurrency *string, transferFrom *string
 		return
 	}*/
 
-	// 5. Find the user's source account in his bookwerx db.  It's an account that is:
+	// 4. Find the user's source account in his bookwerx db.  It's an account that is:
 	// A. Tagged with the whatever category corresponds with the specified source, such as funding or spot,
 	// B. Configured to use the specified currency.
-
-	selectt := "SELECT%20accounts.id"
-	from := "FROM%20accounts_categories"
-	join1 := "JOIN%20accounts%20ON%20accounts.id%3daccounts_categories.account_id"
-	join2 := "JOIN%20currencies%20ON%20currencies.id%3daccounts.currency_id"
-	where := fmt.Sprintf("WHERE%%20category_id%%3d%d%%20AND%%20currencies.symbol%%3d'%s'", catSource, *transferCurrency)
-	query := fmt.Sprintf("%s%%20%s%%20%s%%20%s%%20%s", selectt, from, join1, join2, where)
-	url := fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
-
-	body, err := bwapi.Get(clientB, url)
-	if err != nil {
-		fmt.Printf("%s :Error reading %s\n%v", methodName, url, err)
-		return
+	// C. If it's a margin account, then perhaps kept for the specified instrument.
+	var sourceAcctID uint32
+	if source.needsInstrument {
+		sourceAcctID, err = bookwerx.FindInstrumentAccount(clientB, catSource, *transferCurrency, instrumentID, *cfg)
+	} else {
+		sourceAcctID, err = bookwerx.FindCategoryAccount(clientB, catSource, *transferCurrency, *cfg)
 	}
-	fixDot(body)
-
-	n1 := make([]AId, 0)
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n1)
 	if err != nil {
-		fmt.Printf("transfer.go: JSON decode error: %v\n", err)
+		log.WithError(err).Error("Cannot find the source account.")
 		return
 	}
 
-	if len(n1) == 0 {
-		fmt.Printf("transfer.go: Bookwerx does not have any account properly configured.\n")
+	// 5. Find the user's destination account in his bookwerx db in a manner similar to that of the source
+	// account.
+	var destAcctID uint32
+	if dest.needsInstrument {
+		destAcctID, err = bookwerx.FindInstrumentAccount(clientB, catDest, *transferCurrency, toInstrumentID, *cfg)
+	} else {
+		destAcctID, err = bookwerx.FindCategoryAccount(clientB, catDest, *transferCurrency, *cfg)
+	}
+	if err != nil {
+		log.WithError(err).Error("Cannot find the destination account.")
 		return
-	} else if len(n1) > 1 {
-		fmt.Printf("transfer.go: Bookwerx has more than one suitable account.  This should never happen.\n")
 	}
 
-	sourceAcctID := n1[0]
+	// 6. Now execute the transfer on okex.
 
-	// 6. Find the user's destination account in his bookwerx db in a manner similar to that of the source
-	// account. It's an account that is:
-	// A. Tagged with the whatever category corresponds with the specified destination, such as funding or spot,
-	// B. Configured to use the specified currency.
-	// Said destination account may not exist so create it if necessary.
-	//selectt := "SELECT%20accounts.id";
-	//from := "FROM%20accounts_categories";
-	//join1 := "JOIN%20accounts%20ON%20accounts.id%3daccounts_categories.account_id";
-	//join2 := "JOIN%20currencies%20ON%20currencies.id%3daccounts.currency_id";
-	//cat1 := cfg.BookwerxConfig.FundingCat;
-	//cat2 := cfg.BookwerxConfig.SpotAvailableCat;
-	where = fmt.Sprintf("WHERE%%20category_id%%3d%d%%20AND%%20currencies.symbol%%3d'%s'", catDest, *transferCurrency)
-	query = fmt.Sprintf("%s%%20%s%%20%s%%20%s%%20%s", selectt, from, join1, join2, where)
-	url = fmt.Sprintf("%s/sql?query=%s&apikey=%s", cfg.BookwerxConfig.BaseURL, query, cfg.BookwerxConfig.APIKey)
-
-	body, err = bwapi.Get(clientB, url)
+	// 6.1 Read the credentials file for OKEx
+	cfgCaller := cfg.ForAccount(caller)
+	credentials, err := config.ReadCredentials(cfgCaller.OKExConfig.Credentials)
 	if err != nil {
-		fmt.Printf("transfer.go: get error: %v\n", err)
+		log.Error("Cannot read the OKEx credentials file.")
 		return
 	}
 
-	// ugly hack to fix.  the '.' fucks up subsequent decoding
-	for i, num := range body {
-		if num == 46 {
-			body[i] = 45
-		}
-	}
-
-	n1 = make([]AId, 0)
-	err = json.NewDecoder(bytes.NewReader(body)).Decode(&n1)
+	// 6.2 Open the journal
+	jrnl, err := journal.Open(cfg.Journal)
 	if err != nil {
-		fmt.Printf("transfer.go: JSON decode error: %v\n", err)
+		log.Error("Cannot open the journal.")
 		return
 	}
 
-	if len(n1) == 0 {
-		fmt.Printf("transfer.go: Bookwerx does not have any account properly configured.\n")
+	// 6.3 Which transfer is this?  If we are continuing an earlier attempt then perhaps there's nothing left to do.
+	clientOID := *transferClientOID
+	if clientOID == "" {
+		clientOID = okex.NewClientOID()
+	} else if entry, ok := jrnl.Get(clientOID); ok && entry.State == journal.StateBooked {
+		log.WithField("client_oid", clientOID).Info("The transfer has already been made and booked.")
 		return
-	} else if len(n1) > 1 {
-		fmt.Printf("transfer.go: Bookwerx has more than one suitable account.  This should never happen.\n")
 	}
-
-	destAcctID := n1[0]
-
-	/*where = fmt.Sprintf("WHERE accounts_categories.category_id %3d", catDest);
-	url = fmt.Sprintf("%s %s %s %s %s %s %s", selectt, from, join1, join2, where, group, having);
-
-
-	// 6.1 If there are zero accounts tagged with the suitable destination categories, then
-	// create and tag them suitably
-	if len(n.AccountId) == 0 {
-		// A. Create the account and save the account id
-		// B. Tag the account with destination & APIKEY8
-	} else {
-		// grab AccountId[0].  Assume that's the only one.
+	tlog := log.WithField("client_oid", clientOID)
+	tlog.Info("Transfer")
+
+	// 6.4 Make the Call!
+	client := okex.NewClient(*cfgCaller, *credentials)
+	client.Journal = jrnl
+	reqBody, _ := json.Marshal(AccountTransferRequest{
+		CurrencySymbol: *transferCurrency,
+		Amount:         quan.String(),
+		From:           source.code,
+		To:             dest.code,
+		ClientOID:      clientOID,
+		Type:           transferType,
+		SubAccount:     subAccount,
+		InstrumentID:   instrumentID,
+		ToInstrumentID: toInstrumentID,
+	})
+	_, err = accountTransfer(client, clientOID, string(reqBody))
+	if err != nil {
+		tlog.WithError(err).Error("The OKEx API call failed.  Rerun with -client_oid to try again.")
+		return
 	}
-	destAcctID := n.AccountId[0]
-	*/
 
-	// 7. Now execute the transfer on okex.
-	// call okprobe to do the dirty work
+	// 7. If successful, make the bookwerx tx.
 
-	// 8. If successful, make the bookwerx tx.
-	// 8. Now create the transaction on the user's books and the two distributions using three requests.
-
-	// 8.1 Create the tx
-	txid, err := createTransaction(clientB, "time", *cfg)
+	// 7.1 But not if an earlier run has already done so.
+	txids, err := bookwerx.FindTransactionsByNotes(clientB, clientOID, *cfg)
 	if err != nil {
-		//log.Error(err)
-		//fmt.Fprintf(w, err.Error())
-		fmt.Printf("transfer.go: Error creating bookwerx transaction.\n")
+		tlog.WithError(err).Error("Cannot determine whether bookwerx already has this transfer.  Rerun with -client_oid to try again.")
 		return
 	}
-
-	// 8.2 Create the DR distribution
-	_, err = createDistribution(clientB, destAcctID.Id, quanCoff, quan.Exponent(), txid, *cfg)
-	if err != nil {
-		//log.Error(err)
-		//fmt.Fprintf(w, err.Error())
-		fmt.Printf("transfer.go: Error creating bookwerx distribution.\n")
+	if len(txids) > 0 {
+		tlog.WithField("transaction_id", txids[0]).Info("Bookwerx already has this transfer.")
+		_ = jrnl.MarkBooked(clientOID)
 		return
 	}
 
-	// 8.3 Create the CR distribution
-	_, err = createDistribution(clientB, sourceAcctID.Id, -quanCoff, quan.Exponent(), txid, *cfg)
+	// 7.2 Now create the transaction on the user's books and its two distributions.
+	notes := fmt.Sprintf("Transfer %s %s from %s to %s client_oid=%s", quan.String(), *transferCurrency, *transferFrom, *transferTo, clientOID)
+	if subAccount != "" || caller.Name != config.MainAccount {
+		notes = fmt.Sprintf("Transfer %s %s from %s %s to %s %s client_oid=%s", quan.String(), *transferCurrency, fromAccount.Name, *transferFrom, toAccount.Name, *transferTo, clientOID)
+	}
+
+	// 7.2.1 DR the destination and CR the source.
+	tx := bookwerx.NewTransaction(client.Timestamp(), notes)
+	tx.Add(destAcctID, *transferCurrency, quan).Add(sourceAcctID, *transferCurrency, quan.Neg())
+
+	// 7.2.2 Create the tx and both distributions, or neither.
+	_, err = tx.Post(clientB, *cfg)
 	if err != nil {
-		//log.Error(err)
-		//fmt.Fprintf(w, err.Error())
-		fmt.Printf("transfer.go: Error creating bookwerx distribution.\n")
+		tlog.WithError(err).Error("Rerun with -client_oid to try again.")
 		return
 	}
-	//fmt.Printf(catSource, catDest, quanCoff, sourceAcctID, destAcctID)
+
+	_ = jrnl.MarkBooked(clientOID)
 	return
 
 }
 
-// Make the API call to perform the transfer on okex
-func accountTransfer(cfg config.Config, credentials utils.Credentials) (AccountTransferResult, error) {
-	urlBase := cfg.OKExConfig.BaseURL
-	endpoint := "/api/account/v3/transfer"
-	url := urlBase + endpoint
-	client := okchttp.GetHTTPClient(urlBase)
-	reqBody := `{"from":"6", "to":"1", "amount":"0.1", "currency":"BTC"}`
-
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.999Z")
-	prehash := timestamp + "POST" + endpoint + reqBody
-	encoded, _ := utils.HmacSha256Base64Signer(prehash, credentials.SecretKey)
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(reqBody))
-	if err != nil {
-		fmt.Printf("transfer.go:accountTransfer: NewRequest error:%v\n", err)
-		return AccountTransferResult{}, err
+// List the names of every kind of OKEx account, for the error messages.
+func transferAccountTypeNames() string {
+	names := make([]string, 0, len(transferAccountTypes))
+	for name := range transferAccountTypes {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("OK-ACCESS-KEY", credentials.Key)
-	req.Header.Add("OK-ACCESS-SIGN", encoded)
-	req.Header.Add("OK-ACCESS-TIMESTAMP", timestamp)
-	req.Header.Add("OK-ACCESS-PASSPHRASE", credentials.Passphrase)
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("transfer.go:accountTransfer: client.Do error:%v\n", err)
-		return AccountTransferResult{}, err
+// What OKEx calls the given sub-account.
+func okexName(a config.AccountConfig) string {
+	if a.SubAccount != "" {
+		return a.SubAccount
 	}
+	return a.Name
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+// Make the API call to perform the transfer on okex, at most once.
+func accountTransfer(client *okex.Client, clientOID string, reqBody string) (AccountTransferResult, error) {
+	respBody, err := client.Submit("transfer", "/api/account/v3/transfer", clientOID, reqBody, lookupTransfer)
 	if err != nil {
-		fmt.Printf("transfer.go:accountTransfer: ReadAll error:%v\n", err)
 		return AccountTransferResult{}, err
 	}
 
-	err = resp.Body.Close()
+	accountTransferResult := AccountTransferResult{}
+	dec := json.NewDecoder(bytes.NewReader(respBody))
+	err = dec.Decode(&accountTransferResult)
 	if err != nil {
-		fmt.Printf("transfer.go:accountTransfer: Body.Close error:%v\n", err)
+		log.WithError(err).WithField("body", string(respBody)).Error("Cannot decode the OKEx transfer result.")
 		return AccountTransferResult{}, err
 	}
 
-	if resp.StatusCode != 200 {
-		fmt.Printf("Status Code error: expected= 200, received=%d\n", resp.StatusCode)
-		fmt.Printf("body=%s\n", string(respBody))
-		return AccountTransferResult{}, errors.New("transfer.go:accountTransfer: status code error")
+	return accountTransferResult, nil
+}
+
+// Ask OKEx whether the transfer with the given client_oid has happened.
+func lookupTransfer(client *okex.Client, clientOID string) ([]byte, bool, error) {
+	respBody, err := client.Do("GET", "/api/account/v3/transfer/state?client_oid="+url.QueryEscape(clientOID), "")
+	if err != nil {
+		return nil, false, err
 	}
 
 	accountTransferResult := AccountTransferResult{}
-	dec := json.NewDecoder(bytes.NewReader(respBody))
-	err = dec.Decode(&accountTransferResult)
+	err = json.NewDecoder(bytes.NewReader(respBody)).Decode(&accountTransferResult)
 	if err != nil {
-		fmt.Printf("transfer.go:accountTransfer: Wallet JSON decode error: %v\n", err)
-		fmt.Printf("body=%s\n", string(respBody))
-		return AccountTransferResult{}, err
+		return nil, false, err
 	}
 
-	return accountTransferResult, nil
+	return respBody, accountTransferResult.TransferID != "", nil
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bostontrader/okconnect/config"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestFindTransferAccountType(t *testing.T) {
+	bc := config.BookwerxConfig{CatFunding: 1, CatSpotAvailable: 2, CatFutures: 3, CatC2C: 4, CatMargin: 5, CatSwap: 6, CatOptions: 7}
+
+	tests := []struct {
+		name            string
+		code            string // "" if there's no such kind
+		needsInstrument bool
+		needsSubAccount bool
+		category        uint32
+	}{
+		{"funding", "6", false, false, 1},
+		{"6", "6", false, false, 1},
+		{"Funding", "6", false, false, 1},
+		{"spot", "1", false, false, 2},
+		{"1", "1", false, false, 2},
+		{"futures", "3", false, false, 3},
+		{"c2c", "4", false, false, 4},
+		{"margin", "5", true, false, 5},
+		{"5", "5", true, false, 5},
+		{"swap", "9", false, false, 6},
+		{"options", "12", false, false, 7},
+		{"12", "12", false, false, 7},
+		{"sub-account", "0", false, true, 1},
+		{"0", "0", false, true, 1},
+		{"wallet", "", false, false, 0},
+		{"2", "", false, false, 0},
+		{"", "", false, false, 0},
+	}
+	for _, tt := range tests {
+		got, ok := findTransferAccountType(tt.name)
+		if ok != (tt.code != "") {
+			t.Errorf("findTransferAccountType(%q) found = %v", tt.name, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.code != tt.code || got.needsInstrument != tt.needsInstrument || got.needsSubAccount != tt.needsSubAccount {
+			t.Errorf("findTransferAccountType(%q) = {%s %v %v}, want {%s %v %v}", tt.name,
+				got.code, got.needsInstrument, got.needsSubAccount, tt.code, tt.needsInstrument, tt.needsSubAccount)
+		}
+		if c := got.category(bc); c != tt.category {
+			t.Errorf("findTransferAccountType(%q): category = %d, want %d", tt.name, c, tt.category)
+		}
+	}
+
+	// Every code is different, or else a legacy code would be ambiguous.
+	codes := make(map[string]string)
+	for name, tat := range transferAccountTypes {
+		if other, ok := codes[tat.code]; ok {
+			t.Errorf("%s and %s both have code %s", name, other, tat.code)
+		}
+		codes[tat.code] = name
+	}
+}
+
+// Every transfer here has an unparseable quantity, so one that gets past the validation of its source and destination
+// stops there instead, before it talks to OKEx or Bookwerx.
+func TestTransferValidation(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.BookwerxConfig = config.BookwerxConfig{CatFunding: 1, CatSpotAvailable: 2, CatMargin: 5}
+	cfg.OKExConfig.Accounts = []config.AccountConfig{{Name: "sub1", CatFunding: 31, CatSpotAvailable: 32}}
+
+	const valid = "Cannot parse the quantity"
+	tests := []struct {
+		name         string
+		from         string
+		to           string
+		instrument   string
+		toInstrument string
+		subAccount   string
+		fromAccount  string
+		toAccount    string
+		want         string
+	}{
+		{"by name", "funding", "spot", "", "", "", "", "", valid},
+		{"by code", "6", "1", "", "", "", "", "", valid},
+		{"unknown source", "wallet", "spot", "", "", "", "", "", "The transferFrom parameter must be one of"},
+		{"unknown destination", "funding", "99", "", "", "", "", "", "The transferTo parameter must be one of"},
+		{"same", "funding", "6", "", "", "", "", "", "are the same"},
+		{"no category", "funding", "futures", "", "", "", "", "", "does not say which category"},
+
+		{"margin", "funding", "margin", "BTC-USDT", "", "", "", "", valid},
+		{"margin by code", "5", "6", "BTC-USDT", "", "", "", "", valid},
+		{"margin without instrument", "funding", "margin", "", "", "", "", "", "A margin account is per instrument"},
+		{"margin by code without instrument", "5", "1", "", "", "", "", "", "A margin account is per instrument"},
+		{"margin to margin", "margin", "margin", "BTC-USDT", "ETH-USDT", "", "", "", valid},
+		{"margin to margin without to_instrument", "margin", "margin", "BTC-USDT", "", "", "", "", "A margin account is per instrument"},
+		{"margin to the same margin", "margin", "margin", "BTC-USDT", "BTC-USDT", "", "", "", "are the same"},
+
+		{"sub-account", "funding", "sub-account", "", "", "sub1", "", "", valid},
+		{"sub-account by code", "0", "6", "", "", "sub1", "", "", valid},
+		{"sub-account without name", "funding", "sub-account", "", "", "", "", "", "Say which sub-account"},
+		{"sub-account by code without name", "0", "6", "", "", "", "", "", "Say which sub-account"},
+		{"unknown sub-account", "funding", "sub-account", "", "", "sub2", "", "", "does not have this sub-account"},
+		{"main as sub-account", "funding", "sub-account", "", "", config.MainAccount, "", "", "does not have this sub-account"},
+		{"sub-account and to_account", "funding", "sub-account", "", "", "sub1", "", "sub1", "not both"},
+		{"sub-account of a sub-account", "funding", "sub-account", "", "", "sub1", "sub1", "sub1", "main account's credentials"},
+
+		{"to a sub-account", "funding", "funding", "", "", "", "", "sub1", valid},
+		{"within a sub-account", "funding", "spot", "", "", "", "sub1", "sub1", valid},
+		{"unknown account", "funding", "funding", "", "", "", "", "sub2", "The config does not have this account"},
+	}
+	for _, tt := range tests {
+		fromAccount, toAccount := tt.fromAccount, tt.toAccount
+		if fromAccount == "" {
+			fromAccount = config.MainAccount
+		}
+		if toAccount == "" {
+			toAccount = config.MainAccount
+		}
+		currency, quan, clientOID := "BTC", "x", ""
+		from, to, instrument, toInstrument, subAccount := tt.from, tt.to, tt.instrument, tt.toInstrument, tt.subAccount
+
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		Transfer(cfg, &currency, &from, &to, &quan, &clientOID, &fromAccount, &toAccount, &instrument, &toInstrument, &subAccount)
+		log.SetOutput(os.Stderr)
+
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("%s: logged %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/compare"
+	okchttp "github.com/bostontrader/okconnect/http"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// How many of the recent ledger entries and distributions to show.
+const recent = 10
+
+// An entry of an OKEx ledger, whether of the funding account or of a spot account.
+type ledgerEntry struct {
+	LedgerID  string `json:"ledger_id"`
+	Timestamp string `json:"timestamp"`
+	Amount    string `json:"amount"`
+	Balance   string `json:"balance"`
+	Typename  string `json:"typename"` // the funding ledger
+	Type      string `json:"type"`     // the spot ledgers
+}
+
+// What we know about a single comparison, once drilled into.
+type detail struct {
+	row        row
+	ledger     []ledgerEntry
+	ledgerNote string // why there's no ledger to show
+	dists      []bookwerx.AccountDistribution
+	distsNote  string // why there are no distributions to show
+}
+
+// The OKEx ledger of whatever the comparison is about, if there's one that we can show.
+func ledgerEndpoint(c compare.Comparison) string {
+	switch c.Category {
+	case "F":
+		return fmt.Sprintf("/api/account/v3/ledger?currency=%s&limit=%d", c.CurrencySymbol, recent)
+	case "Spot-Available", "Spot-Hold", compare.SpotTotal:
+		return fmt.Sprintf("/api/spot/v3/accounts/%s/ledger?limit=%d", c.CurrencySymbol, recent)
+	}
+	return ""
+}
+
+// Get the recent OKEx ledger entries and Bookwerx distributions of the selected row.
+func (m *model) openDetail() {
+	if m.cursor >= len(m.rows) {
+		return
+	}
+	r := m.rows[m.cursor]
+	d := &detail{row: r}
+
+	// 1. ... from OKEx
+	endpoint := ledgerEndpoint(r.c)
+	if endpoint == "" {
+		d.ledgerNote = "OKEx has no ledger of this that we can show."
+	} else {
+		body, err := r.account.client.Do("GET", endpoint, "")
+		if err == nil {
+			err = json.NewDecoder(bytes.NewReader(body)).Decode(&d.ledger)
+		}
+		if err != nil {
+			log.WithError(err).WithField("endpoint", endpoint).Error("Cannot get the OKEx ledger.")
+			d.ledgerNote = "Cannot get the OKEx ledger."
+		}
+	}
+
+	// 2. ... from Bookwerx
+	if r.c.AccountID == 0 {
+		d.distsNote = "Bookwerx has no account for this."
+	} else {
+		clientB := okchttp.GetHeimdallClient("bookwerx", r.account.cfg.BookwerxConfig.BaseURL, 5000*time.Millisecond)
+		dists, err := bookwerx.RecentDistributions(clientB, r.c.AccountID, recent, *r.account.cfg)
+		if err != nil {
+			d.distsNote = "Cannot get the Bookwerx distributions."
+		}
+		d.dists = dists
+	}
+
+	m.detail = d
+	m.screen = screenDetail
+}
+
+// The lines of the OKEx side of the detail.
+func (d *detail) ledgerLines() []string {
+	if d.ledgerNote != "" {
+		return []string{d.ledgerNote}
+	}
+	if len(d.ledger) == 0 {
+		return []string{"Nothing."}
+	}
+	retVal := make([]string, 0, len(d.ledger))
+	for _, e := range d.ledger {
+		kind := e.Typename
+		if kind == "" {
+			kind = e.Type
+		}
+		retVal = append(retVal, fmt.Sprintf("%-19.19s %-12.12s %14s = %s", e.Timestamp, kind, e.Amount, e.Balance))
+	}
+	return retVal
+}
+
+// The lines of the Bookwerx side of the detail.
+func (d *detail) distLines() []string {
+	if d.distsNote != "" {
+		return []string{d.distsNote}
+	}
+	if len(d.dists) == 0 {
+		return []string{"Nothing."}
+	}
+	retVal := make([]string, 0, len(d.dists))
+	for _, dist := range d.dists {
+		amount := bookwerx.DFP{Amount: dist.Amount, Exp: dist.AmountExp}.Decimal()
+		retVal = append(retVal, fmt.Sprintf("%-19.19s %14s %s", dist.Time, amount.String(), dist.Notes))
+	}
+	return retVal
+}
@@ -0,0 +1,206 @@
+package tui
+
+import (
+	"fmt"
+	"github.com/bostontrader/okconnect/compare"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+)
+
+// The ANSI escape codes that we use.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l" // use the alternate screen and hide the cursor
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	home        = "\x1b[H\x1b[2J"
+	reset       = "\x1b[0m"
+	bold        = "\x1b[1m"
+	dim         = "\x1b[2m"
+	reverse     = "\x1b[7m"
+	green       = "\x1b[32m"
+	yellow      = "\x1b[33m"
+	red         = "\x1b[31m"
+)
+
+func statusColour(status string) string {
+	switch status {
+	case compare.StatusMatch:
+		return green
+	case compare.StatusTolerated:
+		return yellow
+	}
+	return red
+}
+
+// Cut or pad the text to exactly the given width.
+func fit(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+func balance(b compare.MaybeBalance) string {
+	if b.Nil {
+		return "-"
+	}
+	return b.Balance.String()
+}
+
+// Draw the whole screen, with whatever was logged last on the bottom line.
+func (m *model) draw() {
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	var lines []string
+	switch m.screen {
+	case screenList:
+		lines = m.drawList(width, height-1)
+	case screenDetail:
+		lines = m.drawDetail(width)
+	case screenProposal:
+		lines = m.drawProposal(width)
+	case screenTransfer:
+		lines = m.drawForm(width)
+	}
+
+	var b strings.Builder
+	b.WriteString(home)
+	for i := 0; i < height-1; i++ {
+		if i < len(lines) {
+			b.WriteString(lines[i])
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString(dim + fit(m.status.String(), width) + reset)
+	os.Stdout.WriteString(b.String())
+}
+
+func (m *model) rowText(account, category, currency, okex, bookwerx, difference, status string) string {
+	s := fmt.Sprintf("%-16s %-8s %18s %18s %18s  %s", category, currency, okex, bookwerx, difference, status)
+	if len(m.accounts) > 1 {
+		s = fmt.Sprintf("%-10s ", account) + s
+	}
+	return s
+}
+
+func (m *model) drawList(width int, height int) []string {
+	lines := []string{
+		bold + fit(fmt.Sprintf("OKConnect: %d comparisons as of %s", len(m.rows), m.refreshed.Format("15:04:05")), width) + reset,
+		fit("up/down move   enter details   r refresh   t transfer   q quit", width),
+		"",
+		bold + fit(m.rowText("Account", "Category", "Currency", "OKEx", "Bookwerx", "Difference", "Status"), width) + reset,
+	}
+	if len(m.rows) == 0 {
+		return append(lines, "Nothing to compare.")
+	}
+
+	// Scroll so that the selected row can be seen.
+	visible := height - len(lines)
+	if visible < 1 {
+		visible = 1
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+visible {
+		m.offset = m.cursor - visible + 1
+	}
+
+	for i := m.offset; i < len(m.rows) && i < m.offset+visible; i++ {
+		r := m.rows[i]
+		text := fit(m.rowText(r.c.Account, r.category(), r.c.CurrencySymbol, balance(r.c.OKExBalance),
+			balance(r.c.BookwerxBalance), r.c.Difference().String(), r.status), width)
+		colour := statusColour(r.status)
+		if i == m.cursor {
+			colour += reverse
+		}
+		lines = append(lines, colour+text+reset)
+	}
+	return lines
+}
+
+// The recent OKEx ledger entries on the left, next to the recent Bookwerx distributions on the right.
+func (m *model) drawDetail(width int) []string {
+	d := m.detail
+	c := d.row.c
+	title := fmt.Sprintf("%s %s", d.row.category(), c.CurrencySymbol)
+	if c.Account != "" {
+		title = c.Account + " " + title
+	}
+
+	lines := []string{
+		bold + fit(title, width) + reset,
+		fit("r refresh   p propose an adjustment   t transfer   esc back", width),
+		"",
+		fit(fmt.Sprintf("OKEx:       %s", balance(c.OKExBalance)), width),
+		fit(fmt.Sprintf("Bookwerx:   %s   (account %d)", balance(c.BookwerxBalance), c.AccountID), width),
+		statusColour(d.row.status) + fit(fmt.Sprintf("Difference: %s   %s", c.Difference().String(), d.row.status), width) + reset,
+		"",
+	}
+
+	half := (width - 3) / 2
+	lines = append(lines, bold+fit("Recent OKEx ledger entries", half)+" | "+fit("Recent Bookwerx distributions", half)+reset)
+	left, right := d.ledgerLines(), d.distLines()
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := "", ""
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		lines = append(lines, fit(l, half)+" | "+fit(r, half))
+	}
+	return lines
+}
+
+func (m *model) drawProposal(width int) []string {
+	p := m.proposal
+	lines := []string{
+		bold + fit("Proposed adjustment", width) + reset,
+		"",
+		fit(p.Transaction.Notes, width),
+		"",
+	}
+	for _, l := range p.Transaction.Legs {
+		side := "DR"
+		if l.Amount.IsNegative() {
+			side = "CR"
+		}
+		lines = append(lines, fit(fmt.Sprintf("%s  account %-8d %18s %s", side, l.AccountID, l.Amount.Abs().String(), l.Currency), width))
+	}
+	return append(lines, "", fit("y post it to Bookwerx   any other key cancel", width))
+}
+
+func (m *model) drawForm(width int) []string {
+	f := m.form
+	title, within := "Transfer", ""
+	if f.account != "" {
+		title, within = "Transfer within "+f.account, " within "+f.account
+	}
+	lines := []string{
+		bold + fit(title, width) + reset,
+		fit("up/down/tab move   enter transfer   esc cancel", width),
+		"",
+	}
+	for i, fl := range f.fields {
+		text := fmt.Sprintf("%-12s %s", fl.label+":", fl.value)
+		if i == f.focus {
+			lines = append(lines, reverse+fit(text+"_", width)+reset)
+		} else {
+			lines = append(lines, fit(text, width))
+		}
+	}
+	lines = append(lines, "", fit("From and To are funding, spot, futures, c2c, margin, swap, or options.  A margin account needs the instrument.", width))
+
+	if f.confirm {
+		v := func(i int) string { return strings.TrimSpace(f.fields[i].value) }
+		lines = append(lines, "", bold+fit(fmt.Sprintf("Transfer %s %s from %s to %s%s?  y yes   any other key no",
+			v(fieldQuan), v(fieldCurrency), v(fieldFrom), v(fieldTo), within), width)+reset)
+	}
+	return lines
+}
@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"strings"
+)
+
+// The fields of the transfer form.
+const (
+	fieldCurrency = iota
+	fieldFrom
+	fieldTo
+	fieldQuan
+	fieldInstrument
+)
+
+type field struct {
+	label string
+	value string
+}
+
+type form struct {
+	account string // the name of the OKEx account to transfer within, "" if there's only one
+	fields  []field
+	focus   int
+	confirm bool // Has the user asked to transfer, so that we must now ask whether they're sure?
+}
+
+// What okconnect transfer calls the kind of OKEx account that a comparison is about.
+func transferName(category string) string {
+	switch {
+	case category == "F":
+		return "funding"
+	case strings.HasPrefix(category, "Spot"):
+		return "spot"
+	case strings.HasPrefix(category, "Margin"):
+		return "margin"
+	case category == "Futures":
+		return "futures"
+	case category == "Swap":
+		return "swap"
+	}
+	return "funding"
+}
+
+// Open the transfer form, starting with the OKEx account, the currency, and the kind of account of the selected row.
+func (m *model) openTransfer() {
+	account, currency, from, instrument := "", "", "funding", ""
+	if m.cursor < len(m.rows) {
+		r := m.rows[m.cursor]
+		account, currency, from, instrument = r.account.name, r.c.CurrencySymbol, transferName(r.c.Category), r.c.Instrument
+	}
+
+	m.form = &form{account: account, fields: []field{
+		{"Currency", currency},
+		{"From", from},
+		{"To", ""},
+		{"Quantity", ""},
+		{"Instrument", instrument},
+	}}
+	m.back, m.screen = m.screen, screenTransfer
+}
+
+func (m *model) handleForm(k string) {
+	f := m.form
+
+	// Once asked, y transfers and anything else goes back to the form.
+	if f.confirm {
+		f.confirm = false
+		if k == "y" {
+			v := func(i int) string { return strings.TrimSpace(f.fields[i].value) }
+			m.transfer(m.cfg, f.account, v(fieldCurrency), v(fieldFrom), v(fieldTo), v(fieldQuan), v(fieldInstrument))
+			m.refresh()
+			m.screen = screenList
+		}
+		return
+	}
+
+	switch k {
+	case keyEsc:
+		m.screen = m.back
+	case keyUp:
+		f.focus = (f.focus + len(f.fields) - 1) % len(f.fields)
+	case keyDown, keyTab:
+		f.focus = (f.focus + 1) % len(f.fields)
+	case keyEnter:
+		f.confirm = true
+	case keyBackspace:
+		r := []rune(f.fields[f.focus].value)
+		if len(r) > 0 {
+			f.fields[f.focus].value = string(r[:len(r)-1])
+		}
+	default:
+		if len(k) == 1 && k[0] >= ' ' && k[0] < 0x7f {
+			f.fields[f.focus].value += k
+		}
+	}
+}
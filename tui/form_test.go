@@ -0,0 +1,40 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+)
+
+func TestOpenTransfer(t *testing.T) {
+	sub1 := &account{name: "sub1"}
+	m := &model{rows: []row{
+		{account: &account{name: "main"}, c: compare.Comparison{Category: "F", CurrencySymbol: "USDT"}},
+		{account: sub1, c: compare.Comparison{Category: "Margin-Available", CurrencySymbol: "BTC", Instrument: "BTC-USDT"}},
+	}, cursor: 1}
+
+	m.openTransfer()
+	f := m.form
+	if f.account != "sub1" || f.fields[fieldCurrency].value != "BTC" || f.fields[fieldFrom].value != "margin" || f.fields[fieldInstrument].value != "BTC-USDT" {
+		t.Fatalf("form = %+v", f)
+	}
+
+	// The user must be able to see which account the money moves in.
+	f.fields[fieldTo].value, f.fields[fieldQuan].value, f.confirm = "funding", "1", true
+	lines := strings.Join(m.drawForm(200), "\n")
+	if !strings.Contains(lines, "Transfer within sub1") || !strings.Contains(lines, "from margin to funding within sub1?") {
+		t.Errorf("drawForm = %s", lines)
+	}
+
+	// And the money moves in that account.
+	var got []string
+	m.transfer = func(cfg *config.Config, account string, currency string, from string, to string, quan string, instrument string) {
+		got = []string{account, currency, from, to, quan, instrument}
+	}
+	m.handleForm("y")
+	if strings.Join(got, " ") != "sub1 BTC margin funding 1 BTC-USDT" {
+		t.Errorf("transfer = %v", got)
+	}
+}
@@ -0,0 +1,284 @@
+// The purpose of this package is to review the comparisons in a terminal, instead of reading the JSON of compare.
+//
+// It lists every category and currency with its status in colour, and drills into any of them to show the recent
+// OKEx ledger entries next to the recent Bookwerx distributions of its account.  From there it can refresh, propose an
+// adjustment, or make a transfer.  It draws using nothing but ANSI escape codes.
+package tui
+
+import (
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/okex"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Make a transfer, just as okconnect transfer does, within the named OKEx account, or the main account if it's "".  From
+// and to are kinds of OKEx account, such as funding.
+type TransferFunc func(cfg *config.Config, account string, currency string, from string, to string, quan string, instrument string)
+
+// The screens.
+const (
+	screenList = iota
+	screenDetail
+	screenProposal
+	screenTransfer
+)
+
+// The keys that aren't merely characters.
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyEnter     = "enter"
+	keyEsc       = "esc"
+	keyTab       = "tab"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+)
+
+// An OKEx account to compare.
+type account struct {
+	name   string // "" if there's only one
+	cfg    *config.Config
+	client *okex.Client
+}
+
+// A single comparison, as listed.
+type row struct {
+	account *account
+	c       compare.Comparison
+	status  string
+}
+
+func (r row) key() string {
+	return r.c.Account + ":" + r.c.Key()
+}
+
+// The category of the row, and its margin instrument if it has one.
+func (r row) category() string {
+	if r.c.Instrument == "" {
+		return r.c.Category
+	}
+	return r.c.Category + " " + r.c.Instrument
+}
+
+type model struct {
+	cfg      *config.Config
+	accounts []*account
+	transfer TransferFunc
+	status   *statusLine
+
+	rows      []row
+	cursor    int // the selected row
+	offset    int // the first row shown
+	refreshed time.Time
+
+	screen   int
+	back     int // the screen to go back to from the transfer form
+	detail   *detail
+	proposal *compare.Proposal
+	form     *form
+	quit     bool
+}
+
+// Keep the last line that's logged, in order to show it at the bottom of the screen.
+type statusLine struct {
+	mu   sync.Mutex
+	last string
+}
+
+func (s *statusLine) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimRight(string(p), "\n"), "\n")
+	s.mu.Lock()
+	s.last = lines[len(lines)-1]
+	s.mu.Unlock()
+	return len(p), nil
+}
+
+func (s *statusLine) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Review the comparisons until the user quits.
+//
+// Example:
+// okconnect tui -config okconnect.yaml
+func Run(cfg *config.Config, transfer TransferFunc) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !terminal.IsTerminal(in) || !terminal.IsTerminal(out) {
+		log.Error("okconnect tui needs a terminal.")
+		return
+	}
+
+	// 1. Read the credentials of every OKEx account.
+	m := &model{cfg: cfg, transfer: transfer, status: &statusLine{}}
+	all := cfg.AllAccounts()
+	for _, a := range all {
+		cfgA := cfg.ForAccount(a)
+		credentials, err := config.ReadCredentials(cfgA.OKExConfig.Credentials)
+		if err != nil {
+			log.WithField("account", a.Name).Error("Cannot read the OKEx credentials file.")
+			return
+		}
+		name := ""
+		if len(all) > 1 {
+			name = a.Name
+		}
+		m.accounts = append(m.accounts, &account{name, cfgA, okex.NewClient(*cfgA, *credentials)})
+	}
+
+	// 2. Take over the terminal, and show whatever is logged on the status line instead.
+	state, err := terminal.MakeRaw(in)
+	if err != nil {
+		log.WithError(err).Error("Cannot use the terminal.")
+		return
+	}
+	defer terminal.Restore(in, state)
+	os.Stdout.WriteString(enterScreen)
+	defer os.Stdout.WriteString(leaveScreen)
+
+	logOut := log.StandardLogger().Out
+	log.SetOutput(m.status)
+	defer log.SetOutput(logOut)
+
+	// 3. Draw, wait for a key, and do what it says, until it says to quit.
+	m.refresh()
+	buf := make([]byte, 16)
+	for !m.quit {
+		m.draw()
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		m.handle(key(buf[:n]))
+	}
+}
+
+// What key was that?  A single read gets the entire escape sequence of an arrow key.
+func key(b []byte) string {
+	switch s := string(b); s {
+	case "\x1b[A", "\x1bOA":
+		return keyUp
+	case "\x1b[B", "\x1bOB":
+		return keyDown
+	case "\r", "\n":
+		return keyEnter
+	case "\x1b":
+		return keyEsc
+	case "\t":
+		return keyTab
+	case "\x7f", "\x08":
+		return keyBackspace
+	case "\x03":
+		return keyCtrlC
+	default:
+		return s
+	}
+}
+
+func (m *model) handle(k string) {
+	if k == keyCtrlC {
+		m.quit = true
+		return
+	}
+
+	switch m.screen {
+	case screenList:
+		switch k {
+		case keyUp, "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case keyDown, "j":
+			if m.cursor < len(m.rows)-1 {
+				m.cursor++
+			}
+		case keyEnter:
+			m.openDetail()
+		case "r":
+			m.refresh()
+		case "t":
+			m.openTransfer()
+		case "q", keyEsc:
+			m.quit = true
+		}
+
+	case screenDetail:
+		switch k {
+		case "r":
+			m.refresh()
+			m.openDetail()
+		case "p":
+			m.openProposal()
+		case "t":
+			m.openTransfer()
+		case "q", keyEsc:
+			m.screen = screenList
+		}
+
+	case screenProposal:
+		if k == "y" {
+			err := m.proposal.Post()
+			if err == nil {
+				m.refresh()
+			}
+		}
+		m.proposal = nil
+		m.openDetail()
+
+	case screenTransfer:
+		m.handleForm(k)
+	}
+}
+
+// Compare every account again, and keep the same row selected if it's still there.
+func (m *model) refresh() {
+	selected := ""
+	if m.cursor < len(m.rows) {
+		selected = m.rows[m.cursor].key()
+	}
+
+	rows := make([]row, 0)
+	for _, a := range m.accounts {
+		comparisons, err := compare.Comparisons(a.cfg, a.client)
+		if err != nil {
+			log.WithField("account", a.name).Error("Cannot compare this account.")
+			return
+		}
+		for _, c := range comparisons {
+			c.Account = a.name
+			rows = append(rows, row{a, c, c.Status(a.cfg.CompareConfig)})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].key() < rows[j].key() })
+
+	m.rows, m.cursor, m.refreshed = rows, 0, time.Now()
+	for i, r := range rows {
+		if r.key() == selected {
+			m.cursor = i
+		}
+	}
+}
+
+// Propose an adjustment for the mismatch at hand and ask whether to post it.
+func (m *model) openProposal() {
+	r := m.detail.row
+	if r.status == compare.StatusMatch {
+		log.Info("These balances match.  There's nothing to adjust.")
+		return
+	}
+
+	proposals, err := compare.Propose(r.account.cfg, r.account.client, []compare.Comparison{r.c})
+	if err != nil || len(proposals) == 0 {
+		return
+	}
+	m.proposal = &proposals[0]
+	m.screen = screenProposal
+}
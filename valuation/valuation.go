@@ -0,0 +1,350 @@
+// The purpose of this package is to say what all of our balances at OKEx are worth in a single quote currency, such as
+// USDT, at the current market prices.
+//
+// OKEx does not list a pair for every combination of currencies, so when there is no direct pair we route the price
+// through USDT or BTC.  For example, the price of LTC in USDT might be the price of LTC-BTC times that of BTC-USDT.
+package valuation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bostontrader/okconnect/bookwerx"
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	okchttp "github.com/bostontrader/okconnect/http"
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// If there is no direct pair then try to route the price through these currencies, in this order.
+var viaCurrencies = []string{"USDT", "BTC"}
+
+// Round the values to this many decimal places.
+const places = 8
+
+// What a single currency is worth.
+type CurrencyValue struct {
+	Currency   string
+	Balance    decimal.Decimal // Summed across the funding, spot, and margin accounts, less what's borrowed
+	Price      decimal.Decimal // In the quote currency
+	Route      []string        `json:",omitempty"` // The instruments whose tickers gave us the price
+	Value      decimal.Decimal
+	Priced     bool // Did we find a price?  If not then the Value is not counted in the Total.
+	Reconciled bool // Does Bookwerx agree with OKEx, within tolerance, about every balance in this currency?
+}
+
+type Valuation struct {
+	Quote      string
+	Time       string
+	Currencies []CurrencyValue
+	Total      decimal.Decimal
+}
+
+// The part of /api/spot/v3/instruments/<instrument_id>/ticker that we care about.
+type ticker struct {
+	InstrumentID string `json:"instrument_id"`
+	Last         string `json:"last"`
+}
+
+// A pricer gets the tickers from OKEx and remembers them, including the ones that don't exist.
+type pricer struct {
+	client  *okex.Client
+	tickers map[string]*decimal.Decimal // by instrument_id, nil if there is no such instrument
+}
+
+// Value all of our OKEx balances in the given quote currency and print the result in the given format: table or json.
+// If book is true then also book the change in value, since the last time we did this, as an unrealised gain or loss.
+//
+// Example:
+// okconnect valuation -quote USDT -config okconnect.yaml
+func Value(cfg *config.Config, quote string, format string, book bool) {
+	quote = strings.ToUpper(quote)
+
+	// 1. Read the credentials file for OKEx
+	credentials, err := config.ReadCredentials(cfg.OKExConfig.Credentials)
+	if err != nil {
+		log.Error("Cannot read the OKEx credentials file.")
+		return
+	}
+	client := okex.NewClient(*cfg, *credentials)
+
+	// 2. Get the balances, as compare sees them.
+	comparisons, err := compare.Comparisons(cfg, client)
+	if err != nil {
+		return
+	}
+
+	// 3. Value them.
+	v, err := value(client, quote, comparisons, cfg.CompareConfig)
+	if err != nil {
+		return
+	}
+
+	err = printValuation(v, format)
+	if err != nil {
+		log.WithError(err).WithField("format", format).Error("Cannot print the valuation.")
+		return
+	}
+
+	// 4. Perhaps book the change in value.
+	if book {
+		_ = bookRevaluation(cfg, v)
+	}
+}
+
+// Sum the OKEx balances for each currency and value them in the quote currency.
+func value(client *okex.Client, quote string, comparisons []compare.Comparison, cc config.CompareConfig) (Valuation, error) {
+
+	// 1. Sum the balances for each currency.
+	byCurrency := make(map[string]*CurrencyValue)
+	for _, c := range comparisons {
+		cv, ok := byCurrency[c.CurrencySymbol]
+		if !ok {
+			cv = &CurrencyValue{Currency: c.CurrencySymbol, Reconciled: true}
+			byCurrency[c.CurrencySymbol] = cv
+		}
+		if !c.OKExBalance.Nil {
+			if c.Category == compare.MarginBorrowed {
+				cv.Balance = cv.Balance.Sub(c.OKExBalance.Balance)
+			} else {
+				cv.Balance = cv.Balance.Add(c.OKExBalance.Balance)
+			}
+		}
+		if c.Status(cc) == compare.StatusMismatch {
+			cv.Reconciled = false
+		}
+	}
+
+	// 2. Price each currency that we actually have.
+	v := Valuation{Quote: quote, Time: time.Now().UTC().Format(bookwerx.TimeFormat), Currencies: make([]CurrencyValue, 0)}
+	p := &pricer{client: client, tickers: make(map[string]*decimal.Decimal)}
+	for _, cv := range byCurrency {
+		if cv.Balance.IsZero() {
+			continue
+		}
+
+		price, route, err := p.price(cv.Currency, quote)
+		if err != nil {
+			return Valuation{}, err
+		}
+		if route == nil && cv.Currency != quote {
+			log.WithFields(log.Fields{"currency": cv.Currency, "quote": quote}).Warn("Cannot find a price.  Leaving it out of the total.")
+		} else {
+			cv.Price = price
+			cv.Route = route
+			cv.Value = cv.Balance.Mul(price).Round(places)
+			cv.Priced = true
+			v.Total = v.Total.Add(cv.Value)
+		}
+		if !cv.Reconciled {
+			log.WithField("currency", cv.Currency).Warn("Bookwerx does not agree with OKEx about this currency.  Run compare to see why.")
+		}
+
+		v.Currencies = append(v.Currencies, *cv)
+	}
+
+	sort.Slice(v.Currencies, func(i, j int) bool { return v.Currencies[i].Currency < v.Currencies[j].Currency })
+	return v, nil
+}
+
+// Find the price of the currency in the quote currency, and the instruments used to find it.  If there is no price to
+// be found then return a nil route.
+func (p *pricer) price(currency string, quote string) (decimal.Decimal, []string, error) {
+	if currency == quote {
+		return decimal.New(1, 0), []string{}, nil
+	}
+
+	// 1. Is there a direct, or an inverse, pair?
+	price, route, err := p.pair(currency, quote)
+	if err != nil || route != nil {
+		return price, route, err
+	}
+
+	// 2. No.  Go the long way around.
+	for _, via := range viaCurrencies {
+		if via == currency || via == quote {
+			continue
+		}
+
+		price1, route1, err := p.pair(currency, via)
+		if err != nil {
+			return decimal.Zero, nil, err
+		}
+		if route1 == nil {
+			continue
+		}
+
+		price2, route2, err := p.pair(via, quote)
+		if err != nil {
+			return decimal.Zero, nil, err
+		}
+		if route2 == nil {
+			continue
+		}
+
+		return price1.Mul(price2), append(route1, route2...), nil
+	}
+
+	return decimal.Zero, nil, nil
+}
+
+// Find the price of base in quote using the base-quote instrument, or else the quote-base instrument upside down.
+func (p *pricer) pair(base string, quote string) (decimal.Decimal, []string, error) {
+	last, err := p.ticker(base + "-" + quote)
+	if err != nil || last != nil {
+		if last == nil {
+			return decimal.Zero, nil, err
+		}
+		return *last, []string{base + "-" + quote}, nil
+	}
+
+	last, err = p.ticker(quote + "-" + base)
+	if err != nil || last == nil {
+		return decimal.Zero, nil, err
+	}
+	if last.IsZero() {
+		return decimal.Zero, nil, nil
+	}
+	return decimal.New(1, 0).Div(*last), []string{quote + "-" + base}, nil
+}
+
+// Get the last price of the given instrument, or nil if there is no such instrument.
+func (p *pricer) ticker(instrumentID string) (*decimal.Decimal, error) {
+	if last, ok := p.tickers[instrumentID]; ok {
+		return last, nil
+	}
+
+	body, err := p.client.Do("GET", "/api/spot/v3/instruments/"+instrumentID+"/ticker", "")
+	if err != nil {
+		// OKEx says so with this error code when there is no such instrument.  Anything else, even another 4xx such as
+		// a 401 or a 429, is a real problem that must not quietly drop the currency from the total.
+		var okErr *okex.Error
+		if errors.As(err, &okErr) && okErr.Code == okex.ErrCodeNoSuchInstrument {
+			p.tickers[instrumentID] = nil
+			return nil, nil
+		}
+		log.WithError(err).WithField("instrument_id", instrumentID).Error("Cannot get the OKEx ticker.")
+		return nil, err
+	}
+
+	t := ticker{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&t)
+	if err != nil {
+		log.WithError(err).WithField("instrument_id", instrumentID).Error("Cannot decode the OKEx ticker.")
+		return nil, err
+	}
+
+	last, err := decimal.NewFromString(t.Last)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"instrument_id": instrumentID, "last": t.Last}).Error("Cannot parse the last price.")
+		return nil, err
+	}
+
+	p.tickers[instrumentID] = &last
+	return &last, nil
+}
+
+// Book the change in the value of everything other than the quote currency itself, since the last revaluation, as an
+// unrealised gain or loss.  The account tagged with CatRevaluation, in the quote currency, holds the value as of the
+// last revaluation, so the change is the difference between the value now and its balance.
+func bookRevaluation(cfg *config.Config, v Valuation) error {
+	bc := cfg.BookwerxConfig
+	rlog := log.WithField("quote", v.Quote)
+
+	// 1. Make sure that we have everything we need.
+	if bc.CatRevaluation == 0 || bc.CatUnrealisedGain == 0 {
+		rlog.Error("The config must say which categories are cat_revaluation and cat_unrealised_gain in order to book a revaluation.")
+		return errors.New("valuation: the revaluation categories are not configured")
+	}
+
+	value := decimal.Zero
+	for _, cv := range v.Currencies {
+		if cv.Currency == v.Quote {
+			continue
+		}
+		if !cv.Priced {
+			rlog.WithField("currency", cv.Currency).Error("Cannot book a revaluation without a price for every currency.")
+			return errors.New("valuation: not every currency has a price")
+		}
+		value = value.Add(cv.Value)
+	}
+
+	// 2. What did we say it was worth last time?
+	sums, err := bookwerx.CategoryDistSums(*cfg, bc.CatRevaluation, "", "")
+	if err != nil {
+		rlog.WithError(err).Error("Cannot get the revaluation balance from Bookwerx.")
+		return err
+	}
+
+	booked := decimal.Zero
+	for _, brd := range sums {
+		if brd.Account.Currency.Symbol == v.Quote {
+			booked = booked.Add(brd.Sum.Decimal())
+		}
+	}
+
+	delta := value.Sub(booked).Round(places)
+	rlog = rlog.WithFields(log.Fields{"value": value.String(), "booked": booked.String(), "delta": delta.String()})
+	if delta.IsZero() {
+		rlog.Info("Nothing has changed since the last revaluation.")
+		return nil
+	}
+
+	// 3. Find the accounts before we write anything.
+	clientB := okchttp.GetHeimdallClient("bookwerx", bc.BaseURL, 5000*time.Millisecond)
+	revaluationID, err := bookwerx.FindCategoryAccount(clientB, bc.CatRevaluation, v.Quote, *cfg)
+	if err != nil {
+		return err
+	}
+	gainID, err := bookwerx.FindCategoryAccount(clientB, bc.CatUnrealisedGain, v.Quote, *cfg)
+	if err != nil {
+		return err
+	}
+
+	// 4. DR the revaluation account and CR the unrealised gain, or the other way around for a loss.
+	tx := bookwerx.NewTransaction(v.Time, fmt.Sprintf("Revaluation in %s", v.Quote))
+	tx.Add(revaluationID, v.Quote, delta).Add(gainID, v.Quote, delta.Neg())
+	txid, err := tx.Post(clientB, *cfg)
+	if err != nil {
+		return err
+	}
+
+	rlog.WithField("transaction_id", txid).Info("Booked the revaluation.")
+	return nil
+}
+
+func printValuation(v Valuation, format string) error {
+	switch format {
+	case "table":
+		fmt.Printf("Valuation in %s as of %s\n\n", v.Quote, v.Time)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "Currency\tBalance\tPrice\tValue\tRoute\tReconciled")
+		for _, cv := range v.Currencies {
+			price, value := "?", "?"
+			if cv.Priced {
+				price, value = cv.Price.Round(places).String(), cv.Value.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", cv.Currency, cv.Balance.String(), price, value, strings.Join(cv.Route, " "), cv.Reconciled)
+		}
+		fmt.Fprintf(w, "Total\t\t\t%s\t\t\n", v.Total.String())
+		return w.Flush()
+
+	case "json":
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	return errors.New("unknown format")
+}
@@ -0,0 +1,56 @@
+package valuation
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bostontrader/okconnect/okex"
+	"github.com/shopspring/decimal"
+)
+
+func TestTicker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.Split(r.URL.Path, "/")[5] {
+		case "BTC-USDT":
+			fmt.Fprint(w, `{"instrument_id":"BTC-USDT","last":"10000"}`)
+		case "USDT-BTC":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":30032,"error_code":"30032","message":"The currency pair does not exist"}`)
+		case "LTC-USDT":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":30006,"error_code":"30006","message":"Invalid OK-ACCESS-KEY"}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	p := &pricer{client: &okex.Client{BaseURL: server.URL, HTTPClient: server.Client()}, tickers: make(map[string]*decimal.Decimal)}
+
+	last, err := p.ticker("BTC-USDT")
+	if err != nil || last == nil || !last.Equal(decimal.New(10000, 0)) {
+		t.Errorf("BTC-USDT: ticker = %v, %v, want 10000", last, err)
+	}
+
+	last, err = p.ticker("USDT-BTC")
+	if err != nil || last != nil {
+		t.Errorf("USDT-BTC: ticker = %v, %v, want no such instrument", last, err)
+	}
+	if cached, ok := p.tickers["USDT-BTC"]; !ok || cached != nil {
+		t.Errorf("USDT-BTC: not remembered as missing")
+	}
+
+	// Other errors are not the same as a missing instrument, so they're not remembered either.
+	for _, id := range []string{"LTC-USDT", "BSV-USDT"} {
+		last, err = p.ticker(id)
+		if err == nil {
+			t.Errorf("%s: ticker = %v, want an error", id, last)
+		}
+		if _, ok := p.tickers[id]; ok {
+			t.Errorf("%s: remembered after an error", id)
+		}
+	}
+}
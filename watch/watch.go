@@ -0,0 +1,194 @@
+// The purpose of this package is to keep Bookwerx in step with OKEx, without anybody having to remember to do so.
+//
+// OKEx has no push notification of balance changes, so every so often we book whatever is new in the OKEx ledgers,
+// just like okconnect sync ledger, and then compare the balances, just like okconnect compare, and send any alerts
+// that calls for.  The cursors remember where we are so that we can be stopped and started again at any time.
+//
+// We can also listen to the OKEx WebSocket and start a cycle as soon as it tells us that something has happened.  The
+// cycle still uses the REST API, so anything that happened while the WebSocket was down is booked just the same.
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bostontrader/okconnect/alert"
+	"github.com/bostontrader/okconnect/compare"
+	"github.com/bostontrader/okconnect/config"
+	"github.com/bostontrader/okconnect/journal"
+	"github.com/bostontrader/okconnect/ledger"
+	"github.com/bostontrader/okconnect/lots"
+	"github.com/bostontrader/okconnect/metrics"
+	"github.com/bostontrader/okconnect/okex"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Keep Bookwerx in step with OKEx until we receive SIGINT or SIGTERM.  After each cycle print the mismatches found by
+// compare as a JSON array, one line per cycle.  A signal lets the current transaction finish, then skips the rest of
+// the cycle and stops.
+//
+// Example:
+// okconnect watch -interval 1m -config okconnect.yaml
+// okconnect watch -websocket -interval 10m -metrics-addr :9100 -config okconnect.yaml
+func Watch(cfg *config.Config, interval time.Duration, backfill bool, websocket bool, metricsAddr string) {
+
+	// 1. Read the credentials of every OKEx account.  Only the main account's ledgers are booked, but every account is
+	// compared.
+	all := cfg.AllAccounts()
+	accounts := make([]account, 0, len(all))
+	for _, a := range all {
+		cfgA := cfg.ForAccount(a)
+		credentials, err := config.ReadCredentials(cfgA.OKExConfig.Credentials)
+		if err != nil {
+			log.WithField("account", a.Name).Error("Cannot read the OKEx credentials file.")
+			return
+		}
+		name := ""
+		if len(all) > 1 {
+			name = a.Name
+		}
+		accounts = append(accounts, account{a.Name, name, cfgA, okex.NewClient(*cfgA, *credentials)})
+	}
+
+	// 2. Where did we stop last time?
+	cursors, err := ledger.OpenCursors(cfg.Cursors)
+	if err != nil {
+		return
+	}
+
+	client := accounts[0].client
+	syncer := ledger.NewSyncer(cfg, client, cursors)
+	syncer.Backfill = backfill
+	syncer.Lots, err = lots.Open(cfg.LotsConfig)
+	if err != nil {
+		return
+	}
+
+	// 3. Stop when asked to.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.WithField("received", sig.String()).Info("Stopping after the current transaction.")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// 4. Perhaps tell Prometheus how we're doing.
+	if metricsAddr != "" {
+		metrics.Serve(metricsAddr)
+	}
+
+	// 5. Perhaps listen for news.
+	events := make(chan okex.Event, 100)
+	if websocket {
+		if cfg.OKExConfig.WSURL == "" {
+			log.Error("The config does not say where the OKEx WebSocket is.  Set okexconfig.ws_url.")
+			return
+		}
+		go okex.NewStream(*cfg, client).Run(ctx, events)
+	}
+
+	log.WithFields(log.Fields{"interval": interval.String(), "websocket": websocket}).Info("Watching.")
+
+	// 6. Go around and around.
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cycle(ctx, cfg, accounts, syncer)
+
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped.")
+			return
+		case <-ticker.C:
+		case e := <-events:
+			log.WithFields(log.Fields{"event": e.Kind, "channel": e.Channel, "order_id": e.OrderID}).Info("OKEx says something has happened.")
+
+			// Events tend to arrive in bunches.  One cycle will take care of all of them.
+			for len(events) > 0 {
+				<-events
+			}
+		}
+	}
+}
+
+// An OKEx account to compare, with its own credentials and categories.
+type account struct {
+	label  string // what the metrics call it, such as main
+	name   string // what the comparisons call it, or "" if there's only one account
+	cfg    *config.Config
+	client *okex.Client
+}
+
+// Book whatever is new and then compare every account.  An error is merely logged because the next cycle may well
+// work.
+func cycle(ctx context.Context, cfg *config.Config, accounts []account, syncer *ledger.Syncer) {
+	start := time.Now()
+
+	journalBacklog(cfg)
+
+	booked, err := syncer.Sync(ctx)
+	if err != nil {
+		log.WithError(err).WithField("booked", booked).Error("Cannot book everything in the OKEx ledgers.  Will try again next time.")
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	metrics.LastSync.Set(float64(time.Now().Unix()))
+
+	comparisons := make([]compare.Comparison, 0)
+	labels := make([]string, 0) // of the account of each comparison
+	mismatches := make([]compare.Comparison, 0)
+	for _, a := range accounts {
+		found, err := compare.Comparisons(a.cfg, a.client)
+		if err != nil {
+			log.WithError(err).WithField("account", a.label).Error("Cannot compare the balances.  Will try again next time.")
+			return
+		}
+		for _, c := range found {
+			c.Account = a.name
+			comparisons = append(comparisons, c)
+			labels = append(labels, a.label)
+			if c.Status(a.cfg.CompareConfig) == compare.StatusMismatch {
+				mismatches = append(mismatches, c)
+			}
+		}
+	}
+
+	// Every comparison says how far apart its balances are, whether they match or not.
+	metrics.BalanceDifference.Zero()
+	for i, c := range comparisons {
+		diff, _ := c.Difference().Abs().Float64()
+		metrics.BalanceDifference.Set(diff, labels[i], c.Category, c.Instrument, c.CurrencySymbol)
+	}
+
+	retVal, _ := json.Marshal(mismatches)
+	fmt.Println(string(retVal))
+
+	_ = alert.Check(cfg, mismatches)
+
+	log.WithFields(log.Fields{"booked": booked, "mismatches": len(mismatches), "latency": time.Since(start).String()}).Info("Cycle complete.")
+}
+
+// Count the requests in the journal that still need attention.  Other commands, such as transfer, write to the journal
+// too, so read it afresh every time.
+func journalBacklog(cfg *config.Config) {
+	jrnl, err := journal.Open(cfg.Journal)
+	if err != nil {
+		return
+	}
+	for _, state := range []string{journal.StatePending, journal.StateDone} {
+		metrics.JournalBacklog.Set(float64(len(jrnl.InState(state))), state)
+	}
+}